@@ -11,11 +11,14 @@ import (
 	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"pdm-logic-server/pkg/blob"
 	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/keys"
 	"pdm-logic-server/pkg/config"
 	"pdm-logic-server/pkg/db"
 	"pdm-logic-server/pkg/health"
 	"pdm-logic-server/pkg/metrics"
+	appmiddleware "pdm-logic-server/pkg/middleware"
 	"pdm-logic-server/pkg/models"
 	"pdm-logic-server/pkg/services"
 	"sync"
@@ -30,6 +33,8 @@ type App struct {
 	health      *health.HealthChecker
 	storage     *services.Storage
 	authService *services.AuthService
+	reminders   *services.ReminderScheduler
+	stopJobs    context.CancelFunc
 }
 
 func NewApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
@@ -50,18 +55,24 @@ func NewApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
 	//	return nil, err
 	//}
 
-	cache, err := initCache(cfg, logger)
+	cache, err := initCache(cfg, logger, metricsCollector)
 	if err != nil {
 		return nil, err
 	}
 
 	// Initialize RabbitMQ connection
-	rabbitMQCtx, err := services.NewRabbitMQHandler()
+	rabbitMQCtx, err := services.NewRabbitMQHandler(&cfg.RabbitMQ)
 	if err != nil {
 		return nil, err
 	}
 
-	storage := services.NewStorage(db.DB, rabbitMQCtx, cache)
+	// Initialize blob storage for attachments
+	blobStore, err := blob.NewBlobStore(&cfg.Blob)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := services.NewStorage(db.DB, rabbitMQCtx, cache, blobStore, &cfg.Stampede)
 
 	// Create health checker
 	healthChecker := health.NewHealthChecker(db, cache)
@@ -76,6 +87,7 @@ func NewApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
 		health:      healthChecker,
 		storage:     storage,
 		authService: authService,
+		reminders:   services.NewReminderScheduler(storage, cfg),
 	}
 
 	// Setup everything
@@ -109,6 +121,22 @@ func (a *App) Start() error {
 		return fmt.Errorf("preflight checks failed: %w", err)
 	}
 
+	// Start background workers
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	a.stopJobs = stopJobs
+	if a.config.Reminders.Enabled {
+		go a.reminders.Run(jobsCtx)
+	}
+	go a.storage.Ch.Listen(jobsCtx)
+	go a.storage.R.ConsumeSyncNotices(jobsCtx, services.SyncNoticeHandlers{
+		RejectedNotes: func(rejected services.RejectedNotes) {
+			a.storage.DropRejectedNotes(jobsCtx, rejected)
+		},
+		DeletedAttachments: func(deleted services.DeletedAttachments) {
+			a.storage.RemoveDeletedAttachments(jobsCtx, deleted)
+		},
+	})
+
 	// Start server
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		a.logger.WithError(err).Errorf("failed to start server: %s", err.Error())
@@ -121,11 +149,16 @@ func (a *App) Start() error {
 func (a *App) Shutdown(ctx context.Context) error {
 	a.logger.Info("Initiating graceful shutdown...")
 
+	// Stop background workers
+	if a.stopJobs != nil {
+		a.stopJobs()
+	}
+
 	// Create a WaitGroup to track all shutdown operations
 	var wg sync.WaitGroup
 
 	// Create error channel to collect errors from goroutines
-	errChan := make(chan error, 3)
+	errChan := make(chan error, 4)
 
 	// Shutdown HTTP server
 	wg.Add(1)
@@ -161,6 +194,19 @@ func (a *App) Shutdown(ctx context.Context) error {
 		a.logger.Info("Database connections closed")
 	}()
 
+	// Close the RabbitMQ connection, so it is not restored
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.logger.Info("Closing RabbitMQ connection...")
+
+		if err := a.storage.R.Close(); err != nil {
+			errChan <- fmt.Errorf("error closing RabbitMQ connection: %w", err)
+			return
+		}
+		a.logger.Info("RabbitMQ connection closed")
+	}()
+
 	// Close cache connections
 	wg.Add(1)
 	go func() {
@@ -279,16 +325,72 @@ func migrateDB(db *gorm.DB) error {
 	}
 	log.Println("Migration for RefreshKey completed!")
 
+	if err := db.AutoMigrate(&models.NoteAttachment{}); err != nil {
+		log.Fatalf("Failed to migrate database schema: %v", err)
+	}
+	log.Println("Migration for NoteAttachment completed!")
+
+	if err := db.AutoMigrate(&models.NoteShare{}); err != nil {
+		log.Fatalf("Failed to migrate database schema: %v", err)
+	}
+	log.Println("Migration for NoteShare completed!")
+
+	if err := db.AutoMigrate(&models.PublicLink{}); err != nil {
+		log.Fatalf("Failed to migrate database schema: %v", err)
+	}
+	log.Println("Migration for PublicLink completed!")
+
+	if err := db.AutoMigrate(&models.NoteReminder{}); err != nil {
+		log.Fatalf("Failed to migrate database schema: %v", err)
+	}
+	log.Println("Migration for NoteReminder completed!")
+
+	if err := db.AutoMigrate(&models.NoteLink{}); err != nil {
+		log.Fatalf("Failed to migrate database schema: %v", err)
+	}
+	log.Println("Migration for NoteLink completed!")
+
 	log.Println("Database migration completed!")
 
 	return nil
 }
 
-func initCache(cfg *config.Config, logger *logrus.Logger) (*cache.RedisCache, error) {
+func initCache(cfg *config.Config, logger *logrus.Logger, m *metrics.Metrics) (*cache.RedisCache, error) {
 
 	// Initialize Redis client
-	redisClient := cache.NewRedisClient(&cfg.Redis)
-	cacheLayer := cache.NewCache(redisClient)
+	var redisClient cache.RedisClient
+	switch cfg.Redis.Backend {
+	case "redis":
+		client, err := cache.NewRedisClient(&cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		redisClient = client
+	case "memory":
+		logger.Warn("Using the in-memory cache; it is not shared, so run a single instance only")
+		redisClient = cache.NewMemoryClient()
+	default:
+		return nil, fmt.Errorf("unknown REDIS_BACKEND %q", cfg.Redis.Backend)
+	}
+	if cfg.Metrics.Enabled {
+		redisClient = cache.NewInstrumentedClient(redisClient, m)
+	}
+
+	schema := keys.NewSchema(&cfg.CacheKeys)
+
+	// The in-memory backend is already local
+	if !cfg.LocalCache.Enabled || cfg.Redis.Backend == "memory" {
+		return cache.NewCache(redisClient, schema), nil
+	}
+	families := []cache.LocalFamily{
+		{Pattern: schema.UserIDs(), TTL: cfg.LocalCache.UserIDTTL},
+		{Pattern: schema.UserInfo("*"), TTL: cfg.LocalCache.UserInfoTTL},
+	}
+	var observer cache.Observer
+	if cfg.Metrics.Enabled {
+		observer = m
+	}
+	cacheLayer := cache.NewTieredCache(redisClient, schema, cache.NewLocalCache(cfg.LocalCache.MaxBytes), families, cfg.LocalCache.Channel, observer)
 
 	return cacheLayer, nil
 }
@@ -327,6 +429,15 @@ func (a *App) setupMiddleware() {
 	if a.config.Metrics.Enabled {
 		a.echo.Use(a.metrics.Middleware())
 	}
+
+	// Compress responses the client accepts compressed
+	if a.config.Compression.Enabled {
+		var observe appmiddleware.CompressionObserver
+		if a.config.Metrics.Enabled {
+			observe = a.metrics.ObserveCompression
+		}
+		a.echo.Use(appmiddleware.CreateCompressionMiddleware(&a.config.Compression, observe))
+	}
 }
 
 // generateNonce creates a random nonce
@@ -1,7 +1,9 @@
 package app
 
 import (
+	"fmt"
 	"github.com/labstack/echo/v4"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"pdm-logic-server/pkg/handlers"
 	"pdm-logic-server/pkg/middleware"
@@ -29,6 +31,15 @@ func (a *App) setupRoutes() {
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(baseHandler)
 	notesHandler := handlers.NewNotesHandler(baseHandler)
+	attachmentsHandler := handlers.NewAttachmentsHandler(baseHandler)
+	sharesHandler := handlers.NewSharesHandler(baseHandler)
+	publicLinksHandler := handlers.NewPublicLinksHandler(baseHandler)
+	exportHandler := handlers.NewExportHandler(baseHandler)
+	jobsHandler := handlers.NewJobsHandler(baseHandler)
+	importHandler := handlers.NewImportHandler(baseHandler)
+	remindersHandler := handlers.NewRemindersHandler(baseHandler)
+	linksHandler := handlers.NewLinksHandler(baseHandler)
+	adminHandler := handlers.NewAdminHandler(baseHandler)
 	statusHandler := handlers.NewStatusHandler(baseHandler, a.config.StaticContent.StatusPassword)
 	statusHandler.SetupRenderer(a.echo, a.config.StaticContent.InternalPath)
 
@@ -41,18 +52,70 @@ func (a *App) setupRoutes() {
 	a.echo.POST("/signup", userHandler.Register)
 	a.echo.POST("/signup/verify", userHandler.ValidateVerificationCode)
 	a.echo.GET("/status/*", statusHandler.StatusHandlerFunc)
+	a.echo.GET("/attachments/:attachmentId", attachmentsHandler.Download, middleware.RenderAppErrors)
+	a.echo.GET("/p/:token", publicLinksHandler.ViewLink)
+	a.echo.POST("/p/:token", publicLinksHandler.ViewLink)
+	a.echo.GET("/exports/:jobId", exportHandler.Download, middleware.RenderAppErrors)
 
 	// Protected routes
 	api := a.echo.Group("/api")
+	api.Use(middleware.RenderAppErrors)
 	api.Use(middleware.CreateJWTMiddleware(a.config.Auth.PublicKey))
+	api.Use(middleware.CreateIdempotencyMiddleware(a.storage.Ch, &a.config.Idempotency))
 
 	// User routes
 	api.GET("/user/logout", userHandler.Logout)
 	api.GET("/user", userHandler.GetUserInfo)
+	api.GET("/user/usage", userHandler.GetUsage)
 
 	// Notes routes
 	api.GET("/notes", notesHandler.GetNotes)
+	noteBodyLimit := echomiddleware.BodyLimit(fmt.Sprintf("%dB", a.config.Quota.MaxRequestBytes))
 	api.POST("/notes", notesHandler.CreateNote)
-	api.PUT("/notes", notesHandler.UpdateNotes)
+	api.PUT("/notes", notesHandler.UpdateNotes, noteBodyLimit)
+	api.PATCH("/notes/:id", notesHandler.PatchNote, noteBodyLimit)
 	api.DELETE("/notes", notesHandler.DeleteNotes)
+	api.POST("/notes/batch", notesHandler.BatchNotes, noteBodyLimit)
+
+	// Sharing routes
+	api.GET("/notes/shared", sharesHandler.SharedWithMe)
+	api.POST("/notes/:id/shares", sharesHandler.ShareNote)
+	api.GET("/notes/:id/shares", sharesHandler.ListShares)
+	api.DELETE("/notes/:id/shares/:shareId", sharesHandler.DeleteShare)
+
+	// Public link routes
+	api.POST("/notes/:id/links", publicLinksHandler.CreateLink)
+	api.GET("/notes/:id/links", publicLinksHandler.ListLinks)
+	api.DELETE("/notes/:id/links/:linkId", publicLinksHandler.RevokeLink)
+
+	// Link routes
+	api.GET("/notes/graph", linksHandler.Graph)
+	api.GET("/notes/:id/backlinks", linksHandler.Backlinks)
+
+	// Reminder routes
+	api.POST("/notes/:id/reminders", remindersHandler.CreateReminder)
+	api.GET("/notes/:id/reminders", remindersHandler.ListReminders)
+	api.DELETE("/notes/:id/reminders/:reminderId", remindersHandler.DeleteReminder)
+
+	// Export, import and job routes
+	api.GET("/export", exportHandler.Export)
+	api.GET("/export/:jobId/url", exportHandler.ExportURL)
+	api.POST("/import", importHandler.Import)
+	api.GET("/jobs/:jobId", jobsHandler.GetJob)
+
+	// Attachment routes
+	api.POST("/notes/:id/attachments", attachmentsHandler.CreateAttachment)
+	api.GET("/notes/:id/attachments", attachmentsHandler.ListAttachments)
+	api.GET("/notes/:id/attachments/:attachmentId", attachmentsHandler.GetAttachment)
+	api.PUT("/notes/:id/attachments/:attachmentId/content", attachmentsHandler.UploadChunk)
+	api.GET("/notes/:id/attachments/:attachmentId/url", attachmentsHandler.DownloadURL)
+	api.DELETE("/notes/:id/attachments/:attachmentId", attachmentsHandler.DeleteAttachment)
+
+	// Admin routes
+	admin := a.echo.Group("/admin")
+	admin.Use(middleware.RenderAppErrors)
+	admin.Use(middleware.CreateAdminMiddleware(a.config.Admin.Token))
+	admin.POST("/integrity/audit", adminHandler.StartIntegrityAudit)
+	admin.GET("/integrity/audit/:jobId/report", adminHandler.IntegrityReport)
+	admin.GET("/jobs/:jobId", adminHandler.GetJob)
 }
@@ -0,0 +1,37 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"pdm-logic-server/pkg/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore is the storage backend for binary objects such as note
+// attachments. Keys are slash separated paths, e.g. "attachments/<user>/<id>".
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	// size may be -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key. Returns ErrNotFound if missing.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys starting with prefix in lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NewBlobStore builds the backend selected by cfg.Backend.
+func NewBlobStore(cfg *config.BlobConfig) (BlobStore, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocalStore(cfg.LocalPath)
+	case "s3":
+		return NewS3Store(cfg)
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,226 @@
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"pdm-logic-server/pkg/blob"
+	"pdm-logic-server/pkg/config"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every backend runs the same tests. The S3 ones run against the MinIO, or
+// other S3-compatible service, at BLOB_TEST_S3_ENDPOINT, with
+// BLOB_TEST_S3_ACCESS_KEY and BLOB_TEST_S3_SECRET_KEY (minioadmin by
+// default), and are skipped when it is unset or does not answer. Their keys
+// start with a prefix of their own and are removed afterwards.
+//
+//	BLOB_TEST_S3_ENDPOINT=localhost:9000 go test ./pkg/blob
+
+func TestLocalStore(t *testing.T) {
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	runStoreTests(t, store, "")
+}
+
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("BLOB_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("BLOB_TEST_S3_ENDPOINT is not set")
+	}
+	cfg := &config.BlobConfig{
+		Backend:     "s3",
+		S3Endpoint:  endpoint,
+		S3AccessKey: envOrDefault("BLOB_TEST_S3_ACCESS_KEY", "minioadmin"),
+		S3SecretKey: envOrDefault("BLOB_TEST_S3_SECRET_KEY", "minioadmin"),
+		S3Bucket:    envOrDefault("BLOB_TEST_S3_BUCKET", "pdm-blob-test"),
+		S3Region:    "us-east-1",
+	}
+	store, err := blob.NewS3Store(cfg)
+	if err != nil {
+		t.Skipf("no S3 endpoint at %s: %v", endpoint, err)
+	}
+
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, err := store.List(ctx, prefix)
+		if err != nil {
+			t.Logf("Failed to list test blobs: %v", err)
+		}
+		for _, key := range keys {
+			if err := store.Delete(ctx, key); err != nil {
+				t.Logf("Failed to delete test blob %s: %v", key, err)
+			}
+		}
+	})
+	runStoreTests(t, store, prefix)
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// runStoreTests runs the backend tests on store with keys below prefix
+func runStoreTests(t *testing.T, store blob.BlobStore, prefix string) {
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, store, prefix) })
+	t.Run("List", func(t *testing.T) { testList(t, store, prefix) })
+	t.Run("Multipart", func(t *testing.T) { testMultipart(t, store, prefix) })
+}
+
+func readBlob(t *testing.T, store blob.BlobStore, key string) []byte {
+	t.Helper()
+	rc, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q) error = %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading %q: %v", key, err)
+	}
+	return data
+}
+
+func testRoundTrip(t *testing.T, store blob.BlobStore, prefix string) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		key     string
+		content []byte
+		size    int64
+	}{
+		{name: "known size", key: "roundtrip/a", content: []byte("hello"), size: 5},
+		{name: "unknown size", key: "roundtrip/b", content: bytes.Repeat([]byte("x"), 100000), size: -1},
+		{name: "empty", key: "roundtrip/c", content: []byte{}, size: 0},
+		{name: "replaced", key: "roundtrip/a", content: []byte("replaced"), size: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := prefix + tt.key
+			if err := store.Put(ctx, key, bytes.NewReader(tt.content), tt.size, "application/octet-stream"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if got := readBlob(t, store, key); !bytes.Equal(got, tt.content) {
+				t.Errorf("Get() = %d bytes, want %d", len(got), len(tt.content))
+			}
+		})
+	}
+
+	if err := store.Delete(ctx, prefix+"roundtrip/a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(ctx, prefix+"roundtrip/a"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, prefix+"roundtrip/missing"); err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+}
+
+func testList(t *testing.T, store blob.BlobStore, prefix string) {
+	ctx := context.Background()
+	for _, key := range []string{"list/a/2", "list/a/1", "list/a/sub/3", "list/ab/4", "list/b/5"} {
+		if err := store.Put(ctx, prefix+key, strings.NewReader(key), int64(len(key)), "text/plain"); err != nil {
+			t.Fatalf("Put(%q) error = %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "list/a/", want: []string{"list/a/1", "list/a/2", "list/a/sub/3"}},
+		{prefix: "list/a", want: []string{"list/a/1", "list/a/2", "list/a/sub/3", "list/ab/4"}},
+		{prefix: "list/b/", want: []string{"list/b/5"}},
+		{prefix: "list/missing/"},
+		{prefix: "nothing/at/all/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := store.List(ctx, prefix+tt.prefix)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			for i := range got {
+				got[i] = strings.TrimPrefix(got[i], prefix)
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testMultipart stores an upload the way attachments are, in parts beside
+// the final key, then assembles the parts into the final object
+func testMultipart(t *testing.T, store blob.BlobStore, prefix string) {
+	ctx := context.Background()
+	key := prefix + "attachments/u1/a1"
+	content := bytes.Repeat([]byte("0123456789"), 2500)
+
+	const partSize = 10000
+	for offset := 0; offset < len(content); offset += partSize {
+		part := content[offset:min(offset+partSize, len(content))]
+		partKey := fmt.Sprintf("%s.parts/%020d", key, offset)
+		if err := store.Put(ctx, partKey, bytes.NewReader(part), int64(len(part)), "application/octet-stream"); err != nil {
+			t.Fatalf("Put(%q) error = %v", partKey, err)
+		}
+	}
+
+	parts, err := store.List(ctx, key+".parts/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("List() = %v, want 3 parts", parts)
+	}
+	var assembled bytes.Buffer
+	for _, part := range parts {
+		assembled.Write(readBlob(t, store, part))
+	}
+	if err := store.Put(ctx, key, &assembled, int64(len(content)), "application/octet-stream"); err != nil {
+		t.Fatalf("Put() of the assembled object error = %v", err)
+	}
+	for _, part := range parts {
+		if err := store.Delete(ctx, part); err != nil {
+			t.Fatalf("Delete(%q) error = %v", part, err)
+		}
+	}
+
+	if got := readBlob(t, store, key); !bytes.Equal(got, content) {
+		t.Errorf("assembled object is %d bytes, want %d", len(got), len(content))
+	}
+	if left, err := store.List(ctx, key+".parts/"); err != nil || len(left) != 0 {
+		t.Errorf("List() of the parts after assembly = %v, %v, want none", left, err)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	for _, key := range []string{"", ".", "../outside", "a/../../outside"} {
+		if err := store.Put(context.Background(), key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Put(%q) error = nil, want an invalid key", key)
+		}
+	}
+}
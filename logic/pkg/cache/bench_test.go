@@ -0,0 +1,204 @@
+package cache_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"pdm-logic-server/pkg/cache"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The benchmarks compare reading a user's cached notes the old way, a KEYS
+// scan of the whole keyspace plus a GET per note, with the per-user index,
+// SMEMBERS plus one pipelined MGET, and counting keys with KEYS against
+// SCAN. They need a Redis they can write to, see testRedis; every key they
+// create starts with "bench:" and is removed once the package's tests are
+// done.
+//
+//	REDIS_TEST_ADDR=localhost:6379 go test ./pkg/cache -run '^$' -bench . -users 100000 -notes 5
+
+var (
+	benchUsers = flag.Int("users", 100000, "number of users the benchmarks populate")
+	benchNotes = flag.Int("notes", 5, "cached notes per user in the benchmarks")
+)
+
+const benchPrefix = "bench:"
+
+var (
+	populateOnce sync.Once
+	populateErr  error
+	populated    *redis.Client
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if populated != nil {
+		if err := cleanupBench(context.Background(), populated); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove benchmark keys: %v\n", err)
+		}
+	}
+	os.Exit(code)
+}
+
+func benchNoteKey(userID, noteID string) string {
+	return fmt.Sprintf("%suser:%s:note:%s", benchPrefix, userID, noteID)
+}
+
+func benchNoteIndexKey(userID string) string {
+	return fmt.Sprintf("%suser:%s:notes", benchPrefix, userID)
+}
+
+func benchUserID(i int) string {
+	return fmt.Sprintf("%08d-0000-4000-8000-000000000000", i)
+}
+
+func benchNoteID(user, n int) string {
+	return fmt.Sprintf("%08d-%04d-4000-8000-000000000000", user, n)
+}
+
+// populateBench writes the benchmark notes and their indexes, once per run
+func populateBench(b *testing.B, rdb *redis.Client) {
+	b.Helper()
+	populateOnce.Do(func() {
+		populated = rdb
+		populateErr = populate(context.Background(), rdb, *benchUsers, *benchNotes)
+	})
+	if populateErr != nil {
+		b.Fatalf("Failed to populate Redis: %v", populateErr)
+	}
+	b.ResetTimer()
+}
+
+func populate(ctx context.Context, rdb *redis.Client, users, notes int) error {
+	value := `{"content":"` + strings.Repeat("x", 256) + `"}`
+
+	pipe := rdb.Pipeline()
+	for u := 0; u < users; u++ {
+		ids := make([]interface{}, notes)
+		for n := 0; n < notes; n++ {
+			ids[n] = benchNoteID(u, n)
+			pipe.Set(ctx, benchNoteKey(benchUserID(u), benchNoteID(u, n)), value, 0)
+		}
+		pipe.SAdd(ctx, benchNoteIndexKey(benchUserID(u)), ids...)
+
+		if u%1000 == 999 || u == users-1 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func cleanupBench(ctx context.Context, rdb *redis.Client) error {
+	iter := rdb.Scan(ctx, 0, benchPrefix+"*", 1000).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 1000 {
+			if err := rdb.Unlink(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return rdb.Unlink(ctx, batch...).Err()
+	}
+	return nil
+}
+
+// readWithKeys is how notes were read before the index
+func readWithKeys(ctx context.Context, rdb *redis.Client, user string) (int, error) {
+	keys, err := rdb.Keys(ctx, fmt.Sprintf("%suser:%s:note:*", benchPrefix, user)).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := rdb.Get(ctx, key).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+func readWithIndex(ctx context.Context, client cache.RedisClient, user string) (int, error) {
+	ids, err := client.SMembers(ctx, benchNoteIndexKey(user))
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = benchNoteKey(user, id)
+	}
+	values, err := client.MGet(ctx, keys...)
+	return len(values), err
+}
+
+func BenchmarkGetNotes(b *testing.B) {
+	ctx := context.Background()
+	rdb, client := testRedis(b)
+
+	readers := []struct {
+		name string
+		read func(user string) (int, error)
+	}{
+		{name: "KEYS+GET", read: func(user string) (int, error) { return readWithKeys(ctx, rdb, user) }},
+		{name: "SMEMBERS+MGET", read: func(user string) (int, error) { return readWithIndex(ctx, client, user) }},
+	}
+	for _, r := range readers {
+		b.Run(r.name, func(b *testing.B) {
+			populateBench(b, rdb)
+			for i := 0; i < b.N; i++ {
+				n, err := r.read(benchUserID(rand.Intn(*benchUsers)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != *benchNotes {
+					b.Fatalf("read %d notes, want %d", n, *benchNotes)
+				}
+			}
+		})
+	}
+}
+
+// KEYS blocks Redis for the whole scan; SCAN holds it for one batch at a time
+func BenchmarkCountKeys(b *testing.B) {
+	ctx := context.Background()
+	rdb, client := testRedis(b)
+	pattern := fmt.Sprintf("%suser:%s:note:*", benchPrefix, benchUserID(0))
+
+	counters := []struct {
+		name  string
+		count func() (int64, error)
+	}{
+		{name: "KEYS", count: func() (int64, error) {
+			keys, err := rdb.Keys(ctx, pattern).Result()
+			return int64(len(keys)), err
+		}},
+		{name: "SCAN", count: func() (int64, error) { return client.CountKeys(ctx, pattern) }},
+	}
+	for _, c := range counters {
+		b.Run(c.name, func(b *testing.B) {
+			populateBench(b, rdb)
+			for i := 0; i < b.N; i++ {
+				n, err := c.count()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != int64(*benchNotes) {
+					b.Fatalf("counted %d keys, want %d", n, *benchNotes)
+				}
+			}
+		})
+	}
+}
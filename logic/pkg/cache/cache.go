@@ -3,19 +3,23 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"pdm-logic-server/pkg/cache/keys"
 	"time"
 )
 
 type Cache interface {
 	// Basic operations
 	Get(ctx context.Context, key string) (string, error)
+	MGet(ctx context.Context, keys ...string) ([]string, error)
 	Set(ctx context.Context, key string, value string, ttl time.Duration) error
 	Delete(ctx context.Context, key string) error
 
 	// Map-like operations
 	HSet(ctx context.Context, key, field, value string) error
+	HSetAll(ctx context.Context, key string, fields map[string]string) error
 	HGet(ctx context.Context, key, field string) (string, error)
 	HDel(ctx context.Context, key string, fields ...string) error
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
 
 	// Set-like operations
 	SAdd(ctx context.Context, key string, members ...string) error
@@ -30,6 +34,8 @@ type Cache interface {
 	IncrWithReset(ctx context.Context, key string, resetAfter time.Duration) (int64, error)
 	Exists(ctx context.Context, key string) (interface{}, interface{})
 	Incr(ctx context.Context, key string) (interface{}, interface{})
+	IncrBy(ctx context.Context, key string, value int64) (int64, error)
+	IncrByIfExists(ctx context.Context, key string, value int64) (int64, bool, error)
 	Expire(ctx context.Context, key string, after time.Duration) interface{}
 	CountKeys(ctx context.Context, pattern string) (int64, error)
 	Keys(ctx context.Context, pattern string) ([]string, error)
@@ -37,11 +43,22 @@ type Cache interface {
 
 type RedisCache struct {
 	client RedisClient
+
+	// Schema builds the keys to use with this cache
+	Schema *keys.Schema
+
+	// Local tier, see NewTieredCache
+	local    *LocalCache
+	families []LocalFamily
+	channel  string
+	origin   string
+	observer Observer
 }
 
-func NewCache(client RedisClient) *RedisCache {
+func NewCache(client RedisClient, schema *keys.Schema) *RedisCache {
 	return &RedisCache{
 		client: client,
+		Schema: schema,
 	}
 }
 
@@ -89,6 +106,9 @@ func (r *RedisCache) SetNX(ctx context.Context, key string, value string, ttl ti
 	if err != nil {
 		return false, err
 	}
+	if success {
+		r.written(ctx, key, nil)
+	}
 	return success, nil
 }
 
@@ -97,6 +117,7 @@ func (r *RedisCache) IncrWithReset(ctx context.Context, key string, resetAfter t
 	if err != nil {
 		return 0, err
 	}
+	r.written(ctx, key, nil)
 
 	if count == 1 {
 		err = r.client.Expire(ctx, key, resetAfter)
@@ -110,28 +131,98 @@ func (r *RedisCache) IncrWithReset(ctx context.Context, key string, resetAfter t
 
 // Basic operations
 func (r *RedisCache) Get(ctx context.Context, key string) (string, error) {
-	return r.client.Get(ctx, key)
+	ttl := r.localTTL(key)
+	if ttl == 0 {
+		return r.client.Get(ctx, key)
+	}
+
+	k := localKey{key: key}
+	value, ok := r.local.get(k)
+	r.lookupLocal("get", key, ok)
+	if ok {
+		return value, nil
+	}
+	epoch := r.local.currentEpoch()
+	value, err := r.client.Get(ctx, key)
+	if err == nil && value != "" {
+		r.local.put(k, value, ttl, epoch)
+	}
+	return value, err
+}
+
+// MGet returns the values of keys in order, "" for missing ones
+func (r *RedisCache) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	return r.client.MGet(ctx, keys...)
 }
 
 func (r *RedisCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
-	return r.client.Set(ctx, key, value, ttl)
+	if err := r.client.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	r.written(ctx, key, nil)
+	return nil
 }
 
 func (r *RedisCache) Delete(ctx context.Context, key string) error {
-	return r.client.Delete(ctx, key)
+	if err := r.client.Delete(ctx, key); err != nil {
+		return err
+	}
+	r.written(ctx, key, nil)
+	return nil
 }
 
 // Map-like operations
 func (r *RedisCache) HSet(ctx context.Context, key, field, value string) error {
-	return r.client.HSet(ctx, key, field, value)
+	if err := r.client.HSet(ctx, key, field, value); err != nil {
+		return err
+	}
+	r.written(ctx, key, &field)
+	return nil
+}
+
+// HSetAll sets every field of fields in one round trip
+func (r *RedisCache) HSetAll(ctx context.Context, key string, fields map[string]string) error {
+	if err := r.client.HSetAll(ctx, key, fields); err != nil {
+		return err
+	}
+	for field := range fields {
+		r.written(ctx, key, &field)
+	}
+	return nil
 }
 
 func (r *RedisCache) HGet(ctx context.Context, key, field string) (string, error) {
-	return r.client.HGet(ctx, key, field)
+	ttl := r.localTTL(key)
+	if ttl == 0 {
+		return r.client.HGet(ctx, key, field)
+	}
+
+	k := localKey{key: key, field: field, hasField: true}
+	value, ok := r.local.get(k)
+	r.lookupLocal("hget", key, ok)
+	if ok {
+		return value, nil
+	}
+	epoch := r.local.currentEpoch()
+	value, err := r.client.HGet(ctx, key, field)
+	if err == nil && value != "" {
+		r.local.put(k, value, ttl, epoch)
+	}
+	return value, err
 }
 
 func (r *RedisCache) HDel(ctx context.Context, key string, fields ...string) error {
-	return r.client.HDel(ctx, key, fields...)
+	if err := r.client.HDel(ctx, key, fields...); err != nil {
+		return err
+	}
+	for i := range fields {
+		r.written(ctx, key, &fields[i])
+	}
+	return nil
+}
+
+func (r *RedisCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.client.HGetAll(ctx, key)
 }
 
 // Set-like operations
@@ -156,9 +247,35 @@ func (r *RedisCache) Exists(ctx context.Context, key string) (interface{}, inter
 }
 
 func (r *RedisCache) Incr(ctx context.Context, key string) (interface{}, interface{}) {
-	return r.client.Incr(ctx, key)
+	count, err := r.client.Incr(ctx, key)
+	if err == nil {
+		r.written(ctx, key, nil)
+	}
+	return count, err
 }
 
 func (r *RedisCache) Expire(ctx context.Context, key string, after time.Duration) interface{} {
-	return r.client.Expire(ctx, key, after)
+	err := r.client.Expire(ctx, key, after)
+	if err == nil {
+		r.written(ctx, key, nil)
+	}
+	return err
+}
+
+func (r *RedisCache) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	count, err := r.client.IncrBy(ctx, key, value)
+	if err == nil {
+		r.written(ctx, key, nil)
+	}
+	return count, err
+}
+
+// IncrByIfExists adds value to the integer at key only if key exists, in one
+// atomic step, and reports whether it did
+func (r *RedisCache) IncrByIfExists(ctx context.Context, key string, value int64) (int64, bool, error) {
+	count, ok, err := r.client.IncrByIfExists(ctx, key, value)
+	if err == nil && ok {
+		r.written(ctx, key, nil)
+	}
+	return count, ok, err
 }
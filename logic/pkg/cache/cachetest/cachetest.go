@@ -0,0 +1,463 @@
+// Package cachetest holds a conformance suite for cache.RedisClient. Every
+// implementation must pass it, and it is written against the behaviour of a
+// real Redis server, so running it against both keeps MemoryClient honest.
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"pdm-logic-server/pkg/cache"
+)
+
+// Case is one conformance check. key namespaces the keys a case uses, so
+// cases never see each other's keys.
+type Case struct {
+	Name string
+	Run  func(ctx context.Context, c cache.RedisClient, key func(string) string) error
+}
+
+// RunCase runs tc against c. All its keys start with prefix and are deleted
+// when it is done, so it is safe on a Redis that holds other data.
+func RunCase(ctx context.Context, c cache.RedisClient, prefix string, tc Case) error {
+	var used []string
+	key := func(name string) string {
+		k := fmt.Sprintf("%s%s:%s", prefix, tc.Name, name)
+		used = append(used, k)
+		return k
+	}
+	defer func() {
+		for _, k := range used {
+			_ = c.Delete(ctx, k)
+		}
+	}()
+
+	return tc.Run(ctx, c, key)
+}
+
+func expect(what string, got, want interface{}) error {
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: got %#v, want %#v", what, got, want)
+	}
+	return nil
+}
+
+// check returns the first error of errs
+func check(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sorted(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+// expiry is kept short so the suite is quick; Redis expires keys to the
+// millisecond
+const expiry = 150 * time.Millisecond
+
+// Cases is the conformance suite
+var Cases = []Case{
+	{"strings", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("s")
+		got, err := c.Get(ctx, k)
+		if err := check(err, expect("missing key", got, "")); err != nil {
+			return err
+		}
+		if err := c.Set(ctx, k, "v1", 0); err != nil {
+			return err
+		}
+		got, err = c.Get(ctx, k)
+		if err := check(err, expect("Get", got, "v1")); err != nil {
+			return err
+		}
+		exists, err := c.Exists(ctx, k)
+		if err := check(err, expect("Exists", exists, true)); err != nil {
+			return err
+		}
+		if err := c.Delete(ctx, k); err != nil {
+			return err
+		}
+		exists, err = c.Exists(ctx, k)
+		return check(err, expect("Exists after Delete", exists, false))
+	}},
+
+	{"mget", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		a, b, h := key("a"), key("b"), key("h")
+		if err := check(c.Set(ctx, a, "1", 0), c.Set(ctx, b, "2", 0), c.HSet(ctx, h, "f", "v")); err != nil {
+			return err
+		}
+		got, err := c.MGet(ctx, a, key("missing"), b, h)
+		if err := check(err, expect("MGet", got, []string{"1", "", "2", ""})); err != nil {
+			return err
+		}
+		got, err = c.MGet(ctx)
+		return check(err, expect("MGet of nothing", len(got), 0))
+	}},
+
+	{"ttl", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("t")
+		ttl, err := c.TTL(ctx, k)
+		if err := check(err, expect("TTL of missing key", ttl, time.Duration(-2))); err != nil {
+			return err
+		}
+		if err := c.Set(ctx, k, "v", 0); err != nil {
+			return err
+		}
+		ttl, err = c.TTL(ctx, k)
+		if err := check(err, expect("TTL without expiry", ttl, time.Duration(-1))); err != nil {
+			return err
+		}
+		if err := c.Expire(ctx, k, time.Hour); err != nil {
+			return err
+		}
+		ttl, err = c.TTL(ctx, k)
+		if err != nil {
+			return err
+		}
+		if ttl <= 59*time.Minute || ttl > time.Hour {
+			return fmt.Errorf("TTL after Expire: got %v, want about 1h", ttl)
+		}
+		if err := c.Set(ctx, k, "v2", 0); err != nil {
+			return err
+		}
+		ttl, err = c.TTL(ctx, k)
+		if err := check(err, expect("TTL after Set", ttl, time.Duration(-1))); err != nil {
+			return err
+		}
+		return c.Expire(ctx, key("missing"), time.Hour)
+	}},
+
+	{"expiry", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		s, h := key("s"), key("h")
+		if err := check(c.Set(ctx, s, "v", expiry), c.HSet(ctx, h, "f", "v"), c.Expire(ctx, h, expiry)); err != nil {
+			return err
+		}
+		got, err := c.Get(ctx, s)
+		if err := check(err, expect("Get before expiry", got, "v")); err != nil {
+			return err
+		}
+		time.Sleep(2 * expiry)
+		got, err = c.Get(ctx, s)
+		if err := check(err, expect("Get after expiry", got, "")); err != nil {
+			return err
+		}
+		exists, err := c.Exists(ctx, h)
+		return check(err, expect("Exists of expired hash", exists, false))
+	}},
+
+	{"setnx", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("lock")
+		ok, err := c.SetNX(ctx, k, "a", expiry)
+		if err := check(err, expect("first SetNX", ok, true)); err != nil {
+			return err
+		}
+		ok, err = c.SetNX(ctx, k, "b", expiry)
+		if err := check(err, expect("second SetNX", ok, false)); err != nil {
+			return err
+		}
+		got, err := c.Get(ctx, k)
+		if err := check(err, expect("value after SetNX", got, "a")); err != nil {
+			return err
+		}
+		time.Sleep(2 * expiry)
+		ok, err = c.SetNX(ctx, k, "c", 0)
+		return check(err, expect("SetNX after expiry", ok, true))
+	}},
+
+	{"counters", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("n")
+		n, err := c.Incr(ctx, k)
+		if err := check(err, expect("Incr of missing key", n, int64(1))); err != nil {
+			return err
+		}
+		n, err = c.IncrBy(ctx, k, 5)
+		if err := check(err, expect("IncrBy", n, int64(6))); err != nil {
+			return err
+		}
+		n, err = c.Decr(ctx, k)
+		if err := check(err, expect("Decr", n, int64(5))); err != nil {
+			return err
+		}
+		n, err = c.DecrBy(ctx, k, 7)
+		if err := check(err, expect("DecrBy", n, int64(-2))); err != nil {
+			return err
+		}
+
+		if err := c.Expire(ctx, k, time.Hour); err != nil {
+			return err
+		}
+		if _, err := c.Incr(ctx, k); err != nil {
+			return err
+		}
+		ttl, err := c.TTL(ctx, k)
+		if err != nil {
+			return err
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("Incr dropped the expiry: TTL %v", ttl)
+		}
+
+		word := key("word")
+		if err := c.Set(ctx, word, "abc", 0); err != nil {
+			return err
+		}
+		if _, err := c.Incr(ctx, word); err == nil {
+			return errors.New("Incr of a non-integer did not fail")
+		}
+		return nil
+	}},
+
+	{"conditional counters", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("n")
+		n, ok, err := c.IncrByIfExists(ctx, k, 5)
+		if err := check(err, expect("IncrByIfExists of missing key", []interface{}{n, ok}, []interface{}{int64(0), false})); err != nil {
+			return err
+		}
+		exists, err := c.Exists(ctx, k)
+		if err := check(err, expect("Exists after IncrByIfExists of missing key", exists, false)); err != nil {
+			return err
+		}
+
+		if err := c.Set(ctx, k, "10", time.Hour); err != nil {
+			return err
+		}
+		n, ok, err = c.IncrByIfExists(ctx, k, -3)
+		if err := check(err, expect("IncrByIfExists", []interface{}{n, ok}, []interface{}{int64(7), true})); err != nil {
+			return err
+		}
+		ttl, err := c.TTL(ctx, k)
+		if err != nil {
+			return err
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("IncrByIfExists dropped the expiry: TTL %v", ttl)
+		}
+		return nil
+	}},
+
+	{"hashes", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("h")
+		all, err := c.HGetAll(ctx, k)
+		if err := check(err, expect("HGetAll of missing key", all, map[string]string{})); err != nil {
+			return err
+		}
+		if err := check(c.HSet(ctx, k, "a", "1"), c.HSet(ctx, k, "b", "2"), c.HSet(ctx, k, "a", "3")); err != nil {
+			return err
+		}
+		got, err := c.HGet(ctx, k, "a")
+		if err := check(err, expect("HGet", got, "3")); err != nil {
+			return err
+		}
+		got, err = c.HGet(ctx, k, "missing")
+		if err := check(err, expect("HGet of missing field", got, "")); err != nil {
+			return err
+		}
+		ok, err := c.HExists(ctx, k, "b")
+		if err := check(err, expect("HExists", ok, true)); err != nil {
+			return err
+		}
+		n, err := c.HLen(ctx, k)
+		if err := check(err, expect("HLen", n, int64(2))); err != nil {
+			return err
+		}
+		all, err = c.HGetAll(ctx, k)
+		if err := check(err, expect("HGetAll", all, map[string]string{"a": "3", "b": "2"})); err != nil {
+			return err
+		}
+		if err := check(c.HSetAll(ctx, k, map[string]string{"b": "4", "c": "5"}), c.HSetAll(ctx, k, nil)); err != nil {
+			return err
+		}
+		all, err = c.HGetAll(ctx, k)
+		if err := check(err, expect("HGetAll after HSetAll", all, map[string]string{"a": "3", "b": "4", "c": "5"})); err != nil {
+			return err
+		}
+		if err := c.HDel(ctx, k, "c"); err != nil {
+			return err
+		}
+		if err := c.HDel(ctx, k, "a", "b", "missing"); err != nil {
+			return err
+		}
+		exists, err := c.Exists(ctx, k)
+		return check(err, expect("Exists of emptied hash", exists, false))
+	}},
+
+	{"sets", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("s")
+		members, err := c.SMembers(ctx, k)
+		if err := check(err, expect("SMembers of missing key", len(members), 0)); err != nil {
+			return err
+		}
+		if err := check(c.SAdd(ctx, k, "a", "b"), c.SAdd(ctx, k, "b", "c")); err != nil {
+			return err
+		}
+		n, err := c.SCard(ctx, k)
+		if err := check(err, expect("SCard", n, int64(3))); err != nil {
+			return err
+		}
+		members, err = c.SMembers(ctx, k)
+		if err := check(err, expect("SMembers", sorted(members), []string{"a", "b", "c"})); err != nil {
+			return err
+		}
+		ok, err := c.SIsMember(ctx, k, "c")
+		if err := check(err, expect("SIsMember", ok, true)); err != nil {
+			return err
+		}
+		if err := c.SRem(ctx, k, "a", "c", "missing"); err != nil {
+			return err
+		}
+		ok, err = c.SIsMember(ctx, k, "c")
+		if err := check(err, expect("SIsMember after SRem", ok, false)); err != nil {
+			return err
+		}
+		if err := c.SRem(ctx, k, "b"); err != nil {
+			return err
+		}
+		exists, err := c.Exists(ctx, k)
+		return check(err, expect("Exists of emptied set", exists, false))
+	}},
+
+	{"lists", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		k := key("l")
+		got, err := c.LPop(ctx, k)
+		if err := check(err, expect("LPop of missing key", got, "")); err != nil {
+			return err
+		}
+		if err := check(c.RPush(ctx, k, "a", "b"), c.LPush(ctx, k, "x", "y")); err != nil {
+			return err
+		}
+		all, err := c.LRange(ctx, k, 0, -1)
+		if err := check(err, expect("LRange 0 -1", all, []string{"y", "x", "a", "b"})); err != nil {
+			return err
+		}
+		all, err = c.LRange(ctx, k, 1, 2)
+		if err := check(err, expect("LRange 1 2", all, []string{"x", "a"})); err != nil {
+			return err
+		}
+		all, err = c.LRange(ctx, k, -2, 100)
+		if err := check(err, expect("LRange -2 100", all, []string{"a", "b"})); err != nil {
+			return err
+		}
+		all, err = c.LRange(ctx, k, 5, 10)
+		if err := check(err, expect("LRange out of range", len(all), 0)); err != nil {
+			return err
+		}
+		n, err := c.LLen(ctx, k)
+		if err := check(err, expect("LLen", n, int64(4))); err != nil {
+			return err
+		}
+		got, err = c.LPop(ctx, k)
+		if err := check(err, expect("LPop", got, "y")); err != nil {
+			return err
+		}
+		got, err = c.RPop(ctx, k)
+		if err := check(err, expect("RPop", got, "b")); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := c.RPop(ctx, k); err != nil {
+				return err
+			}
+		}
+		exists, err := c.Exists(ctx, k)
+		return check(err, expect("Exists of emptied list", exists, false))
+	}},
+
+	{"wrongtype", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		s, h, set, l := key("s"), key("h"), key("set"), key("l")
+		if err := check(c.Set(ctx, s, "v", 0), c.HSet(ctx, h, "f", "v"), c.SAdd(ctx, set, "m"), c.RPush(ctx, l, "e")); err != nil {
+			return err
+		}
+		if _, err := c.Get(ctx, h); err == nil {
+			return errors.New("Get of a hash did not fail")
+		}
+		if _, err := c.HGet(ctx, s, "f"); err == nil {
+			return errors.New("HGet of a string did not fail")
+		}
+		if err := c.SAdd(ctx, l, "m"); err == nil {
+			return errors.New("SAdd to a list did not fail")
+		}
+		if err := c.LPush(ctx, set, "e"); err == nil {
+			return errors.New("LPush to a set did not fail")
+		}
+		// Set replaces whatever the key held
+		if err := c.Set(ctx, h, "v", 0); err != nil {
+			return err
+		}
+		got, err := c.Get(ctx, h)
+		return check(err, expect("Get after Set over a hash", got, "v"))
+	}},
+
+	{"pubsub", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		channel := key("channel")
+		subCtx, cancel := context.WithCancel(ctx)
+		messages, err := c.Subscribe(subCtx, channel)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if err := check(c.Publish(ctx, channel, "one"), c.Publish(ctx, channel, "two"), c.Publish(ctx, key("other"), "x")); err != nil {
+			cancel()
+			return err
+		}
+
+		var got []string
+		timeout := time.After(2 * time.Second)
+		for len(got) < 2 {
+			select {
+			case m := <-messages:
+				got = append(got, m)
+			case <-timeout:
+				cancel()
+				return fmt.Errorf("received %v, want [one two]", got)
+			}
+		}
+		if err := expect("messages", got, []string{"one", "two"}); err != nil {
+			cancel()
+			return err
+		}
+
+		cancel()
+		select {
+		case _, ok := <-messages:
+			if ok {
+				return errors.New("message after unsubscribing")
+			}
+			return nil
+		case <-time.After(2 * time.Second):
+			return errors.New("message channel not closed after unsubscribing")
+		}
+	}},
+
+	{"keys", func(ctx context.Context, c cache.RedisClient, key func(string) string) error {
+		for _, name := range []string{"note:a1", "note:b2", "note:c3", "other"} {
+			if err := c.Set(ctx, key(name), "v", 0); err != nil {
+				return err
+			}
+		}
+		prefix := key("")
+		keys, err := c.Keys(ctx, prefix+"note:*")
+		if err := check(err, expect("Keys note:*", sorted(keys), []string{prefix + "note:a1", prefix + "note:b2", prefix + "note:c3"})); err != nil {
+			return err
+		}
+		keys, err = c.Keys(ctx, prefix+"note:[ab]?")
+		if err := check(err, expect("Keys note:[ab]?", sorted(keys), []string{prefix + "note:a1", prefix + "note:b2"})); err != nil {
+			return err
+		}
+		keys, err = c.Keys(ctx, prefix+"note:[^a]*")
+		if err := check(err, expect("Keys note:[^a]*", sorted(keys), []string{prefix + "note:b2", prefix + "note:c3"})); err != nil {
+			return err
+		}
+		n, err := c.CountKeys(ctx, prefix+"*")
+		return check(err, expect("CountKeys", n, int64(4)))
+	}},
+}
@@ -0,0 +1,41 @@
+package cache_test
+
+import (
+	"context"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/cachetest"
+	"testing"
+	"time"
+)
+
+// The conformance suite runs against every RedisClient: the in-memory one,
+// the instrumented wrapper and, when one is available, a real Redis, see
+// testRedis
+
+func runConformance(t *testing.T, client cache.RedisClient) {
+	for _, tc := range cachetest.Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if err := cachetest.RunCase(context.Background(), client, "conformance:", tc); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMemoryClientConformance(t *testing.T) {
+	runConformance(t, cache.NewMemoryClient())
+}
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveCacheOperation(op, family string, took time.Duration, err error) {}
+func (nopObserver) ObserveCacheLookup(tier, op, family string, hit bool)                   {}
+
+func TestInstrumentedClientConformance(t *testing.T) {
+	runConformance(t, cache.NewInstrumentedClient(cache.NewMemoryClient(), nopObserver{}))
+}
+
+func TestRedisClientConformance(t *testing.T) {
+	_, client := testRedis(t)
+	runConformance(t, client)
+}
@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"context"
+	"pdm-logic-server/pkg/cache/keys"
+	"strings"
+	"time"
+)
+
+// Tiers a lookup can be answered from
+const (
+	TierLocal = "local"
+	TierRedis = "redis"
+)
+
+// Observer receives what InstrumentedClient and the local tier measure;
+// metrics.Metrics implements it
+type Observer interface {
+	// ObserveCacheOperation records one call of op on a key of family
+	ObserveCacheOperation(op, family string, took time.Duration, err error)
+	// ObserveCacheLookup records whether a read of a key of family found
+	// anything in tier
+	ObserveCacheLookup(tier, op, family string, hit bool)
+}
+
+// maxFamilySegment is the longest key segment kept in a family name; longer
+// ones are taken for IDs
+const maxFamilySegment = 32
+
+// KeyFamily names the family of a key by its pattern, with the segments that
+// vary from key to key, IDs, emails, hashes and timestamps, replaced by *.
+// user:<uuid>:note:<uuid> is user:*:note:*. Only segments made of letters,
+// '_' and '-' are kept, so the number of families stays small. The schema
+// version is left out, so families carry over from one version to the next.
+func KeyFamily(key string) string {
+	segments := strings.Split(keys.Unversioned(key), ":")
+	for i, segment := range segments {
+		if !familySegment(segment) {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, ":")
+}
+
+func familySegment(segment string) bool {
+	if segment == "" || len(segment) > maxFamilySegment {
+		return false
+	}
+	for _, r := range segment {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// InstrumentedClient is a RedisClient that reports the count, latency and
+// errors of every call, and hits and misses of reads, to an Observer
+type InstrumentedClient struct {
+	next     RedisClient
+	observer Observer
+}
+
+func NewInstrumentedClient(next RedisClient, observer Observer) RedisClient {
+	return &InstrumentedClient{next: next, observer: observer}
+}
+
+// start times a call of op on key; the returned func records it
+func (c *InstrumentedClient) start(op, key string) func(err error) {
+	begin := time.Now()
+	return func(err error) {
+		c.observer.ObserveCacheOperation(op, KeyFamily(key), time.Since(begin), err)
+	}
+}
+
+func (c *InstrumentedClient) lookup(op, key string, hit bool) {
+	c.observer.ObserveCacheLookup(TierRedis, op, KeyFamily(key), hit)
+}
+
+// Basic operations
+func (c *InstrumentedClient) Get(ctx context.Context, key string) (string, error) {
+	done := c.start("get", key)
+	value, err := c.next.Get(ctx, key)
+	done(err)
+	if err == nil {
+		c.lookup("get", key, value != "")
+	}
+	return value, err
+}
+
+// MGet is recorded under the family of its first key; hits and misses are
+// recorded per key
+func (c *InstrumentedClient) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return c.next.MGet(ctx)
+	}
+	done := c.start("mget", keys[0])
+	values, err := c.next.MGet(ctx, keys...)
+	done(err)
+	if err == nil {
+		for i, value := range values {
+			c.lookup("mget", keys[i], value != "")
+		}
+	}
+	return values, err
+}
+
+func (c *InstrumentedClient) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	done := c.start("set", key)
+	err := c.next.Set(ctx, key, value, ttl)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) Delete(ctx context.Context, key string) error {
+	done := c.start("delete", key)
+	err := c.next.Delete(ctx, key)
+	done(err)
+	return err
+}
+
+// Count operations
+func (c *InstrumentedClient) Incr(ctx context.Context, key string) (int64, error) {
+	done := c.start("incr", key)
+	n, err := c.next.Incr(ctx, key)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	done := c.start("incrby", key)
+	n, err := c.next.IncrBy(ctx, key, value)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) IncrByIfExists(ctx context.Context, key string, value int64) (int64, bool, error) {
+	done := c.start("incrbyifexists", key)
+	n, ok, err := c.next.IncrByIfExists(ctx, key, value)
+	done(err)
+	return n, ok, err
+}
+
+func (c *InstrumentedClient) Decr(ctx context.Context, key string) (int64, error) {
+	done := c.start("decr", key)
+	n, err := c.next.Decr(ctx, key)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) DecrBy(ctx context.Context, key string, value int64) (int64, error) {
+	done := c.start("decrby", key)
+	n, err := c.next.DecrBy(ctx, key, value)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) CountKeys(ctx context.Context, pattern string) (int64, error) {
+	done := c.start("countkeys", pattern)
+	n, err := c.next.CountKeys(ctx, pattern)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) Keys(ctx context.Context, pattern string) ([]string, error) {
+	done := c.start("keys", pattern)
+	keys, err := c.next.Keys(ctx, pattern)
+	done(err)
+	return keys, err
+}
+
+// Key operations
+func (c *InstrumentedClient) Exists(ctx context.Context, key string) (bool, error) {
+	done := c.start("exists", key)
+	ok, err := c.next.Exists(ctx, key)
+	done(err)
+	if err == nil {
+		c.lookup("exists", key, ok)
+	}
+	return ok, err
+}
+
+func (c *InstrumentedClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	done := c.start("expire", key)
+	err := c.next.Expire(ctx, key, ttl)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	done := c.start("ttl", key)
+	ttl, err := c.next.TTL(ctx, key)
+	done(err)
+	return ttl, err
+}
+
+// Map-like operations
+func (c *InstrumentedClient) HSet(ctx context.Context, key, field, value string) error {
+	done := c.start("hset", key)
+	err := c.next.HSet(ctx, key, field, value)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) HSetAll(ctx context.Context, key string, fields map[string]string) error {
+	done := c.start("hsetall", key)
+	err := c.next.HSetAll(ctx, key, fields)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) HGet(ctx context.Context, key, field string) (string, error) {
+	done := c.start("hget", key)
+	value, err := c.next.HGet(ctx, key, field)
+	done(err)
+	if err == nil {
+		c.lookup("hget", key, value != "")
+	}
+	return value, err
+}
+
+func (c *InstrumentedClient) HDel(ctx context.Context, key string, fields ...string) error {
+	done := c.start("hdel", key)
+	err := c.next.HDel(ctx, key, fields...)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) HExists(ctx context.Context, key, field string) (bool, error) {
+	done := c.start("hexists", key)
+	ok, err := c.next.HExists(ctx, key, field)
+	done(err)
+	if err == nil {
+		c.lookup("hexists", key, ok)
+	}
+	return ok, err
+}
+
+func (c *InstrumentedClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	done := c.start("hgetall", key)
+	all, err := c.next.HGetAll(ctx, key)
+	done(err)
+	if err == nil {
+		c.lookup("hgetall", key, len(all) > 0)
+	}
+	return all, err
+}
+
+func (c *InstrumentedClient) HLen(ctx context.Context, key string) (int64, error) {
+	done := c.start("hlen", key)
+	n, err := c.next.HLen(ctx, key)
+	done(err)
+	return n, err
+}
+
+// Set-like operations
+func (c *InstrumentedClient) SAdd(ctx context.Context, key string, members ...string) error {
+	done := c.start("sadd", key)
+	err := c.next.SAdd(ctx, key, members...)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) SRem(ctx context.Context, key string, members ...string) error {
+	done := c.start("srem", key)
+	err := c.next.SRem(ctx, key, members...)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	done := c.start("smembers", key)
+	members, err := c.next.SMembers(ctx, key)
+	done(err)
+	if err == nil {
+		c.lookup("smembers", key, len(members) > 0)
+	}
+	return members, err
+}
+
+func (c *InstrumentedClient) SIsMember(ctx context.Context, key, member string) (bool, error) {
+	done := c.start("sismember", key)
+	ok, err := c.next.SIsMember(ctx, key, member)
+	done(err)
+	return ok, err
+}
+
+func (c *InstrumentedClient) SCard(ctx context.Context, key string) (int64, error) {
+	done := c.start("scard", key)
+	n, err := c.next.SCard(ctx, key)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	done := c.start("setnx", key)
+	ok, err := c.next.SetNX(ctx, key, value, ttl)
+	done(err)
+	return ok, err
+}
+
+// List operations
+func (c *InstrumentedClient) LPush(ctx context.Context, key string, values ...string) error {
+	done := c.start("lpush", key)
+	err := c.next.LPush(ctx, key, values...)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) RPush(ctx context.Context, key string, values ...string) error {
+	done := c.start("rpush", key)
+	err := c.next.RPush(ctx, key, values...)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) LPop(ctx context.Context, key string) (string, error) {
+	done := c.start("lpop", key)
+	value, err := c.next.LPop(ctx, key)
+	done(err)
+	return value, err
+}
+
+func (c *InstrumentedClient) RPop(ctx context.Context, key string) (string, error) {
+	done := c.start("rpop", key)
+	value, err := c.next.RPop(ctx, key)
+	done(err)
+	return value, err
+}
+
+func (c *InstrumentedClient) LLen(ctx context.Context, key string) (int64, error) {
+	done := c.start("llen", key)
+	n, err := c.next.LLen(ctx, key)
+	done(err)
+	return n, err
+}
+
+func (c *InstrumentedClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	done := c.start("lrange", key)
+	values, err := c.next.LRange(ctx, key, start, stop)
+	done(err)
+	return values, err
+}
+
+// Pub/sub
+func (c *InstrumentedClient) Publish(ctx context.Context, channel, message string) error {
+	done := c.start("publish", channel)
+	err := c.next.Publish(ctx, channel, message)
+	done(err)
+	return err
+}
+
+func (c *InstrumentedClient) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	done := c.start("subscribe", channel)
+	messages, err := c.next.Subscribe(ctx, channel)
+	done(err)
+	return messages, err
+}
@@ -0,0 +1,168 @@
+// Package keys builds every Redis key the server uses, so their layout is
+// defined in one place.
+//
+// Keys come in two kinds. Cache keys hold copies of what is in the database
+// and start with the schema version, v<N>:, so a deploy that bumps the
+// version starts on an empty cache and the keys of the old version expire
+// on their own. Each cache family has its TTL from config, see Schema.TTL.
+// State keys hold what cannot be rebuilt from the database, sessions,
+// locks, jobs and idempotency records, and keep their names across
+// versions; their lifetimes are part of the protocols that use them.
+package keys
+
+import (
+	"fmt"
+	"pdm-logic-server/pkg/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Family is a family of cache keys sharing a layout and a TTL
+type Family int
+
+const (
+	Note         Family = iota // user:<id>:note:<noteid>, a cached note
+	PendingNote                // a cached note whose write the database has not confirmed yet
+	NoteIndex                  // user:<id>:notes, the IDs of a user's cached notes
+	NotesLoaded                // user:<id>:notes:loaded, set while all of a user's notes are cached
+	NotesVersion               // user:<id>:notes:version, the ETag version of a user's notes
+	UserInfo                   // user:<id>:userinfo
+	Usage                      // user:<id>:usage:*, a user's tracked quota usage
+)
+
+// Schema builds keys for one schema version and knows the TTL of each
+// cache family
+type Schema struct {
+	prefix string
+	ttls   map[Family]time.Duration
+}
+
+func NewSchema(cfg *config.CacheKeysConfig) *Schema {
+	return &Schema{
+		prefix: "v" + strconv.Itoa(cfg.Version) + ":",
+		ttls: map[Family]time.Duration{
+			Note:        cfg.NotesTTL,
+			PendingNote: max(cfg.PendingNotesTTL, cfg.NotesTTL),
+			// The index lists pending notes too
+			NoteIndex:    max(cfg.PendingNotesTTL, cfg.NotesTTL),
+			NotesLoaded:  cfg.NotesTTL,
+			NotesVersion: cfg.NotesVersionTTL,
+			UserInfo:     cfg.UserInfoTTL,
+			Usage:        cfg.UsageTTL,
+		},
+	}
+}
+
+// TTL returns how long keys of a cache family live
+func (s *Schema) TTL(f Family) time.Duration {
+	return s.ttls[f]
+}
+
+// Unversioned strips the schema version off a cache key, and returns other
+// keys as they are
+func Unversioned(key string) string {
+	version, rest, ok := strings.Cut(key, ":")
+	if !ok || len(version) < 2 || version[0] != 'v' {
+		return key
+	}
+	if _, err := strconv.Atoi(version[1:]); err != nil {
+		return key
+	}
+	return rest
+}
+
+func (s *Schema) cache(format string, args ...any) string {
+	return s.prefix + fmt.Sprintf(format, args...)
+}
+
+// Cache keys
+
+func (s *Schema) Note(userID, noteID string) string {
+	return s.cache("user:%s:note:%s", userID, noteID)
+}
+
+func (s *Schema) NoteIndex(userID string) string {
+	return s.cache("user:%s:notes", userID)
+}
+
+func (s *Schema) NotesLoaded(userID string) string {
+	return s.cache("user:%s:notes:loaded", userID)
+}
+
+func (s *Schema) NotesVersion(userID string) string {
+	return s.cache("user:%s:notes:version", userID)
+}
+
+// UserInfo is the cached info of userID; "*" gives the pattern of the family
+func (s *Schema) UserInfo(userID string) string {
+	return s.cache("user:%s:userinfo", userID)
+}
+
+// Usage returns the note counter, the byte counter and the hash of note
+// sizes of a user
+func (s *Schema) Usage(userID string) (notes, bytes, sizes string) {
+	prefix := s.cache("user:%s:usage", userID)
+	return prefix + ":notes", prefix + ":bytes", prefix + ":sizes"
+}
+
+// AttachmentUsage is the counter of the attachment bytes a user has reserved
+func (s *Schema) AttachmentUsage(userID string) string {
+	return s.cache("user:%s:usage:attachments", userID)
+}
+
+// RebuildLock is held by the instance rebuilding a cache key
+func (s *Schema) RebuildLock(key string) string {
+	return s.prefix + "rebuild:" + Unversioned(key)
+}
+
+// State keys
+
+// UserIDs is the hash of user IDs by email, filled at login
+func (s *Schema) UserIDs() string {
+	return "userEmail:userId"
+}
+
+func (s *Schema) Session(userID string) string {
+	return fmt.Sprintf("user:%s:sessionKey", userID)
+}
+
+// Blocked is the nth session key of userID blocked at logout
+func (s *Schema) Blocked(userID string, n int64) string {
+	return fmt.Sprintf("user:%s:blocked:%d", userID, n)
+}
+
+func (s *Schema) BlockedPattern(userID string) string {
+	return fmt.Sprintf("user:%s:blocked:*", userID)
+}
+
+// Idempotency is the record of a request of userID by the hash of its key
+func (s *Schema) Idempotency(userID, keyHash string) string {
+	return fmt.Sprintf("user:%s:idempotency:%s", userID, keyHash)
+}
+
+func (s *Schema) Job(jobID string) string {
+	return fmt.Sprintf("job:%s", jobID)
+}
+
+func (s *Schema) ExportRunning(userID string) string {
+	return fmt.Sprintf("user:%s:export:running", userID)
+}
+
+func (s *Schema) AuditRunning() string {
+	return "admin:integrity_audit:running"
+}
+
+// ReminderLock is held by the instance firing an occurrence of a reminder
+func (s *Schema) ReminderLock(reminderID string, fireAt time.Time) string {
+	return fmt.Sprintf("reminder:%s:%d:lock", reminderID, fireAt.Unix())
+}
+
+// ReminderSent records the channels an occurrence of a reminder went out on
+func (s *Schema) ReminderSent(reminderID string, fireAt time.Time) string {
+	return fmt.Sprintf("reminder:%s:%d:sent", reminderID, fireAt.Unix())
+}
+
+func (s *Schema) PublicLinkAttempts(linkID string) string {
+	return fmt.Sprintf("publicLink:%s:attempts", linkID)
+}
@@ -0,0 +1,116 @@
+package keys
+
+import (
+	"pdm-logic-server/pkg/config"
+	"testing"
+	"time"
+)
+
+func testSchema() *Schema {
+	return NewSchema(&config.CacheKeysConfig{
+		Version:         3,
+		NotesTTL:        time.Hour,
+		PendingNotesTTL: 2 * time.Hour,
+		NotesVersionTTL: 24 * time.Hour,
+		UserInfoTTL:     time.Minute,
+		UsageTTL:        30 * time.Minute,
+	})
+}
+
+func TestSchemaKeys(t *testing.T) {
+	s := testSchema()
+	fireAt := time.Unix(1700000000, 0)
+	notes, bytes, sizes := s.Usage("u1")
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "Note", got: s.Note("u1", "n1"), want: "v3:user:u1:note:n1"},
+		{name: "NoteIndex", got: s.NoteIndex("u1"), want: "v3:user:u1:notes"},
+		{name: "NotesLoaded", got: s.NotesLoaded("u1"), want: "v3:user:u1:notes:loaded"},
+		{name: "NotesVersion", got: s.NotesVersion("u1"), want: "v3:user:u1:notes:version"},
+		{name: "UserInfo", got: s.UserInfo("u1"), want: "v3:user:u1:userinfo"},
+		{name: "UserInfo pattern", got: s.UserInfo("*"), want: "v3:user:*:userinfo"},
+		{name: "Usage notes", got: notes, want: "v3:user:u1:usage:notes"},
+		{name: "Usage bytes", got: bytes, want: "v3:user:u1:usage:bytes"},
+		{name: "Usage sizes", got: sizes, want: "v3:user:u1:usage:sizes"},
+		{name: "AttachmentUsage", got: s.AttachmentUsage("u1"), want: "v3:user:u1:usage:attachments"},
+		{name: "RebuildLock", got: s.RebuildLock(s.NoteIndex("u1")), want: "v3:rebuild:user:u1:notes"},
+		{name: "UserIDs", got: s.UserIDs(), want: "userEmail:userId"},
+		{name: "Session", got: s.Session("u1"), want: "user:u1:sessionKey"},
+		{name: "Blocked", got: s.Blocked("u1", 7), want: "user:u1:blocked:7"},
+		{name: "BlockedPattern", got: s.BlockedPattern("u1"), want: "user:u1:blocked:*"},
+		{name: "Idempotency", got: s.Idempotency("u1", "abc"), want: "user:u1:idempotency:abc"},
+		{name: "Job", got: s.Job("j1"), want: "job:j1"},
+		{name: "ExportRunning", got: s.ExportRunning("u1"), want: "user:u1:export:running"},
+		{name: "AuditRunning", got: s.AuditRunning(), want: "admin:integrity_audit:running"},
+		{name: "ReminderLock", got: s.ReminderLock("r1", fireAt), want: "reminder:r1:1700000000:lock"},
+		{name: "ReminderSent", got: s.ReminderSent("r1", fireAt), want: "reminder:r1:1700000000:sent"},
+		{name: "PublicLinkAttempts", got: s.PublicLinkAttempts("l1"), want: "publicLink:l1:attempts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("key = %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaTTL(t *testing.T) {
+	s := testSchema()
+
+	tests := []struct {
+		family Family
+		want   time.Duration
+	}{
+		{family: Note, want: time.Hour},
+		{family: PendingNote, want: 2 * time.Hour},
+		{family: NoteIndex, want: 2 * time.Hour},
+		{family: NotesLoaded, want: time.Hour},
+		{family: NotesVersion, want: 24 * time.Hour},
+		{family: UserInfo, want: time.Minute},
+		{family: Usage, want: 30 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := s.TTL(tt.family); got != tt.want {
+			t.Errorf("TTL(%d) = %v, want %v", tt.family, got, tt.want)
+		}
+	}
+
+	// Pending notes and the index never expire before the notes themselves
+	short := NewSchema(&config.CacheKeysConfig{NotesTTL: time.Hour, PendingNotesTTL: time.Minute})
+	for _, f := range []Family{PendingNote, NoteIndex} {
+		if got := short.TTL(f); got != time.Hour {
+			t.Errorf("TTL(%d) with short pending TTL = %v, want %v", f, got, time.Hour)
+		}
+	}
+}
+
+func TestUnversioned(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "v3:user:u1:notes", want: "user:u1:notes"},
+		{key: "v12:rebuild:user:u1:notes", want: "rebuild:user:u1:notes"},
+		{key: "user:u1:sessionKey", want: "user:u1:sessionKey"},
+		{key: "v:user:u1", want: "v:user:u1"},
+		{key: "vx:user:u1", want: "vx:user:u1"},
+		{key: "job:j1", want: "job:j1"},
+		{key: "v3", want: "v3"},
+		{key: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := Unversioned(tt.key); got != tt.want {
+				t.Errorf("Unversioned(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LocalFamily is a family of keys, named by a Redis glob pattern, whose
+// values are also kept in process for TTL
+type LocalFamily struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// localEntryOverhead approximates the bookkeeping per entry, so many small
+// entries still count against the memory bound
+const localEntryOverhead = 96
+
+// localKey names a string value (no field) or one field of a hash
+type localKey struct {
+	key      string
+	field    string
+	hasField bool
+}
+
+type localEntry struct {
+	k       localKey
+	value   string
+	expires time.Time
+	size    int64
+}
+
+// LocalCache is an in-process LRU of values read from Redis, bounded by the
+// approximate bytes it holds. It only ever holds copies: writes go to Redis,
+// and the copies are dropped when they expire or are invalidated.
+type LocalCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // of *localEntry, most recently used first
+	entries  map[localKey]*list.Element
+	byKey    map[string]map[localKey]struct{}
+	// epoch moves on with every invalidation. A value read from Redis is
+	// only kept if no invalidation happened while it was being read, as it
+	// may be older than the write that caused it.
+	epoch uint64
+}
+
+func NewLocalCache(maxBytes int64) *LocalCache {
+	return &LocalCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[localKey]*list.Element),
+		byKey:    make(map[string]map[localKey]struct{}),
+	}
+}
+
+func (l *LocalCache) get(k localKey) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[k]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*localEntry)
+	if !time.Now().Before(entry.expires) {
+		l.remove(el)
+		return "", false
+	}
+	l.lru.MoveToFront(el)
+	return entry.value, true
+}
+
+// currentEpoch is read before going to Redis and handed to put
+func (l *LocalCache) currentEpoch() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epoch
+}
+
+func (l *LocalCache) put(k localKey, value string, ttl time.Duration, epoch uint64) {
+	size := int64(len(k.key)+len(k.field)+len(value)) + localEntryOverhead
+	if size > l.maxBytes {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if epoch != l.epoch {
+		return
+	}
+	if el, ok := l.entries[k]; ok {
+		l.remove(el)
+	}
+
+	entry := &localEntry{k: k, value: value, expires: time.Now().Add(ttl), size: size}
+	l.entries[k] = l.lru.PushFront(entry)
+	if l.byKey[k.key] == nil {
+		l.byKey[k.key] = make(map[localKey]struct{})
+	}
+	l.byKey[k.key][k] = struct{}{}
+	l.bytes += size
+
+	for l.bytes > l.maxBytes {
+		l.remove(l.lru.Back())
+	}
+}
+
+// remove drops an entry. Callers hold mu.
+func (l *LocalCache) remove(el *list.Element) {
+	entry := l.lru.Remove(el).(*localEntry)
+	delete(l.entries, entry.k)
+	if fields := l.byKey[entry.k.key]; fields != nil {
+		delete(fields, entry.k)
+		if len(fields) == 0 {
+			delete(l.byKey, entry.k.key)
+		}
+	}
+	l.bytes -= entry.size
+}
+
+// invalidate drops what is held for key: the one field if field is given,
+// otherwise the value and every field
+func (l *LocalCache) invalidate(key string, field *string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.epoch++
+	if field != nil {
+		if el, ok := l.entries[localKey{key: key, field: *field, hasField: true}]; ok {
+			l.remove(el)
+		}
+		return
+	}
+	for k := range l.byKey[key] {
+		l.remove(l.entries[k])
+	}
+}
+
+// clear drops everything, for when invalidations may have been missed
+func (l *LocalCache) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.epoch++
+	l.lru.Init()
+	l.entries = make(map[localKey]*list.Element)
+	l.byKey = make(map[string]map[localKey]struct{})
+	l.bytes = 0
+}
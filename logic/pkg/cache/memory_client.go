@@ -0,0 +1,709 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrWrongType is returned by MemoryClient for an operation on a key that
+// holds another kind of value, as Redis does
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// ErrNotInteger is returned by MemoryClient when incrementing a value that is
+// not an integer
+var ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+
+// sweepEvery is how many writes MemoryClient takes between sweeps for expired
+// keys; keys are otherwise only expired when they are next touched
+const sweepEvery = 1024
+
+// MemoryClient is a RedisClient that keeps everything in process memory. It
+// behaves like a single Redis server, down to expiry and empty hashes, sets
+// and lists being removed, so it can stand in for Redis in local development
+// and on a single node. Nothing is shared between processes or persisted.
+type MemoryClient struct {
+	mu     sync.Mutex
+	keys   map[string]*memoryValue
+	writes int
+
+	subMu       sync.Mutex
+	subscribers map[string]map[chan string]struct{}
+}
+
+// memoryValue holds one of string, map[string]string (hash),
+// map[string]struct{} (set) or []string (list)
+type memoryValue struct {
+	value   interface{}
+	expires time.Time // zero for no expiry
+}
+
+func (v *memoryValue) expired(now time.Time) bool {
+	return !v.expires.IsZero() && !now.Before(v.expires)
+}
+
+func NewMemoryClient() RedisClient {
+	return &MemoryClient{
+		keys:        make(map[string]*memoryValue),
+		subscribers: make(map[string]map[chan string]struct{}),
+	}
+}
+
+// lookup returns the live value at key, dropping it if it has expired.
+// Callers hold mu.
+func (c *MemoryClient) lookup(key string) *memoryValue {
+	v, ok := c.keys[key]
+	if !ok {
+		return nil
+	}
+	if v.expired(time.Now()) {
+		delete(c.keys, key)
+		return nil
+	}
+	return v
+}
+
+// wrote counts a write and sweeps out expired keys now and then. Callers
+// hold mu.
+func (c *MemoryClient) wrote() {
+	c.writes++
+	if c.writes%sweepEvery != 0 {
+		return
+	}
+	now := time.Now()
+	for key, v := range c.keys {
+		if v.expired(now) {
+			delete(c.keys, key)
+		}
+	}
+}
+
+func (c *MemoryClient) str(key string) (string, bool, error) {
+	v := c.lookup(key)
+	if v == nil {
+		return "", false, nil
+	}
+	s, ok := v.value.(string)
+	if !ok {
+		return "", false, ErrWrongType
+	}
+	return s, true, nil
+}
+
+func (c *MemoryClient) hash(key string, create bool) (map[string]string, error) {
+	v := c.lookup(key)
+	if v == nil {
+		if !create {
+			return nil, nil
+		}
+		h := make(map[string]string)
+		c.keys[key] = &memoryValue{value: h}
+		return h, nil
+	}
+	h, ok := v.value.(map[string]string)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return h, nil
+}
+
+func (c *MemoryClient) set(key string, create bool) (map[string]struct{}, error) {
+	v := c.lookup(key)
+	if v == nil {
+		if !create {
+			return nil, nil
+		}
+		s := make(map[string]struct{})
+		c.keys[key] = &memoryValue{value: s}
+		return s, nil
+	}
+	s, ok := v.value.(map[string]struct{})
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return s, nil
+}
+
+func (c *MemoryClient) list(key string) (*memoryValue, []string, error) {
+	v := c.lookup(key)
+	if v == nil {
+		return nil, nil, nil
+	}
+	l, ok := v.value.([]string)
+	if !ok {
+		return nil, nil, ErrWrongType
+	}
+	return v, l, nil
+}
+
+// Basic operations
+func (c *MemoryClient) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, _, err := c.str(key)
+	return s, err
+}
+
+func (c *MemoryClient) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		// Like MGET, other kinds of value read as missing
+		values[i], _, _ = c.str(key)
+	}
+	return values, nil
+}
+
+func (c *MemoryClient) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := &memoryValue{value: value}
+	if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	c.keys[key] = v
+	c.wrote()
+	return nil
+}
+
+func (c *MemoryClient) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lookup(key) != nil {
+		return false, nil
+	}
+	v := &memoryValue{value: value}
+	if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	c.keys[key] = v
+	c.wrote()
+	return true, nil
+}
+
+func (c *MemoryClient) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.keys, key)
+	return nil
+}
+
+// Count operations
+func (c *MemoryClient) Incr(ctx context.Context, key string) (int64, error) {
+	return c.IncrBy(ctx, key, 1)
+}
+
+func (c *MemoryClient) Decr(ctx context.Context, key string) (int64, error) {
+	return c.IncrBy(ctx, key, -1)
+}
+
+func (c *MemoryClient) DecrBy(ctx context.Context, key string, value int64) (int64, error) {
+	return c.IncrBy(ctx, key, -value)
+}
+
+// IncrBy adds value to the integer at key, keeping its expiry
+func (c *MemoryClient) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.incrBy(key, value)
+}
+
+func (c *MemoryClient) IncrByIfExists(ctx context.Context, key string, value int64) (int64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lookup(key) == nil {
+		return 0, false, nil
+	}
+	n, err := c.incrBy(key, value)
+	return n, err == nil, err
+}
+
+// incrBy adds value to the integer at key. Callers hold mu.
+func (c *MemoryClient) incrBy(key string, value int64) (int64, error) {
+	s, ok, err := c.str(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if ok {
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	n += value
+
+	if v := c.lookup(key); v != nil {
+		v.value = strconv.FormatInt(n, 10)
+	} else {
+		c.keys[key] = &memoryValue{value: strconv.FormatInt(n, 10)}
+	}
+	c.wrote()
+	return n, nil
+}
+
+func (c *MemoryClient) Keys(ctx context.Context, pattern string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := []string{}
+	for key := range c.keys {
+		if c.lookup(key) != nil && globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (c *MemoryClient) CountKeys(ctx context.Context, pattern string) (int64, error) {
+	keys, err := c.Keys(ctx, pattern)
+	return int64(len(keys)), err
+}
+
+// Key operations
+func (c *MemoryClient) Exists(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lookup(key) != nil, nil
+}
+
+// Expire sets the expiry of key; a ttl that is not positive deletes it
+func (c *MemoryClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.lookup(key)
+	if v == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(c.keys, key)
+		return nil
+	}
+	v.expires = time.Now().Add(ttl)
+	return nil
+}
+
+// TTL returns the time left on key, -1 for a key without expiry and -2 for a
+// missing key, as the Redis client does
+func (c *MemoryClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.lookup(key)
+	switch {
+	case v == nil:
+		return -2, nil
+	case v.expires.IsZero():
+		return -1, nil
+	}
+	// Redis reports whole seconds, rounded to the nearest
+	return time.Until(v.expires).Round(time.Second), nil
+}
+
+// Map-like operations
+func (c *MemoryClient) HSet(ctx context.Context, key, field, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, true)
+	if err != nil {
+		return err
+	}
+	h[field] = value
+	c.wrote()
+	return nil
+}
+
+func (c *MemoryClient) HSetAll(ctx context.Context, key string, fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, true)
+	if err != nil {
+		return err
+	}
+	for field, value := range fields {
+		h[field] = value
+	}
+	c.wrote()
+	return nil
+}
+
+func (c *MemoryClient) HGet(ctx context.Context, key, field string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, false)
+	return h[field], err
+}
+
+func (c *MemoryClient) HDel(ctx context.Context, key string, fields ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, false)
+	if err != nil || h == nil {
+		return err
+	}
+	for _, field := range fields {
+		delete(h, field)
+	}
+	if len(h) == 0 {
+		delete(c.keys, key)
+	}
+	return nil
+}
+
+func (c *MemoryClient) HExists(ctx context.Context, key, field string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, false)
+	_, ok := h[field]
+	return ok, err
+}
+
+func (c *MemoryClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, false)
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]string, len(h))
+	for field, value := range h {
+		all[field] = value
+	}
+	return all, nil
+}
+
+func (c *MemoryClient) HLen(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := c.hash(key, false)
+	return int64(len(h)), err
+}
+
+// Set-like operations
+func (c *MemoryClient) SAdd(ctx context.Context, key string, members ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.set(key, true)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		s[member] = struct{}{}
+	}
+	c.wrote()
+	return nil
+}
+
+func (c *MemoryClient) SRem(ctx context.Context, key string, members ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.set(key, false)
+	if err != nil || s == nil {
+		return err
+	}
+	for _, member := range members {
+		delete(s, member)
+	}
+	if len(s) == 0 {
+		delete(c.keys, key)
+	}
+	return nil
+}
+
+func (c *MemoryClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.set(key, false)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(s))
+	for member := range s {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (c *MemoryClient) SIsMember(ctx context.Context, key, member string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.set(key, false)
+	_, ok := s[member]
+	return ok, err
+}
+
+func (c *MemoryClient) SCard(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.set(key, false)
+	return int64(len(s)), err
+}
+
+// List operations
+
+// LPush pushes values to the head of the list one after another, so they
+// end up in reverse order, like LPUSH
+func (c *MemoryClient) LPush(ctx context.Context, key string, values ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, l, err := c.list(key)
+	if err != nil {
+		return err
+	}
+	pushed := make([]string, 0, len(values)+len(l))
+	for i := len(values) - 1; i >= 0; i-- {
+		pushed = append(pushed, values[i])
+	}
+	c.storeList(key, v, append(pushed, l...))
+	return nil
+}
+
+func (c *MemoryClient) RPush(ctx context.Context, key string, values ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, l, err := c.list(key)
+	if err != nil {
+		return err
+	}
+	c.storeList(key, v, append(l, values...))
+	return nil
+}
+
+// storeList puts l back at key, keeping the expiry of v, and removes the key
+// once the list is empty. Callers hold mu.
+func (c *MemoryClient) storeList(key string, v *memoryValue, l []string) {
+	switch {
+	case len(l) == 0:
+		delete(c.keys, key)
+	case v == nil:
+		c.keys[key] = &memoryValue{value: l}
+	default:
+		v.value = l
+	}
+	c.wrote()
+}
+
+func (c *MemoryClient) LPop(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, l, err := c.list(key)
+	if err != nil || len(l) == 0 {
+		return "", err
+	}
+	c.storeList(key, v, l[1:])
+	return l[0], nil
+}
+
+func (c *MemoryClient) RPop(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, l, err := c.list(key)
+	if err != nil || len(l) == 0 {
+		return "", err
+	}
+	c.storeList(key, v, l[:len(l)-1])
+	return l[len(l)-1], nil
+}
+
+func (c *MemoryClient) LLen(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, l, err := c.list(key)
+	return int64(len(l)), err
+}
+
+// LRange returns the elements from start to stop inclusive; negative indexes
+// count from the end, like LRANGE
+func (c *MemoryClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, l, err := c.list(key)
+	if err != nil {
+		return nil, err
+	}
+
+	n := int64(len(l))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+	return append([]string(nil), l[start:stop+1]...), nil
+}
+
+// Pub/sub
+
+// memorySubscriberBuffer is how many messages a subscriber may fall behind
+// by before further messages to it are dropped
+const memorySubscriberBuffer = 256
+
+func (c *MemoryClient) Publish(ctx context.Context, channel, message string) error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for sub := range c.subscribers[channel] {
+		select {
+		case sub <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *MemoryClient) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	sub := make(chan string, memorySubscriberBuffer)
+
+	c.subMu.Lock()
+	if c.subscribers[channel] == nil {
+		c.subscribers[channel] = make(map[chan string]struct{})
+	}
+	c.subscribers[channel][sub] = struct{}{}
+	c.subMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+		delete(c.subscribers[channel], sub)
+		if len(c.subscribers[channel]) == 0 {
+			delete(c.subscribers, channel)
+		}
+		close(sub)
+	}()
+	return sub, nil
+}
+
+// globMatch reports whether s matches a Redis glob pattern: * and ? match any
+// run of characters and any one character, [...] a character class, which
+// may be negated with ^ and hold ranges, and \ escapes the next character.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			if end < len(pattern) && pattern[end] == '^' {
+				end++
+			}
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if !classMatch(pattern[1:min(end, len(pattern))], s[0]) {
+				return false
+			}
+			if end < len(pattern) {
+				end++
+			}
+			pattern = pattern[end:]
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+// classMatch matches b against the inside of a [...] class
+func classMatch(class string, b byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		c := class[i]
+		if c == '\\' && i+1 < len(class) {
+			i++
+			c = class[i]
+		} else if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := c, class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= b && b <= hi {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if c == b {
+			matched = true
+		}
+	}
+	return matched != negate
+}
@@ -2,7 +2,13 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
 	"pdm-logic-server/pkg/config"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -11,12 +17,17 @@ import (
 type RedisClient interface {
 	// Basic operations
 	Get(ctx context.Context, key string) (string, error)
+	// MGet returns the values of keys in order, "" for missing ones
+	MGet(ctx context.Context, keys ...string) ([]string, error)
 	Set(ctx context.Context, key string, value string, ttl time.Duration) error
 	Delete(ctx context.Context, key string) error
 
 	// Count operations
 	Incr(ctx context.Context, key string) (int64, error)
 	IncrBy(ctx context.Context, key string, value int64) (int64, error)
+	// IncrByIfExists adds value to the integer at key only if key exists,
+	// atomically, and reports whether it did
+	IncrByIfExists(ctx context.Context, key string, value int64) (int64, bool, error)
 	Decr(ctx context.Context, key string) (int64, error)
 	DecrBy(ctx context.Context, key string, value int64) (int64, error)
 	CountKeys(ctx context.Context, pattern string) (int64, error)
@@ -29,6 +40,8 @@ type RedisClient interface {
 
 	// Map-like operations
 	HSet(ctx context.Context, key, field, value string) error
+	// HSetAll sets every field of fields in one round trip
+	HSetAll(ctx context.Context, key string, fields map[string]string) error
 	HGet(ctx context.Context, key, field string) (string, error)
 	HDel(ctx context.Context, key string, fields ...string) error
 	HExists(ctx context.Context, key, field string) (bool, error)
@@ -50,32 +63,152 @@ type RedisClient interface {
 	RPop(ctx context.Context, key string) (string, error)
 	LLen(ctx context.Context, key string) (int64, error)
 	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+
+	// Pub/sub
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe returns once subscribed to channel. Messages arrive on the
+	// returned channel, which is closed when ctx is done. Delivery is at most
+	// once: an empty message is delivered when the subscription is re-made
+	// after a lost connection, as messages may have been missed meanwhile.
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
 }
+
+// DefaultRedisClient talks to a standalone server, a Sentinel-managed
+// master or a Cluster, depending on config.RedisConfig.Mode
 type DefaultRedisClient struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
+// scanCount is the COUNT hint for SCAN, the work Redis does per call
+const scanCount = 1000
+
+// mgetChunk bounds the keys per MGET so no single command blocks Redis for
+// long; the chunks go out in one pipeline
+const mgetChunk = 500
+
+// hsetChunk bounds the fields per HSET of HSetAll, as mgetChunk does for MGET
+const hsetChunk = 500
+
+// incrByIfExists is INCRBY with the XX semantics of SET: the key is only
+// changed if it exists, and the script returns nil if it does not
+var incrByIfExists = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+return redis.call("INCRBY", KEYS[1], ARGV[1])
+`)
+
+// Keys lists the keys matching pattern with SCAN rather than KEYS, which
+// would block Redis while it walks the whole keyspace
 func (c *DefaultRedisClient) Keys(ctx context.Context, pattern string) ([]string, error) {
-	return c.client.Keys(ctx, pattern).Result()
+	var keys []string
+	err := c.scan(ctx, pattern, func(key string) {
+		keys = append(keys, key)
+	})
+	return keys, err
 }
 
-func NewRedisClient(cfg *config.RedisConfig) RedisClient {
-	options := &redis.Options{
-		Addr:     cfg.Address,
+func (c *DefaultRedisClient) CountKeys(ctx context.Context, pattern string) (int64, error) {
+	var count int64
+	err := c.scan(ctx, pattern, func(string) {
+		count++
+	})
+	return count, err
+}
+
+// scan calls fn for every key matching pattern. A cluster is scanned master
+// by master, as each only holds its own slots; fn is never called
+// concurrently.
+func (c *DefaultRedisClient) scan(ctx context.Context, pattern string, fn func(key string)) error {
+	cluster, ok := c.client.(*redis.ClusterClient)
+	if !ok {
+		iter := c.client.Scan(ctx, 0, pattern, scanCount).Iterator()
+		for iter.Next(ctx) {
+			fn(iter.Val())
+		}
+		return iter.Err()
+	}
+
+	var mu sync.Mutex
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		iter := node.Scan(ctx, 0, pattern, scanCount).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			fn(iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+}
+
+func NewRedisClient(cfg *config.RedisConfig) (RedisClient, error) {
+	options := &redis.UniversalOptions{
+		Username: cfg.Username,
 		Password: cfg.Password,
-		DB:       cfg.DB,
 	}
-	return &DefaultRedisClient{
-		client: redis.NewClient(options),
+	if cfg.TLS.Enabled {
+		tlsConfig, err := redisTLSConfig(&cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options.TLSConfig = tlsConfig
 	}
+
+	var client redis.UniversalClient
+	switch cfg.Mode {
+	case "", "standalone":
+		options.Addrs = []string{cfg.Address}
+		options.DB = cfg.DB
+		client = redis.NewClient(options.Simple())
+	case "sentinel":
+		if cfg.MasterName == "" || len(cfg.SentinelAddrs) == 0 {
+			return nil, errors.New("redis sentinel mode needs a master name and sentinel addresses")
+		}
+		options.Addrs = cfg.SentinelAddrs
+		options.MasterName = cfg.MasterName
+		options.SentinelUsername = cfg.SentinelUsername
+		options.SentinelPassword = cfg.SentinelPassword
+		options.DB = cfg.DB
+		client = redis.NewFailoverClient(options.Failover())
+	case "cluster":
+		options.Addrs = cfg.ClusterAddrs
+		if len(options.Addrs) == 0 {
+			options.Addrs = []string{cfg.Address}
+		}
+		client = redis.NewClusterClient(options.Cluster())
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", cfg.Mode)
+	}
+
+	return &DefaultRedisClient{
+		client: client,
+	}, nil
 }
 
-func (c *DefaultRedisClient) CountKeys(ctx context.Context, pattern string) (int64, error) {
-	keys, err := c.client.Keys(ctx, pattern).Result()
-	if err != nil {
-		return 0, err
+func redisTLSConfig(cfg *config.RedisTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in redis CA file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-	return int64(len(keys)), nil
+	return tlsConfig, nil
 }
 
 func (c *DefaultRedisClient) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
@@ -94,6 +227,17 @@ func (c *DefaultRedisClient) IncrBy(ctx context.Context, key string, value int64
 	return c.client.IncrBy(ctx, key, value).Result()
 }
 
+func (c *DefaultRedisClient) IncrByIfExists(ctx context.Context, key string, value int64) (int64, bool, error) {
+	n, err := incrByIfExists.Run(ctx, c.client, []string{key}, value).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
 func (c *DefaultRedisClient) Decr(ctx context.Context, key string) (int64, error) {
 	return c.client.Decr(ctx, key).Result()
 }
@@ -187,6 +331,52 @@ func (c *DefaultRedisClient) Get(ctx context.Context, key string) (string, error
 	return val, err
 }
 
+func (c *DefaultRedisClient) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values := make([]string, 0, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	// Keys of one MGET must share a hash slot in a cluster, so there each
+	// key is a GET of its own; the pipeline sends them to their nodes
+	if _, ok := c.client.(*redis.ClusterClient); ok {
+		pipe := c.client.Pipeline()
+		cmds := make([]*redis.StringCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		pipe.Exec(ctx)
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil && err != redis.Nil {
+				return nil, err
+			}
+			values = append(values, cmd.Val()) // "" for a missing key
+		}
+		return values, nil
+	}
+
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, 0, (len(keys)+mgetChunk-1)/mgetChunk)
+	for start := 0; start < len(keys); start += mgetChunk {
+		end := start + mgetChunk
+		if end > len(keys) {
+			end = len(keys)
+		}
+		cmds = append(cmds, pipe.MGet(ctx, keys[start:end]...))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range cmds {
+		for _, value := range cmd.Val() {
+			str, _ := value.(string) // nil for a missing key
+			values = append(values, str)
+		}
+	}
+	return values, nil
+}
+
 func (c *DefaultRedisClient) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
 	return c.client.Set(ctx, key, value, ttl).Err()
 }
@@ -200,6 +390,27 @@ func (c *DefaultRedisClient) HSet(ctx context.Context, key, field, value string)
 	return c.client.HSet(ctx, key, field, value).Err()
 }
 
+func (c *DefaultRedisClient) HSetAll(ctx context.Context, key string, fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	pipe := c.client.Pipeline()
+	args := make([]interface{}, 0, 2*min(len(fields), hsetChunk))
+	for field, value := range fields {
+		args = append(args, field, value)
+		if len(args) == 2*hsetChunk {
+			pipe.HSet(ctx, key, args...)
+			args = make([]interface{}, 0, 2*hsetChunk)
+		}
+	}
+	if len(args) > 0 {
+		pipe.HSet(ctx, key, args...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
 func (c *DefaultRedisClient) HGet(ctx context.Context, key, field string) (string, error) {
 	val, err := c.client.HGet(ctx, key, field).Result()
 	if err == redis.Nil {
@@ -238,3 +449,47 @@ func (c *DefaultRedisClient) SMembers(ctx context.Context, key string) ([]string
 func (c *DefaultRedisClient) SIsMember(ctx context.Context, key, member string) (bool, error) {
 	return c.client.SIsMember(ctx, key, member).Result()
 }
+
+// Pub/sub
+func (c *DefaultRedisClient) Publish(ctx context.Context, channel, message string) error {
+	return c.client.Publish(ctx, channel, message).Err()
+}
+
+func (c *DefaultRedisClient) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	ps := c.client.Subscribe(ctx, channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	messages := make(chan string, 64)
+	go func() {
+		defer close(messages)
+		defer ps.Close()
+
+		// Subscriptions seen from here on are the client re-subscribing
+		// after a reconnect
+		in := ps.ChannelWithSubscriptions(ctx, 64)
+		for {
+			var message string
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-in:
+				if !ok {
+					return
+				}
+				if msg, ok := m.(*redis.Message); ok {
+					message = msg.Payload
+				}
+			}
+
+			select {
+			case messages <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
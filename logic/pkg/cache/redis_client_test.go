@@ -0,0 +1,49 @@
+package cache_test
+
+import (
+	"context"
+	"os"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Tests that need a real Redis run against REDIS_TEST_ADDR, with
+// REDIS_PASSWORD if it is set, and are skipped when it is unset or the
+// server does not answer. The keys they write have prefixes of their own.
+
+func testRedisConfig(tb testing.TB) *config.RedisConfig {
+	tb.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		tb.Skip("REDIS_TEST_ADDR is not set")
+	}
+	cfg := &config.RedisConfig{Address: addr, Password: os.Getenv("REDIS_PASSWORD")}
+
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Address, Password: cfg.Password})
+	defer rdb.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		tb.Skipf("no Redis at %s: %v", addr, err)
+	}
+	return cfg
+}
+
+// testRedis returns a raw client and the repo's client for the Redis the
+// tests run against
+func testRedis(tb testing.TB) (*redis.Client, cache.RedisClient) {
+	tb.Helper()
+	cfg := testRedisConfig(tb)
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Address, Password: cfg.Password})
+	tb.Cleanup(func() { rdb.Close() })
+
+	client, err := cache.NewRedisClient(cfg)
+	if err != nil {
+		tb.Fatalf("NewRedisClient() error = %v", err)
+	}
+	return rdb, client
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"pdm-logic-server/pkg/cache/keys"
+	"time"
+)
+
+// resubscribeDelay is how long Listen waits before subscribing again after
+// a failure
+const resubscribeDelay = 5 * time.Second
+
+// invalidation is published on the invalidation channel after a write to a
+// key of a local family. Field is set when only one hash field changed.
+type invalidation struct {
+	Origin string  `json:"origin"`
+	Key    string  `json:"key"`
+	Field  *string `json:"field,omitempty"`
+}
+
+// NewTieredCache returns a RedisCache that also keeps the values of keys in
+// families in local, in front of Redis. Writes through it invalidate the
+// local copies and are announced on channel, so every instance running
+// Listen drops its copies too. Reads from local are reported to observer,
+// which may be nil.
+func NewTieredCache(client RedisClient, schema *keys.Schema, local *LocalCache, families []LocalFamily, channel string, observer Observer) *RedisCache {
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		panic(err)
+	}
+	return &RedisCache{
+		client:   client,
+		Schema:   schema,
+		local:    local,
+		families: families,
+		channel:  channel,
+		origin:   hex.EncodeToString(origin),
+		observer: observer,
+	}
+}
+
+// lookupLocal reports a read of key from the local tier to the observer, if
+// there is one
+func (r *RedisCache) lookupLocal(op, key string, hit bool) {
+	if r.observer != nil {
+		r.observer.ObserveCacheLookup(TierLocal, op, KeyFamily(key), hit)
+	}
+}
+
+// localTTL returns how long key may be kept locally, 0 for not at all
+func (r *RedisCache) localTTL(key string) time.Duration {
+	if r.local == nil {
+		return 0
+	}
+	for _, family := range r.families {
+		if globMatch(family.Pattern, key) {
+			return family.TTL
+		}
+	}
+	return 0
+}
+
+// written invalidates the local copies of key, or of one of its hash fields,
+// here and on the other instances
+func (r *RedisCache) written(ctx context.Context, key string, field *string) {
+	if r.localTTL(key) == 0 {
+		return
+	}
+	r.local.invalidate(key, field)
+
+	msg, err := json.Marshal(invalidation{Origin: r.origin, Key: key, Field: field})
+	if err != nil {
+		log.Printf("Failed to marshal cache invalidation: %v", err)
+		return
+	}
+	if err := r.client.Publish(ctx, r.channel, string(msg)); err != nil {
+		log.Printf("Failed to publish cache invalidation for %s: %v", key, err)
+	}
+}
+
+// Listen applies the invalidations published by other instances until ctx
+// is done. Whenever some may have been missed, while not subscribed, the
+// local cache is cleared; the family TTLs bound how stale it can get.
+func (r *RedisCache) Listen(ctx context.Context) {
+	if r.local == nil {
+		return
+	}
+	for ctx.Err() == nil {
+		messages, err := r.client.Subscribe(ctx, r.channel)
+		if err != nil {
+			log.Printf("Failed to subscribe to cache invalidations: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(resubscribeDelay):
+			}
+			continue
+		}
+
+		r.local.clear()
+		for message := range messages {
+			if message == "" {
+				r.local.clear()
+				continue
+			}
+			var inv invalidation
+			if err := json.Unmarshal([]byte(message), &inv); err != nil {
+				log.Printf("Invalid cache invalidation %q: %v", message, err)
+				continue
+			}
+			if inv.Origin != r.origin {
+				r.local.invalidate(inv.Key, inv.Field)
+			}
+		}
+	}
+}
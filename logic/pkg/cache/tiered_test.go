@@ -0,0 +1,68 @@
+package cache_test
+
+import (
+	"context"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lookupRecorder keeps the lookups reported to it as tier/op/result
+type lookupRecorder struct {
+	lookups []string
+}
+
+func (r *lookupRecorder) ObserveCacheOperation(op, family string, took time.Duration, err error) {}
+
+func (r *lookupRecorder) ObserveCacheLookup(tier, op, family string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	r.lookups = append(r.lookups, tier+"/"+op+"/"+result)
+}
+
+func TestTieredCacheLookups(t *testing.T) {
+	ctx := context.Background()
+	observer := &lookupRecorder{}
+	schema := keys.NewSchema(&config.CacheKeysConfig{Version: 1})
+	client := cache.NewInstrumentedClient(cache.NewMemoryClient(), observer)
+	families := []cache.LocalFamily{{Pattern: schema.UserInfo("*"), TTL: time.Minute}}
+	c := cache.NewTieredCache(client, schema, cache.NewLocalCache(1<<20), families, "", observer)
+
+	local := schema.UserInfo("u1")
+	if err := client.HSet(ctx, local, "name", "Ada"); err != nil {
+		t.Fatalf("HSet() error = %v", err)
+	}
+	observer.lookups = nil
+
+	tests := []struct {
+		name  string
+		read  func() (string, error)
+		want  string
+		calls []string
+	}{
+		{name: "local miss", read: func() (string, error) { return c.HGet(ctx, local, "name") }, want: "Ada", calls: []string{"local/hget/miss", "redis/hget/hit"}},
+		{name: "local hit", read: func() (string, error) { return c.HGet(ctx, local, "name") }, want: "Ada", calls: []string{"local/hget/hit"}},
+		{name: "absent", read: func() (string, error) { return c.HGet(ctx, local, "email") }, calls: []string{"local/hget/miss", "redis/hget/miss"}},
+		{name: "not kept locally", read: func() (string, error) { return c.Get(ctx, schema.NotesVersion("u1")) }, calls: []string{"redis/get/miss"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer.lookups = nil
+			got, err := tt.read()
+			if err != nil {
+				t.Fatalf("read error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("read = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(observer.lookups, tt.calls) {
+				t.Errorf("lookups = %v, want %v", observer.lookups, tt.calls)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/joho/godotenv"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,11 +32,25 @@ type Config struct {
 	Server        ServerConfig
 	StaticContent StaticContentConfig
 	Redis         RedisConfig
+	CacheKeys     CacheKeysConfig
+	LocalCache    LocalCacheConfig
+	Stampede      StampedeConfig
+	RabbitMQ      RabbitMQConfig
 	Database      DatabaseConfig
 	Auth          AuthConfig
 	Email         EmailConfig
 	Logging       LogConfig
 	Metrics       MetricsConfig
+	Compression   CompressionConfig
+	Blob          BlobConfig
+	Attachments   AttachmentConfig
+	Export        ExportConfig
+	Import        ImportConfig
+	Integrity     IntegrityConfig
+	Quota         QuotaConfig
+	Reminders     ReminderConfig
+	Idempotency   IdempotencyConfig
+	Admin         AdminConfig
 }
 
 func (c Config) GetEnv(env string) interface{} {
@@ -46,12 +61,68 @@ func (c Config) GetEnv(env string) interface{} {
 }
 
 type RedisConfig struct {
-	Address              string        // Redis server address
-	Password             string        // Redis password
-	DB                   int           // Redis database number
-	Timeout              time.Duration // Operation timeout
-	NotesCacheTTLMinutes int           // Notes cache TTL in minutes
-	InfoCacheTTLMinutes  int           // Info cache TTL in minutes
+	Backend          string         // "redis", or "memory" for a single node without Redis
+	Mode             string         // "standalone", "sentinel" or "cluster"
+	Address          string         // Redis server address, for standalone
+	ClusterAddrs     []string       // Seed nodes of a cluster; Address if empty
+	MasterName       string         // Sentinel master name
+	SentinelAddrs    []string       // Sentinel addresses
+	SentinelUsername string         // Sentinel ACL user
+	SentinelPassword string         // Sentinel password
+	Username         string         // Redis ACL user
+	Password         string         // Redis password
+	DB               int            // Redis database number, not for cluster
+	TLS              RedisTLSConfig // Optional TLS to Redis
+	Timeout          time.Duration  // Operation timeout
+}
+
+// RedisTLSConfig enables TLS to Redis. The files are PEM; without a CA the
+// system roots are used, and a client certificate is optional.
+type RedisTLSConfig struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// CacheKeysConfig versions the cache keys and sets the TTL of each family of
+// them; see cache/keys
+type CacheKeysConfig struct {
+	Version         int           // Prefixed to cache keys; bump it to start on an empty cache
+	NotesTTL        time.Duration // Cached notes, their index and the loaded marker
+	PendingNotesTTL time.Duration // Cached note writes not yet confirmed by the database
+	NotesVersionTTL time.Duration // Only bounds how long an idle user's ETag version lingers
+	UserInfoTTL     time.Duration // Cached user info
+	UsageTTL        time.Duration // How long tracked quota usage is trusted
+}
+
+// LocalCacheConfig sets up the in-process cache in front of Redis for hot
+// keys; see cache.NewTieredCache
+type LocalCacheConfig struct {
+	Enabled     bool
+	MaxBytes    int64         // Approximate memory bound of the local cache
+	Channel     string        // Redis pub/sub channel for invalidations
+	UserIDTTL   time.Duration // For userEmail:userId
+	UserInfoTTL time.Duration // For user:<id>:userinfo
+}
+
+// StampedeConfig protects the database from concurrent cache misses for the
+// same key; see services/stampede.go
+type StampedeConfig struct {
+	LockTTL       time.Duration // Longest an instance holds a rebuild lock
+	Wait          time.Duration // How long a miss waits for another instance's rebuild
+	RefreshBeta   float64       // Eagerness of early refresh; 0 disables it
+	RefreshWindow time.Duration // Least rebuild time early refresh assumes
+}
+
+// RabbitMQConfig sets how the logic server publishes to the sync server;
+// the connection itself is set up from RABBITMQ_* in InitRabbitMQ
+type RabbitMQConfig struct {
+	ConfirmTimeout    time.Duration // How long a dispatch waits for the broker to confirm it
+	ReconnectMinDelay time.Duration // Wait before the first attempt to reconnect
+	ReconnectMaxDelay time.Duration // Cap of the doubling wait between attempts
 }
 
 type StaticContentConfig struct {
@@ -77,7 +148,87 @@ type MetricsConfig struct {
 	Path    string
 }
 
+type CompressionConfig struct {
+	Enabled  bool // Compress responses with zstd or gzip when the client accepts it
+	MinBytes int  // Responses shorter than this are sent uncompressed
+}
+
+type BlobConfig struct {
+	Backend     string // "local" or "s3"
+	LocalPath   string // Root directory for the local backend
+	S3Endpoint  string // host:port of the S3-compatible endpoint
+	S3AccessKey string
+	S3SecretKey string
+	S3Bucket    string
+	S3Region    string
+	S3UseSSL    bool
+}
+
+type AttachmentConfig struct {
+	MaxFileSize  int64         // Largest single attachment in bytes
+	ChunkSize    int64         // Largest upload chunk in bytes
+	QuotaBytes   int64         // Attachment bytes allowed per user
+	URLTTL       time.Duration // Lifetime of signed download URLs
+	PublicOrigin string        // Scheme and host used to build download URLs
+}
+
+type ExportConfig struct {
+	SyncMaxNotes int           // Accounts with more notes are exported in the background
+	URLTTL       time.Duration // Lifetime of emailed download links
+}
+
+type ImportConfig struct {
+	MaxBytes             int64 // Largest accepted upload
+	MaxNotes             int   // Most notes accepted from one upload
+	MaxNoteBytes         int64 // Largest imported note, heading and content together
+	MaxUncompressedBytes int64 // Most bytes read out of an uploaded zip
+}
+
+// QuotaLimits bound what a single user may store. Zero disables a limit.
+type QuotaLimits struct {
+	MaxNoteBytes int64 // Largest note, heading and content together
+	MaxNotes     int   // Most notes, deleted ones included
+	MaxBytes     int64 // Total size of all notes
+}
+
+type QuotaConfig struct {
+	Default         QuotaLimits
+	Tiers           map[string]QuotaLimits // Overrides keyed by userinfo.product
+	MaxRequestBytes int64                  // Largest note write request body
+}
+
+// For returns the limits of a product tier, falling back to the default
+func (q *QuotaConfig) For(product string) QuotaLimits {
+	if limits, ok := q.Tiers[product]; ok {
+		return limits
+	}
+	return q.Default
+}
+
+type ReminderConfig struct {
+	Enabled      bool          // Run the reminder scheduler in this instance
+	PollInterval time.Duration // How often due reminders are looked up
+	LockTTL      time.Duration // How long an instance owns a firing; also the retry delay
+	BatchSize    int           // Most reminders fired per poll
+	NoteURL      string        // Link to the note in reminder emails; {noteid} is replaced by its ID
+}
+
+type IdempotencyConfig struct {
+	TTL              time.Duration // How long a response is replayed for its key
+	PendingTTL       time.Duration // How long a key is held while its request runs
+	MaxResponseBytes int64         // Larger responses are not kept
+}
+
+type IntegrityConfig struct {
+	RequireVersioned bool // Reject notes whose h/intgrh predate versioned digests
+}
+
+type AdminConfig struct {
+	Token string // Bearer token for /admin routes; empty disables them
+}
+
 type EmailConfig struct {
+	From               string
 	ApiKey             string
 	TurnstileSiteKey   string
 	TurnstileSecretKey string
@@ -122,25 +273,146 @@ func LoadConfig() (*Config, error) {
 			Enabled: getBoolOrDefault("METRICS_ENABLED", true),
 			Path:    getEnvOrDefault("METRICS_PATH", "/metrics"),
 		},
+		Compression: CompressionConfig{
+			Enabled:  getBoolOrDefault("COMPRESSION_ENABLED", true),
+			MinBytes: getIntOrDefault("COMPRESSION_MIN_BYTES", 1024),
+		},
 		Auth: AuthConfig{
 			PublicKey:  publicKey,
 			PrivateKey: privateKey,
 		},
 		Email: EmailConfig{
+			From:               getEnvOrDefault("EMAIL_FROM", "hi@demomailtrap.com"),
 			ApiKey:             getEnvOrDefault("EMAIL_API_KEY", ""),
 			TurnstileSiteKey:   getEnvOrDefault("CF_TURNSTILE_SITE_KEY", ""),
 			TurnstileSecretKey: getEnvOrDefault("CF_TURNSTILE_SECRET_KEY", ""),
 		},
 		Redis: RedisConfig{
-			Address:              os.Getenv("REDIS_URL"),
-			Password:             os.Getenv("REDIS_PASSWORD"),
-			DB:                   getIntOrDefault("REDIS_DB", 0),
-			NotesCacheTTLMinutes: getIntOrDefault("REDIS_NOTES_CACHE_TTL_MINUTES", 1),
-			InfoCacheTTLMinutes:  getIntOrDefault("REDIS_INFO_CACHE_TTL_MINUTES", 1),
+			Backend:          getEnvOrDefault("REDIS_BACKEND", "redis"),
+			Mode:             getEnvOrDefault("REDIS_MODE", "standalone"),
+			Address:          os.Getenv("REDIS_URL"),
+			ClusterAddrs:     getListOrDefault("REDIS_CLUSTER_ADDRS", nil),
+			MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+			SentinelAddrs:    getListOrDefault("REDIS_SENTINEL_ADDRS", nil),
+			SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+			SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+			Username:         os.Getenv("REDIS_USERNAME"),
+			Password:         os.Getenv("REDIS_PASSWORD"),
+			DB:               getIntOrDefault("REDIS_DB", 0),
+			TLS: RedisTLSConfig{
+				Enabled:            getBoolOrDefault("REDIS_TLS", false),
+				CAFile:             os.Getenv("REDIS_TLS_CA_FILE"),
+				CertFile:           os.Getenv("REDIS_TLS_CERT_FILE"),
+				KeyFile:            os.Getenv("REDIS_TLS_KEY_FILE"),
+				ServerName:         os.Getenv("REDIS_TLS_SERVER_NAME"),
+				InsecureSkipVerify: getBoolOrDefault("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+			},
+		},
+		CacheKeys: CacheKeysConfig{
+			Version:         getIntOrDefault("CACHE_SCHEMA_VERSION", 1),
+			NotesTTL:        time.Duration(getIntOrDefault("REDIS_NOTES_CACHE_TTL_MINUTES", 1)) * time.Minute,
+			PendingNotesTTL: getDurationOrDefault("CACHE_PENDING_NOTES_TTL", 24*time.Hour),
+			NotesVersionTTL: getDurationOrDefault("CACHE_NOTES_VERSION_TTL", 30*24*time.Hour),
+			UserInfoTTL:     time.Duration(getIntOrDefault("REDIS_INFO_CACHE_TTL_MINUTES", 1)) * time.Minute,
+			UsageTTL:        getDurationOrDefault("CACHE_USAGE_TTL", time.Hour),
+		},
+		RabbitMQ: RabbitMQConfig{
+			ConfirmTimeout:    getDurationOrDefault("RABBITMQ_CONFIRM_TIMEOUT", 5*time.Second),
+			ReconnectMinDelay: getDurationOrDefault("RABBITMQ_RECONNECT_MIN_DELAY", 500*time.Millisecond),
+			ReconnectMaxDelay: getDurationOrDefault("RABBITMQ_RECONNECT_MAX_DELAY", 30*time.Second),
+		},
+		LocalCache: LocalCacheConfig{
+			Enabled:     getBoolOrDefault("LOCAL_CACHE_ENABLED", true),
+			MaxBytes:    getInt64OrDefault("LOCAL_CACHE_MAX_BYTES", 32<<20),
+			Channel:     getEnvOrDefault("LOCAL_CACHE_CHANNEL", "cache:invalidate"),
+			UserIDTTL:   getDurationOrDefault("LOCAL_CACHE_USER_ID_TTL", 5*time.Minute),
+			UserInfoTTL: getDurationOrDefault("LOCAL_CACHE_USER_INFO_TTL", 30*time.Second),
+		},
+		Stampede: StampedeConfig{
+			LockTTL:       getDurationOrDefault("CACHE_REBUILD_LOCK_TTL", 10*time.Second),
+			Wait:          getDurationOrDefault("CACHE_REBUILD_WAIT", 2*time.Second),
+			RefreshBeta:   getFloatOrDefault("CACHE_EARLY_REFRESH_BETA", 1),
+			RefreshWindow: getDurationOrDefault("CACHE_EARLY_REFRESH_WINDOW", time.Second),
+		},
+		Blob: BlobConfig{
+			Backend:     getEnvOrDefault("BLOB_BACKEND", "local"),
+			LocalPath:   getEnvOrDefault("BLOB_LOCAL_PATH", "./data/blobs"),
+			S3Endpoint:  getEnvOrDefault("BLOB_S3_ENDPOINT", "localhost:9000"),
+			S3AccessKey: os.Getenv("BLOB_S3_ACCESS_KEY"),
+			S3SecretKey: os.Getenv("BLOB_S3_SECRET_KEY"),
+			S3Bucket:    getEnvOrDefault("BLOB_S3_BUCKET", "pdm-attachments"),
+			S3Region:    getEnvOrDefault("BLOB_S3_REGION", "us-east-1"),
+			S3UseSSL:    getBoolOrDefault("BLOB_S3_USE_SSL", false),
+		},
+		Attachments: AttachmentConfig{
+			MaxFileSize:  getInt64OrDefault("ATTACHMENT_MAX_FILE_BYTES", 50<<20),
+			ChunkSize:    getInt64OrDefault("ATTACHMENT_CHUNK_BYTES", 5<<20),
+			QuotaBytes:   getInt64OrDefault("ATTACHMENT_QUOTA_BYTES", 1<<30),
+			URLTTL:       getDurationOrDefault("ATTACHMENT_URL_TTL", 15*time.Minute),
+			PublicOrigin: getEnvOrDefault("PUBLIC_ORIGIN", ""),
+		},
+		Export: ExportConfig{
+			SyncMaxNotes: getIntOrDefault("EXPORT_SYNC_MAX_NOTES", 500),
+			URLTTL:       getDurationOrDefault("EXPORT_URL_TTL", 24*time.Hour),
+		},
+		Import: ImportConfig{
+			MaxBytes:             getInt64OrDefault("IMPORT_MAX_BYTES", 100<<20),
+			MaxNotes:             getIntOrDefault("IMPORT_MAX_NOTES", 20000),
+			MaxNoteBytes:         getInt64OrDefault("IMPORT_MAX_NOTE_BYTES", 10<<20),
+			MaxUncompressedBytes: getInt64OrDefault("IMPORT_MAX_UNCOMPRESSED_BYTES", 1<<30),
+		},
+		Quota: loadQuotaConfig(),
+		Reminders: ReminderConfig{
+			Enabled:      getBoolOrDefault("REMINDERS_ENABLED", true),
+			PollInterval: getDurationOrDefault("REMINDERS_POLL_INTERVAL", 15*time.Second),
+			LockTTL:      getDurationOrDefault("REMINDERS_LOCK_TTL", 2*time.Minute),
+			BatchSize:    getIntOrDefault("REMINDERS_BATCH_SIZE", 100),
+			NoteURL:      getEnvOrDefault("REMINDERS_NOTE_URL", getEnvOrDefault("PUBLIC_ORIGIN", "")+"/notes/{noteid}"),
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:              getDurationOrDefault("IDEMPOTENCY_TTL", 24*time.Hour),
+			PendingTTL:       getDurationOrDefault("IDEMPOTENCY_PENDING_TTL", time.Minute),
+			MaxResponseBytes: getInt64OrDefault("IDEMPOTENCY_MAX_RESPONSE_BYTES", 1<<20),
+		},
+		Integrity: IntegrityConfig{
+			RequireVersioned: getBoolOrDefault("INTEGRITY_REQUIRE_VERSIONED", false),
+		},
+		Admin: AdminConfig{
+			Token: os.Getenv("ADMIN_TOKEN"),
 		},
 	}, nil
 }
 
+// loadQuotaConfig reads the default limits from QUOTA_MAX_* and one set of
+// overrides per tier listed in QUOTA_TIERS, e.g. QUOTA_TIERS=pro with
+// QUOTA_PRO_MAX_NOTES=100000.
+func loadQuotaConfig() QuotaConfig {
+	quota := QuotaConfig{
+		Default: QuotaLimits{
+			MaxNoteBytes: getInt64OrDefault("QUOTA_MAX_NOTE_BYTES", 1<<20),
+			MaxNotes:     getIntOrDefault("QUOTA_MAX_NOTES", 10000),
+			MaxBytes:     getInt64OrDefault("QUOTA_MAX_BYTES", 100<<20),
+		},
+		Tiers:           make(map[string]QuotaLimits),
+		MaxRequestBytes: getInt64OrDefault("QUOTA_MAX_REQUEST_BYTES", 16<<20),
+	}
+
+	for _, tier := range strings.Split(os.Getenv("QUOTA_TIERS"), ",") {
+		tier = strings.TrimSpace(tier)
+		if tier == "" {
+			continue
+		}
+		prefix := "QUOTA_" + strings.ToUpper(tier) + "_"
+		quota.Tiers[tier] = QuotaLimits{
+			MaxNoteBytes: getInt64OrDefault(prefix+"MAX_NOTE_BYTES", quota.Default.MaxNoteBytes),
+			MaxNotes:     getIntOrDefault(prefix+"MAX_NOTES", quota.Default.MaxNotes),
+			MaxBytes:     getInt64OrDefault(prefix+"MAX_BYTES", quota.Default.MaxBytes),
+		}
+	}
+
+	return quota
+}
+
 func loadKeys() (ed25519.PrivateKey, ed25519.PublicKey, error) {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
@@ -211,3 +483,35 @@ func getIntOrDefault(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getInt64OrDefault(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getFloatOrDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+// getListOrDefault reads a comma-separated list, skipping empty items
+func getListOrDefault(key string, defaultValue []string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return defaultValue
+	}
+	return list
+}
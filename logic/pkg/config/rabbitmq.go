@@ -30,26 +30,20 @@ func InitRabbitMQ() (*amqp.Connection, *amqp.Channel, error) {
 		return nil, nil, err
 	}
 
-	// Declare the queue
-	_, err = ch.QueueDeclare(
-		"logic_to_sync", // Queue name
-		true,            // Durable
-		false,           // Delete when unused
-		false,           // Exclusive
-		false,           // No-wait
-		nil,             // Arguments
-	)
-	// Declare the queue
-	_, err = ch.QueueDeclare(
-		"logic_to_sync", // Queue name
-		true,            // Durable
-		false,           // Delete when unused
-		false,           // Exclusive
-		false,           // No-wait
-		nil,             // Arguments
-	)
-	if err != nil {
-		return nil, nil, err
+	// Declare the queues: tasks for the sync server, and its notices of the
+	// note writes it rejected
+	for _, queue := range []string{"logic_to_sync", "sync_to_logic"} {
+		_, err = ch.QueueDeclare(
+			queue, // Queue name
+			true,  // Durable
+			false, // Delete when unused
+			false, // Exclusive
+			false, // No-wait
+			nil,   // Arguments
+		)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	log.Println("RabbitMQ initialized for Logic Server")
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"pdm-logic-server/pkg/models"
+)
+
+type AdminHandler struct {
+	*BaseHandler
+}
+
+func NewAdminHandler(base *BaseHandler) *AdminHandler {
+	return &AdminHandler{BaseHandler: base}
+}
+
+// StartIntegrityAudit starts a scan of all stored notes for digests that no
+// longer match. Progress is reported at /admin/jobs/:jobId.
+func (h *AdminHandler) StartIntegrityAudit(c echo.Context) error {
+	ctx := context.Background()
+
+	job, err := h.storage.StartIntegrityAudit(ctx)
+	if err != nil {
+		return jobError(err)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (h *AdminHandler) GetJob(c echo.Context) error {
+	ids, err := pathIDs(c, "jobId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	job, err := h.storage.GetJob(ctx, models.AdminJobOwner, ids[0])
+	if err != nil {
+		return jobError(err)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// IntegrityReport serves the report of a finished integrity audit
+func (h *AdminHandler) IntegrityReport(c echo.Context) error {
+	ids, err := pathIDs(c, "jobId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	report, err := h.storage.OpenIntegrityReport(ctx, ids[0])
+	if err != nil {
+		return jobError(err)
+	}
+	defer report.Close()
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(c.Response(), report)
+	return err
+}
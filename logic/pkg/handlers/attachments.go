@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"net/url"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/services"
+	"pdm-logic-server/pkg/util"
+	"strconv"
+	"time"
+)
+
+type AttachmentsHandler struct {
+	*BaseHandler
+}
+
+func NewAttachmentsHandler(base *BaseHandler) *AttachmentsHandler {
+	return &AttachmentsHandler{BaseHandler: base}
+}
+
+// attachmentError maps storage errors to HTTP errors
+func attachmentError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, services.ErrNoteNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Note not found", err)
+	case stderrors.Is(err, services.ErrAttachmentNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Attachment not found", err)
+	case stderrors.Is(err, services.ErrFileTooLarge), stderrors.Is(err, services.ErrChunkTooLarge):
+		return errors.NewAppError(http.StatusRequestEntityTooLarge, err.Error(), err)
+	case stderrors.Is(err, services.ErrQuotaExceeded):
+		return errors.NewAppError(http.StatusInsufficientStorage, err.Error(), err)
+	case stderrors.Is(err, services.ErrChunkOffset), stderrors.Is(err, services.ErrUploadComplete):
+		return errors.NewAppError(http.StatusConflict, err.Error(), err)
+	case stderrors.Is(err, services.ErrHashMismatch):
+		return errors.NewAppError(http.StatusUnprocessableEntity, err.Error(), err)
+	default:
+		return errors.NewAppError(http.StatusInternalServerError, "Attachment operation failed", err)
+	}
+}
+
+// pathIDs reads and validates the note and attachment IDs from the route
+func pathIDs(c echo.Context, names ...string) ([]string, error) {
+	ids := make([]string, len(names))
+	for i, name := range names {
+		ids[i] = c.Param(name)
+		if !util.IsUUID(ids[i]) {
+			return nil, errors.NewAppError(http.StatusBadRequest, fmt.Sprintf("Invalid %s", name), nil)
+		}
+	}
+	return ids, nil
+}
+
+func (h *AttachmentsHandler) CreateAttachment(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var req models.CreateAttachmentRequest
+	if err := c.Bind(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request data", err)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	attachment, err := h.storage.CreateAttachment(ctx, userId, ids[0], req, &h.config.Attachments)
+	if err != nil {
+		return attachmentError(err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"attachment": attachment,
+		"chunkSize":  h.config.Attachments.ChunkSize,
+	})
+}
+
+func (h *AttachmentsHandler) ListAttachments(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	attachments, err := h.storage.ListAttachments(ctx, userId, ids[0])
+	if err != nil {
+		return attachmentError(err)
+	}
+
+	return c.JSON(http.StatusOK, attachments)
+}
+
+// GetAttachment returns the attachment metadata; uploadedSize tells a client
+// where to resume an interrupted upload.
+func (h *AttachmentsHandler) GetAttachment(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "attachmentId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	attachment, err := h.storage.GetAttachment(ctx, userId, ids[0], ids[1])
+	if err != nil {
+		return attachmentError(err)
+	}
+
+	return c.JSON(http.StatusOK, attachment)
+}
+
+// UploadChunk accepts the raw bytes of the next chunk. The Upload-Offset
+// header carries the byte offset of the chunk and the optional
+// X-Chunk-SHA256 header its hex encoded SHA-256.
+func (h *AttachmentsHandler) UploadChunk(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "attachmentId")
+	if err != nil {
+		return err
+	}
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid Upload-Offset header", err)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	attachment, err := h.storage.UploadAttachmentChunk(ctx, userId, ids[0], ids[1], offset,
+		c.Request().Header.Get("X-Chunk-SHA256"), c.Request().Body, &h.config.Attachments)
+	if err != nil {
+		if stderrors.Is(err, services.ErrChunkOffset) || stderrors.Is(err, services.ErrHashMismatch) {
+			// Tell the client where to resume
+			return c.JSON(attachmentError(err).Code, map[string]interface{}{
+				"message":    err.Error(),
+				"attachment": attachment,
+			})
+		}
+		return attachmentError(err)
+	}
+
+	return c.JSON(http.StatusOK, attachment)
+}
+
+// DownloadURL returns an expiring signed URL for a completed attachment
+func (h *AttachmentsHandler) DownloadURL(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "attachmentId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	attachment, err := h.storage.GetAttachment(ctx, userId, ids[0], ids[1])
+	if err != nil {
+		return attachmentError(err)
+	}
+	if attachment.Status != models.AttachmentComplete {
+		return errors.NewAppError(http.StatusConflict, "Attachment upload is not complete", nil)
+	}
+
+	expires := time.Now().Add(h.config.Attachments.URLTTL).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", h.authService.SignURL(attachment.ID, expires))
+
+	origin := h.config.Attachments.PublicOrigin
+	if origin == "" {
+		origin = c.Scheme() + "://" + c.Request().Host
+	}
+
+	return c.JSON(http.StatusOK, models.AttachmentURLResponse{
+		URL:       fmt.Sprintf("%s/attachments/%s?%s", origin, attachment.ID, query.Encode()),
+		ExpiresAt: expires,
+	})
+}
+
+// Download serves attachment content to holders of a signed URL
+func (h *AttachmentsHandler) Download(c echo.Context) error {
+	ids, err := pathIDs(c, "attachmentId")
+	if err != nil {
+		return err
+	}
+
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil || !h.authService.VerifyURL(ids[0], expires, c.QueryParam("sig")) {
+		return errors.NewAppError(http.StatusForbidden, "Invalid or expired link", nil)
+	}
+
+	ctx := context.Background()
+
+	attachment, content, err := h.storage.OpenAttachment(ctx, ids[0])
+	if err != nil {
+		return attachmentError(err)
+	}
+	defer content.Close()
+
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s", attachment.Filename, url.PathEscape(attachment.Filename)))
+	c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(attachment.Size, 10))
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	c.Response().Header().Set("Content-Security-Policy", "sandbox")
+	c.Response().Header().Set(echo.HeaderContentType, attachment.ContentType)
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(c.Response(), content)
+	return err
+}
+
+func (h *AttachmentsHandler) DeleteAttachment(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "attachmentId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	if err := h.storage.DeleteAttachment(ctx, userId, ids[0], ids[1]); err != nil {
+		return attachmentError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "attachment deleted",
+	})
+}
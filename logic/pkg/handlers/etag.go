@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/services"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// notesETag is the strong ETag of a user's note listing
+func notesETag(version string) string {
+	return `"notes-` + version + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists the
+// ETag. If-None-Match compares weakly, ignoring a W/ prefix; If-Match
+// compares strongly.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the response ETag and reports whether the client's copy,
+// named by If-None-Match, is still current
+func notModified(c echo.Context, etag string) bool {
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Cache-Control", "private, no-cache")
+
+	header := c.Request().Header.Get("If-None-Match")
+	return header != "" && etagMatches(header, etag, true)
+}
+
+// checkNoteIfMatch guards a write to a single note with If-Match against
+// the note's own ETag, so writes to other notes of its owner do not fail it.
+// Requests without the header always pass.
+func (h *BaseHandler) checkNoteIfMatch(ctx context.Context, c echo.Context, ownerID, noteID string) error {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	note, err := h.storage.GetNoteByID(ctx, ownerID, noteID)
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.NewAppError(http.StatusPreconditionFailed, "Note does not exist", nil)
+	}
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to check note version", err)
+	}
+	if !etagMatches(header, services.NoteETag(note), false) {
+		return errors.NewAppError(http.StatusPreconditionFailed, "Note has changed since If-Match", nil)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"net/url"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/services"
+	"strconv"
+	"time"
+)
+
+type ExportHandler struct {
+	*BaseHandler
+}
+
+func NewExportHandler(base *BaseHandler) *ExportHandler {
+	return &ExportHandler{BaseHandler: base}
+}
+
+// Export streams a zip archive of the user's account. Large accounts, or
+// requests with ?async=true, are exported by a background job instead; the
+// response is then 202 with the job, and the user gets an email with a
+// download link once the archive is ready.
+func (h *ExportHandler) Export(c echo.Context) error {
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+
+	async, _ := strconv.ParseBool(c.QueryParam("async"))
+	if !async {
+		count, err := h.storage.CountNotes(ctx, userId)
+		if err != nil {
+			return errors.NewAppError(http.StatusInternalServerError, "Failed to count notes", err)
+		}
+		async = count > h.config.Export.SyncMaxNotes
+	}
+
+	if async {
+		origin := h.config.Attachments.PublicOrigin
+		if origin == "" {
+			origin = c.Scheme() + "://" + c.Request().Host
+		}
+
+		job, err := h.storage.StartExportJob(ctx, userId, func(job models.Job) {
+			h.notifyExportReady(ctx, job, origin)
+		})
+		if err != nil {
+			return jobError(err)
+		}
+
+		return c.JSON(http.StatusAccepted, models.ExportJobResponse{Job: job})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("pdm-export-%s.zip", time.Now().UTC().Format("20060102"))))
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	c.Response().WriteHeader(http.StatusOK)
+
+	// Headers are sent by now, so a failure can only cut the archive short
+	if _, err := h.storage.WriteExport(ctx, userId, c.Response(), nil); err != nil {
+		h.log.WithError(err).WithField("userId", userId).Error("Export failed while streaming")
+	}
+
+	return nil
+}
+
+func (h *ExportHandler) exportURL(origin, jobID string) (string, time.Time) {
+	expires := time.Now().Add(h.config.Export.URLTTL)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", h.authService.SignURL("export:"+jobID, expires.Unix()))
+
+	return fmt.Sprintf("%s/exports/%s?%s", origin, jobID, query.Encode()), expires
+}
+
+func (h *ExportHandler) notifyExportReady(ctx context.Context, job models.Job, origin string) {
+	if job.Status != models.JobCompleted {
+		return
+	}
+
+	userInfo, err := services.GetUserInfo(h.storage, ctx, job.UserID)
+	if err != nil {
+		h.log.WithError(err).WithField("jobId", job.ID).Error("Failed to look up user of export")
+		return
+	}
+
+	downloadURL, expires := h.exportURL(origin, job.ID)
+	noteCount, _ := strconv.Atoi(job.Result["noteCount"])
+
+	data := models.ExportReadyTemplateData{
+		DownloadURL: downloadURL,
+		ExpiresAt:   expires.UTC(),
+		NoteCount:   noteCount,
+	}
+	if err := services.SendExportReadyEmail(h.config.Email.From, userInfo.Email, data, h.config.Email.ApiKey); err != nil {
+		h.log.WithError(err).WithField("jobId", job.ID).Error("Failed to send export email")
+	}
+}
+
+// ExportURL returns a fresh download link for a completed export job, for
+// clients that poll the job instead of waiting for the email.
+func (h *ExportHandler) ExportURL(c echo.Context) error {
+	ids, err := pathIDs(c, "jobId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	job, err := h.storage.GetJob(ctx, userId, ids[0])
+	if err != nil {
+		return jobError(err)
+	}
+	if job.Type != models.JobTypeExport {
+		return errors.NewAppError(http.StatusNotFound, "Export not found", nil)
+	}
+	if job.Status != models.JobCompleted {
+		return errors.NewAppError(http.StatusConflict, "Export is not complete", nil)
+	}
+
+	origin := h.config.Attachments.PublicOrigin
+	if origin == "" {
+		origin = c.Scheme() + "://" + c.Request().Host
+	}
+
+	downloadURL, expires := h.exportURL(origin, job.ID)
+
+	return c.JSON(http.StatusOK, models.AttachmentURLResponse{
+		URL:       downloadURL,
+		ExpiresAt: expires.Unix(),
+	})
+}
+
+// Download serves an export archive to holders of a signed URL
+func (h *ExportHandler) Download(c echo.Context) error {
+	ids, err := pathIDs(c, "jobId")
+	if err != nil {
+		return err
+	}
+
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil || !h.authService.VerifyURL("export:"+ids[0], expires, c.QueryParam("sig")) {
+		return errors.NewAppError(http.StatusForbidden, "Invalid or expired link", nil)
+	}
+
+	ctx := context.Background()
+
+	job, content, err := h.storage.OpenExport(ctx, ids[0])
+	if err != nil {
+		return jobError(err)
+	}
+	defer content.Close()
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("pdm-export-%s.zip", job.UpdatedAt.Format("20060102"))))
+	if size := job.Result["size"]; size != "" {
+		c.Response().Header().Set(echo.HeaderContentLength, size)
+	}
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(c.Response(), content)
+	return err
+}
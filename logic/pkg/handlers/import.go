@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"os"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/importer"
+	"pdm-logic-server/pkg/models"
+)
+
+// importFormOverhead is what the multipart form may add to an upload of
+// the largest accepted size: boundaries, part headers and the format field
+const importFormOverhead = 64 << 10
+
+type ImportHandler struct {
+	*BaseHandler
+}
+
+func NewImportHandler(base *BaseHandler) *ImportHandler {
+	return &ImportHandler{BaseHandler: base}
+}
+
+// Import accepts a multipart upload in the "file" field: a zip of Markdown
+// files, an Evernote .enex export (or a zip of them) or a Google Keep
+// Takeout zip. The format is detected unless given in the "format" field.
+// Notes are created by a background job whose progress is reported at
+// /api/jobs/:jobId.
+func (h *ImportHandler) Import(c echo.Context) error {
+	// The multipart form is spooled before the file can be looked at, so the
+	// body is cut off past the limit instead of being read to the end
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, h.config.Import.MaxBytes+importFormOverhead)
+	if _, err := c.MultipartForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			return errors.NewAppError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Upload exceeds the limit of %d bytes", h.config.Import.MaxBytes), nil)
+		}
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	format, err := importer.ParseFormat(c.FormValue("format"))
+	if err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Unknown import format", err)
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+	if header.Size > h.config.Import.MaxBytes {
+		return errors.NewAppError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Upload exceeds the limit of %d bytes", h.config.Import.MaxBytes), nil)
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+	defer src.Close()
+
+	// The multipart file is removed when the request ends, so the job gets its own copy
+	tmp, err := os.CreateTemp("", "pdm-import-*")
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to store upload", err)
+	}
+	keep := false
+	defer func() {
+		tmp.Close()
+		if !keep {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	size, err := io.Copy(tmp, io.LimitReader(src, h.config.Import.MaxBytes+1))
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to store upload", err)
+	}
+	if size > h.config.Import.MaxBytes {
+		return errors.NewAppError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Upload exceeds the limit of %d bytes", h.config.Import.MaxBytes), nil)
+	}
+
+	if format == "" {
+		format, err = importer.Detect(importer.Upload{Name: header.Filename, R: tmp, Size: size})
+		if stderrors.Is(err, importer.ErrUnknownFormat) {
+			return errors.NewAppError(http.StatusUnprocessableEntity, "Could not detect the import format", err)
+		}
+		if err != nil {
+			return errors.NewAppError(http.StatusInternalServerError, "Failed to read upload", err)
+		}
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	// From here on the upload belongs to the import job
+	keep = true
+	limits := importer.Limits{
+		MaxNotes:             h.config.Import.MaxNotes,
+		MaxNoteBytes:         h.config.Import.MaxNoteBytes,
+		MaxUncompressedBytes: h.config.Import.MaxUncompressedBytes,
+	}
+	job, err := h.storage.StartImportJob(ctx, userId, tmp.Name(), header.Filename, format, limits, &h.config.Quota)
+	if err != nil {
+		return jobError(err)
+	}
+
+	return c.JSON(http.StatusAccepted, models.ImportJobResponse{
+		Job:         job,
+		Format:      string(format),
+		ProgressURL: fmt.Sprintf("/api/jobs/%s", job.ID),
+	})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/services"
+)
+
+type JobsHandler struct {
+	*BaseHandler
+}
+
+func NewJobsHandler(base *BaseHandler) *JobsHandler {
+	return &JobsHandler{BaseHandler: base}
+}
+
+func jobError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, services.ErrJobNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Job not found", err)
+	case stderrors.Is(err, services.ErrExportNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Export not found", err)
+	case stderrors.Is(err, services.ErrExportRunning):
+		return errors.NewAppError(http.StatusConflict, "An export is already running", err)
+	case stderrors.Is(err, services.ErrAuditNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Audit report not found", err)
+	case stderrors.Is(err, services.ErrAuditRunning):
+		return errors.NewAppError(http.StatusConflict, "An integrity audit is already running", err)
+	default:
+		return errors.NewAppError(http.StatusInternalServerError, "Job operation failed", err)
+	}
+}
+
+// GetJob reports the progress of a background job started by the user
+func (h *JobsHandler) GetJob(c echo.Context) error {
+	ids, err := pathIDs(c, "jobId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	job, err := h.storage.GetJob(ctx, userId, ids[0])
+	if err != nil {
+		return jobError(err)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
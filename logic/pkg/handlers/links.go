@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/services"
+)
+
+type LinksHandler struct {
+	*BaseHandler
+}
+
+func NewLinksHandler(base *BaseHandler) *LinksHandler {
+	return &LinksHandler{BaseHandler: base}
+}
+
+// Backlinks lists the notes that link to a note
+func (h *LinksHandler) Backlinks(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	backlinks, err := h.storage.GetBacklinks(ctx, userId, ids[0])
+	if stderrors.Is(err, services.ErrNoteNotFound) {
+		return errors.NewAppError(http.StatusNotFound, "Note not found", err)
+	}
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to fetch backlinks", err)
+	}
+
+	return c.JSON(http.StatusOK, backlinks)
+}
+
+// Graph returns the user's notes as nodes and the links between them as edges
+func (h *LinksHandler) Graph(c echo.Context) error {
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	graph, err := h.storage.GetNoteGraph(ctx, userId)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to fetch note graph", err)
+	}
+
+	return c.JSON(http.StatusOK, graph)
+}
@@ -2,14 +2,23 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"log"
 	"net/http"
 	"pdm-logic-server/pkg/errors"
 	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/services"
+	"pdm-logic-server/pkg/util"
+	"pdm-shared/integrity"
+	"pdm-shared/patch"
+	"strings"
 )
 
+const mimeNDJSON = "application/x-ndjson"
+
 type NotesHandler struct {
 	*BaseHandler
 }
@@ -27,7 +36,21 @@ func (h *NotesHandler) GetNotes(c echo.Context) error {
 		return err
 	}
 
-	notes, err := h.storage.GetNotes(ctx, userId, h.config.Redis.NotesCacheTTLMinutes)
+	// The version is read before the notes so the ETag is never newer
+	// than the listing it goes with
+	version, err := h.storage.NotesVersion(ctx, userId)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to fetch notes version", err)
+	}
+	if notModified(c, notesETag(version)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if acceptsNDJSON(c) {
+		return h.streamNotes(ctx, c, userId)
+	}
+
+	notes, err := h.storage.GetNotes(ctx, userId)
 	if err != nil {
 		return errors.NewAppError(http.StatusInternalServerError, "Failed to fetch notes", err)
 	}
@@ -35,8 +58,47 @@ func (h *NotesHandler) GetNotes(c echo.Context) error {
 	return c.JSON(http.StatusOK, notes)
 }
 
+// ndjsonFlushEvery is how many streamed notes are sent per flush
+const ndjsonFlushEvery = 100
+
+func acceptsNDJSON(c echo.Context) bool {
+	for _, part := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(mediaType) == mimeNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// streamNotes writes the user's notes as newline-delimited JSON, one note per
+// line, as they come from the database
+func (h *NotesHandler) streamNotes(ctx context.Context, c echo.Context, userId string) error {
+	c.Response().Header().Set(echo.HeaderContentType, mimeNDJSON)
+	c.Response().WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(c.Response())
+	count := 0
+	err := h.storage.StreamNotes(ctx, userId, func(note models.Notes) error {
+		if err := enc.Encode(note); err != nil {
+			return err
+		}
+		count++
+		if count%ndjsonFlushEvery == 0 {
+			c.Response().Flush()
+		}
+		return nil
+	})
+
+	// Headers are sent by now, so a failure can only cut the stream short
+	if err != nil {
+		h.log.WithError(err).WithField("userId", userId).Error("Notes stream failed")
+	}
+	return nil
+}
+
 func (h *NotesHandler) getUserId(ctx context.Context, email string) (string, error) {
-	userIdStr, err := h.storage.Ch.HGet(ctx, "userEmail:userId", email)
+	userIdStr, err := h.storage.Ch.HGet(ctx, h.storage.Ch.Schema.UserIDs(), email)
 	if err != nil {
 		return "", errors.NewAppError(http.StatusInternalServerError, "Failed to get user ID", err)
 	}
@@ -44,11 +106,54 @@ func (h *NotesHandler) getUserId(ctx context.Context, email string) (string, err
 	return userIdStr, nil
 }
 
+// noteAccess looks up the caller's access to a note, failing with 404 when
+// they have none
+func (h *NotesHandler) noteAccess(ctx context.Context, noteID, userId string) (models.NoteAccess, error) {
+	if !util.IsUUID(noteID) {
+		return models.NoteAccess{}, errors.NewAppError(http.StatusBadRequest, "Invalid note ID", nil)
+	}
+
+	access, err := h.storage.GetNoteAccess(ctx, noteID, userId)
+	if err != nil {
+		return access, errors.NewAppError(http.StatusInternalServerError, "Failed to check note access", err)
+	}
+	if !access.CanRead() {
+		return access, errors.NewAppError(http.StatusNotFound, "Note not found", nil)
+	}
+	return access, nil
+}
+
+// quotaError maps quota violations to 413 for a note that is too large and
+// 507 for a user out of space. Other errors give nil.
+func quotaError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, services.ErrNoteTooLarge):
+		return errors.NewAppError(http.StatusRequestEntityTooLarge, err.Error(), err)
+	case stderrors.Is(err, services.ErrNoteLimit), stderrors.Is(err, services.ErrQuotaExceeded):
+		return errors.NewAppError(http.StatusInsufficientStorage, err.Error(), err)
+	default:
+		return nil
+	}
+}
+
+// dispatchError maps a write the sync server was not handed, because the
+// broker did not confirm it, to 503; nothing was written, so the client may
+// retry
+func dispatchError(err error) *errors.AppError {
+	if stderrors.Is(err, services.ErrNotDispatched) {
+		return errors.NewAppError(http.StatusServiceUnavailable, "Note write could not be queued, try again", err)
+	}
+	return nil
+}
+
 func (h *NotesHandler) CreateNote(c echo.Context) error {
 	ctx := context.Background()
 
 	userId := c.Get("userId").(string)
-	note, err := h.storage.CreateNote(ctx, userId, h.config.Redis.NotesCacheTTLMinutes)
+	note, err := h.storage.CreateNote(ctx, userId, &h.config.Quota)
+	if appErr := quotaError(err); appErr != nil {
+		return appErr
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"message": "Failed to create note",
@@ -74,16 +179,106 @@ func (h *NotesHandler) UpdateNotes(c echo.Context) error {
 	log.Printf("[DEBUG, func (h *NotesHandler) UpdateNotes] req.Time: %v", req.Time)
 	ctx := context.Background()
 
-	err := h.storage.UpdateNote(ctx, req, h.config.Redis.NotesCacheTTLMinutes)
+	// The note may be the user's own or shared with them for editing
+	userId := c.Get("userId").(string)
+	access, err := h.noteAccess(ctx, req.NoteID, userId)
+	if err != nil {
+		return err
+	}
+	if !access.CanEdit() {
+		return errors.NewAppError(http.StatusForbidden, "Note is shared read-only", nil)
+	}
+	req.UserID = access.OwnerID
+
+	if err := h.checkNoteIfMatch(ctx, c, access.OwnerID, req.NoteID); err != nil {
+		return err
+	}
+
+	if err := services.VerifyNoteIntegrity(req.Heading, req.Content, req.H, req.Intgrh, &h.config.Integrity); err != nil {
+		return errors.NewAppError(http.StatusUnprocessableEntity, "Note integrity check failed", err)
+	}
+
+	err = h.storage.UpdateNote(ctx, req, &h.config.Quota)
+	if appErr := quotaError(err); appErr != nil {
+		return appErr
+	}
+	if appErr := dispatchError(err); appErr != nil {
+		return appErr
+	}
 	if err != nil {
 		return errors.NewAppError(http.StatusInternalServerError, "Failed to update note", err)
 	}
 
+	c.Response().Header().Set("ETag", services.NoteETag(req))
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "note updated",
 	})
 }
 
+// PatchNote applies a text diff or JSON Patch to a note instead of replacing
+// its whole content; see package patch for the formats. The patch must be
+// made against the current content, named by its digest in baseHash,
+// otherwise the response is 409 with the current digest. A patch that
+// passes the checks is queued and answered with 202.
+func (h *NotesHandler) PatchNote(c echo.Context) error {
+	var req models.PatchNoteRequest
+	if err := c.Bind(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request data", err)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	access, err := h.noteAccess(ctx, c.Param("id"), userId)
+	if err != nil {
+		return err
+	}
+	if !access.CanEdit() {
+		return errors.NewAppError(http.StatusForbidden, "Note is shared read-only", nil)
+	}
+	if err := h.checkNoteIfMatch(ctx, c, access.OwnerID, c.Param("id")); err != nil {
+		return err
+	}
+
+	note, err := h.storage.PatchNote(ctx, access.OwnerID, c.Param("id"), req, &h.config.Integrity, &h.config.Quota)
+	if appErr := quotaError(err); appErr != nil {
+		return appErr
+	}
+	if appErr := dispatchError(err); appErr != nil {
+		return appErr
+	}
+	switch {
+	case err == nil:
+	case stderrors.Is(err, services.ErrPatchConflict):
+		current, _ := integrity.Compute(note.Heading, note.Content)
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": "note has changed since the patch base",
+			"h":       current,
+		})
+	case stderrors.Is(err, services.ErrNoteNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Note not found", err)
+	case stderrors.Is(err, services.ErrInvalidPatch), stderrors.Is(err, patch.ErrTestFailed):
+		return errors.NewAppError(http.StatusUnprocessableEntity, err.Error(), err)
+	case stderrors.Is(err, integrity.ErrContentMismatch), stderrors.Is(err, integrity.ErrHeadingMismatch),
+		stderrors.Is(err, integrity.ErrLegacy), stderrors.Is(err, integrity.ErrUnsupportedVersion):
+		return errors.NewAppError(http.StatusUnprocessableEntity, "Note integrity check failed", err)
+	default:
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to patch note", err)
+	}
+
+	// The sync server applies the patch later and may still reject it
+	c.Response().Header().Set("ETag", services.NoteETag(note))
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message": "note patch accepted",
+		"h":       note.H,
+		"intgrh":  note.Intgrh,
+	})
+}
+
 func (h *NotesHandler) DeleteNotes(c echo.Context) error {
 	var req models.DeleteNoteRequest
 	if err := c.Bind(&req); err != nil {
@@ -96,8 +291,23 @@ func (h *NotesHandler) DeleteNotes(c echo.Context) error {
 
 	ctx := context.Background()
 
+	// Only the owner may delete a note
 	userId := c.Get("userId").(string)
-	err := h.storage.DeleteNote(ctx, userId, req)
+	access, err := h.noteAccess(ctx, req.NoteID, userId)
+	if err != nil {
+		return err
+	}
+	if access.Permission != models.PermissionOwner {
+		return errors.NewAppError(http.StatusForbidden, "Only the owner can delete a note", nil)
+	}
+	if err := h.checkNoteIfMatch(ctx, c, userId, req.NoteID); err != nil {
+		return err
+	}
+
+	err = h.storage.DeleteNote(ctx, userId, req)
+	if appErr := dispatchError(err); appErr != nil {
+		return appErr
+	}
 	if err != nil {
 		return errors.NewAppError(http.StatusInternalServerError, "Failed to update note", err)
 	}
@@ -106,3 +316,54 @@ func (h *NotesHandler) DeleteNotes(c echo.Context) error {
 		"message": "note deleted",
 	})
 }
+
+func (h *NotesHandler) BatchNotes(c echo.Context) error {
+	var req models.BatchNoteRequest
+	if err := c.Bind(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request data", err)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	results, err := h.storage.ApplyNoteBatch(ctx, userId, req.Operations, &h.config.Integrity, &h.config.Quota)
+	if stderrors.Is(err, services.ErrBatchTooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"message": "batch rejected",
+			"results": results,
+		})
+	}
+	if stderrors.Is(err, services.ErrBatchQuota) {
+		return c.JSON(http.StatusInsufficientStorage, map[string]interface{}{
+			"message": "batch rejected",
+			"results": results,
+		})
+	}
+	if stderrors.Is(err, services.ErrBatchIntegrity) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message": "batch rejected",
+			"results": results,
+		})
+	}
+	if stderrors.Is(err, services.ErrBatchRejected) {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"message": "batch rejected",
+			"results": results,
+		})
+	}
+	if appErr := dispatchError(err); appErr != nil {
+		return appErr
+	}
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to apply note batch", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "batch accepted",
+		"results": results,
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/services"
+	"strings"
+	"time"
+)
+
+type PublicLinksHandler struct {
+	*BaseHandler
+}
+
+// PublicNoteView is the template data of public_note.html
+type PublicNoteView struct {
+	Note             models.PublicNote
+	ShowNote         bool
+	PasswordRequired bool
+	Error            string
+}
+
+func NewPublicLinksHandler(base *BaseHandler) *PublicLinksHandler {
+	return &PublicLinksHandler{BaseHandler: base}
+}
+
+// publicLinkError maps storage errors to HTTP errors
+func publicLinkError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, services.ErrNoteNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Note not found", err)
+	case stderrors.Is(err, services.ErrLinkNotFound):
+		return errors.NewAppError(http.StatusNotFound, "This link does not exist or has been revoked", err)
+	case stderrors.Is(err, services.ErrLinkExpired):
+		return errors.NewAppError(http.StatusGone, "This link has expired", err)
+	case stderrors.Is(err, services.ErrLinkPasswordRequired):
+		return errors.NewAppError(http.StatusUnauthorized, "A password is required", err)
+	case stderrors.Is(err, services.ErrLinkPasswordInvalid):
+		return errors.NewAppError(http.StatusUnauthorized, "Wrong password", err)
+	case stderrors.Is(err, services.ErrTooManyAttempts):
+		return errors.NewAppError(http.StatusTooManyRequests, "Too many attempts, try again later", err)
+	default:
+		return errors.NewAppError(http.StatusInternalServerError, "Public link operation failed", err)
+	}
+}
+
+func (h *PublicLinksHandler) CreateLink(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var req models.CreatePublicLinkRequest
+	if err := c.Bind(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request data", err)
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.NewAppError(http.StatusBadRequest, "Expiry must be in the future", nil)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	link, token, err := h.storage.CreatePublicLink(ctx, userId, ids[0], req)
+	if err != nil {
+		return publicLinkError(err)
+	}
+
+	origin := h.config.Attachments.PublicOrigin
+	if origin == "" {
+		origin = c.Scheme() + "://" + c.Request().Host
+	}
+
+	return c.JSON(http.StatusCreated, models.PublicLinkResponse{
+		Link:        link,
+		HasPassword: link.HasPassword(),
+		URL:         fmt.Sprintf("%s/p/%s", origin, token),
+	})
+}
+
+func (h *PublicLinksHandler) ListLinks(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	links, err := h.storage.ListPublicLinks(ctx, userId, ids[0])
+	if err != nil {
+		return publicLinkError(err)
+	}
+
+	response := make([]models.PublicLinkResponse, 0, len(links))
+	for _, link := range links {
+		response = append(response, models.PublicLinkResponse{Link: link, HasPassword: link.HasPassword()})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *PublicLinksHandler) RevokeLink(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "linkId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	if err := h.storage.RevokePublicLink(ctx, userId, ids[0], ids[1]); err != nil {
+		return publicLinkError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "link revoked",
+	})
+}
+
+// ViewLink serves a published note without authentication. Clients asking
+// for JSON get the note as JSON and send a password in X-Link-Password;
+// browsers get an HTML page, with a password form posting back here.
+func (h *PublicLinksHandler) ViewLink(c echo.Context) error {
+	password := c.Request().Header.Get("X-Link-Password")
+	if c.Request().Method == http.MethodPost {
+		password = c.FormValue("password")
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	c.Response().Header().Set("X-Robots-Tag", "noindex, nofollow")
+
+	ctx := context.Background()
+
+	note, err := h.storage.OpenPublicLink(ctx, c.Param("token"), password)
+	wantsJSON := strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON)
+
+	// Errors are answered here, as JSON or as the page, with their own status
+	if err != nil {
+		appErr := publicLinkError(err)
+		if appErr.Code == http.StatusInternalServerError {
+			h.log.WithError(err).Error("Failed to open public link")
+		}
+		if wantsJSON {
+			return c.JSON(appErr.Code, map[string]string{"message": appErr.Message})
+		}
+
+		view := PublicNoteView{
+			PasswordRequired: stderrors.Is(err, services.ErrLinkPasswordRequired) || stderrors.Is(err, services.ErrLinkPasswordInvalid),
+		}
+		if !stderrors.Is(err, services.ErrLinkPasswordRequired) {
+			view.Error = appErr.Message
+		}
+		return c.Render(appErr.Code, "public_note.html", view)
+	}
+
+	if wantsJSON {
+		return c.JSON(http.StatusOK, note)
+	}
+
+	return c.Render(http.StatusOK, "public_note.html", PublicNoteView{Note: note, ShowNote: true})
+}
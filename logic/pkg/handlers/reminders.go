@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/services"
+)
+
+type RemindersHandler struct {
+	*BaseHandler
+}
+
+func NewRemindersHandler(base *BaseHandler) *RemindersHandler {
+	return &RemindersHandler{BaseHandler: base}
+}
+
+// reminderError maps storage errors to HTTP errors
+func reminderError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, services.ErrNoteNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Note not found", err)
+	case stderrors.Is(err, services.ErrReminderNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Reminder not found", err)
+	case stderrors.Is(err, services.ErrInvalidTimezone),
+		stderrors.Is(err, services.ErrInvalidRecurrence),
+		stderrors.Is(err, services.ErrReminderInPast):
+		return errors.NewAppError(http.StatusBadRequest, err.Error(), err)
+	case stderrors.Is(err, services.ErrTooManyReminders):
+		return errors.NewAppError(http.StatusConflict, err.Error(), err)
+	default:
+		return errors.NewAppError(http.StatusInternalServerError, "Reminder operation failed", err)
+	}
+}
+
+// CreateReminder sets a reminder on a note the user can read. The request
+// takes the first time, an IANA timezone and an optional RRULE, e.g.
+// "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=10".
+func (h *RemindersHandler) CreateReminder(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var req models.CreateReminderRequest
+	if err := c.Bind(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request data", err)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	reminder, err := h.storage.CreateReminder(ctx, userId, ids[0], req)
+	if err != nil {
+		return reminderError(err)
+	}
+
+	return c.JSON(http.StatusCreated, reminder)
+}
+
+func (h *RemindersHandler) ListReminders(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	reminders, err := h.storage.ListReminders(ctx, userId, ids[0])
+	if err != nil {
+		return reminderError(err)
+	}
+
+	return c.JSON(http.StatusOK, reminders)
+}
+
+func (h *RemindersHandler) DeleteReminder(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "reminderId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	if err := h.storage.DeleteReminder(ctx, userId, ids[0], ids[1]); err != nil {
+		return reminderError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "reminder deleted",
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"pdm-logic-server/pkg/errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/services"
+)
+
+type SharesHandler struct {
+	*BaseHandler
+}
+
+func NewSharesHandler(base *BaseHandler) *SharesHandler {
+	return &SharesHandler{BaseHandler: base}
+}
+
+// shareError maps storage errors to HTTP errors
+func shareError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, services.ErrNoteNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Note not found", err)
+	case stderrors.Is(err, services.ErrUserNotFound):
+		return errors.NewAppError(http.StatusNotFound, "No registered user with that email", err)
+	case stderrors.Is(err, services.ErrShareNotFound):
+		return errors.NewAppError(http.StatusNotFound, "Share not found", err)
+	case stderrors.Is(err, services.ErrShareWithSelf):
+		return errors.NewAppError(http.StatusBadRequest, err.Error(), err)
+	default:
+		return errors.NewAppError(http.StatusInternalServerError, "Share operation failed", err)
+	}
+}
+
+func (h *SharesHandler) ShareNote(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var req models.ShareNoteRequest
+	if err := c.Bind(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request format", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Invalid request data", err)
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	share, err := h.storage.ShareNote(ctx, userId, ids[0], req)
+	if err != nil {
+		return shareError(err)
+	}
+
+	return c.JSON(http.StatusOK, share)
+}
+
+func (h *SharesHandler) ListShares(c echo.Context) error {
+	ids, err := pathIDs(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	shares, err := h.storage.ListNoteShares(ctx, userId, ids[0])
+	if err != nil {
+		return shareError(err)
+	}
+
+	return c.JSON(http.StatusOK, shares)
+}
+
+func (h *SharesHandler) DeleteShare(c echo.Context) error {
+	ids, err := pathIDs(c, "id", "shareId")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	if err := h.storage.DeleteShare(ctx, userId, ids[0], ids[1]); err != nil {
+		return shareError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "share removed",
+	})
+}
+
+func (h *SharesHandler) SharedWithMe(c echo.Context) error {
+	ctx := context.Background()
+
+	userId := c.Get("userId").(string)
+	notes, err := h.storage.SharedWithMe(ctx, userId)
+	if err != nil {
+		return shareError(err)
+	}
+
+	return c.JSON(http.StatusOK, notes)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"html/template"
 	"io"
@@ -20,6 +21,7 @@ type CustomRenderer struct {
 type TemplateData struct {
 	Nonce  string
 	Status StatusData
+	Note   PublicNoteView
 }
 
 type StatusData struct {
@@ -103,16 +105,25 @@ func (r *CustomRenderer) Render(w io.Writer, name string, data interface{}, c ec
 
 	// Create combined template data
 	templateData := TemplateData{
-		Nonce:  nonce,
-		Status: data.(StatusData),
+		Nonce: nonce,
+	}
+	switch d := data.(type) {
+	case StatusData:
+		templateData.Status = d
+	case PublicNoteView:
+		templateData.Note = d
+	default:
+		return fmt.Errorf("unsupported template data %T", data)
 	}
 
 	return r.template.ExecuteTemplate(w, name, templateData)
 }
 
 func (s *StatusHandler) SetupRenderer(e *echo.Echo, wd string) {
-	templatePath := filepath.Join(wd, "/templates", "status.html")
-	templates := template.Must(template.ParseFiles(templatePath))
+	templates := template.Must(template.ParseFiles(
+		filepath.Join(wd, "/templates", "status.html"),
+		filepath.Join(wd, "/templates", "public_note.html"),
+	))
 
 	renderer := &CustomRenderer{
 		template: templates,
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/golang-jwt/jwt"
@@ -274,12 +276,12 @@ func (h *UserHandler) Login(c echo.Context) error {
 
 func (h *UserHandler) cacheUserSession(ctx context.Context, email string, userId string, token string, expiration time.Time) error {
 	// Cache user ID mapping
-	if err := h.storage.Ch.HSet(ctx, "userEmail:userId", email, userId); err != nil {
+	if err := h.storage.Ch.HSet(ctx, h.storage.Ch.Schema.UserIDs(), email, userId); err != nil {
 		return errors.NewAppError(http.StatusInternalServerError, "Failed to cache user mapping", err)
 	}
 
 	// Cache session token
-	key := fmt.Sprintf("user:%s:sessionKey", userId)
+	key := h.storage.Ch.Schema.Session(userId)
 	ttl := time.Until(expiration)
 	if err := h.storage.Ch.Set(ctx, key, token, ttl); err != nil {
 		return errors.NewAppError(http.StatusInternalServerError, "Failed to cache session", err)
@@ -294,13 +296,13 @@ func (h *UserHandler) Logout(c echo.Context) error {
 
 	userEmail := c.Get("email").(string)
 
-	userId, err := h.storage.Ch.HGet(ctx, "userEmail:userId", userEmail)
+	userId, err := h.storage.Ch.HGet(ctx, h.storage.Ch.Schema.UserIDs(), userEmail)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"message": "Cache operation failed, HGet userEmail:userId",
 		})
 	}
-	key := fmt.Sprintf("user:%s:sessionKey", userId)
+	key := h.storage.Ch.Schema.Session(userId)
 
 	sessionKey, err := h.storage.Ch.Get(ctx, key)
 	if err != nil {
@@ -319,7 +321,7 @@ func (h *UserHandler) Logout(c echo.Context) error {
 	ttl := time.Until(parsedExp) // Calculate duration until expiration (for Redis TTL)
 
 	// Get blocked list count
-	maxCount, err := h.storage.Ch.CountKeys(ctx, fmt.Sprintf("user:%s:blocked:*", userId))
+	maxCount, err := h.storage.Ch.CountKeys(ctx, h.storage.Ch.Schema.BlockedPattern(userId))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"message": "Cache operation failed, CountKeys user:%s:blocked:*",
@@ -327,7 +329,7 @@ func (h *UserHandler) Logout(c echo.Context) error {
 	}
 
 	// Add session key to blocked list
-	blockListKey := fmt.Sprintf("user:%s:blocked:%d", userId, maxCount+1)
+	blockListKey := h.storage.Ch.Schema.Blocked(userId, maxCount+1)
 	err = h.storage.Ch.Set(ctx, blockListKey, sessionKey, ttl)
 
 	err = h.storage.Ch.Delete(ctx, key)
@@ -351,7 +353,7 @@ func (h *UserHandler) GetUserInfo(c echo.Context) error {
 
 	userEmail := c.Get("email").(string)
 
-	userId, err := h.storage.Ch.HGet(ctx, "userEmail:userId", userEmail)
+	userId, err := h.storage.Ch.HGet(ctx, h.storage.Ch.Schema.UserIDs(), userEmail)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"message": "Cache operation failed, HGet userEmail:userId",
@@ -359,6 +361,51 @@ func (h *UserHandler) GetUserInfo(c echo.Context) error {
 	}
 
 	userInfo, err := services.GetUserInfo(h.storage, ctx, userId)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to fetch user info", err)
+	}
+
+	// User info is small, so its ETag is simply a digest of the body
+	body, err := json.Marshal(userInfo)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to encode user info", err)
+	}
+	sum := sha256.Sum256(body)
+	if notModified(c, `"user-`+hex.EncodeToString(sum[:16])+`"`) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// GetUsage reports the user's storage usage against the limits of their tier
+func (h *UserHandler) GetUsage(c echo.Context) error {
+	ctx := context.Background()
 
-	return c.JSON(http.StatusOK, userInfo)
+	userId := c.Get("userId").(string)
+	tier, limits, err := h.storage.QuotaLimits(ctx, userId, &h.config.Quota)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to load user", err)
+	}
+
+	usage, err := h.storage.GetUsage(ctx, userId)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to load usage", err)
+	}
+
+	usage.AttachmentBytes, err = h.storage.AttachmentUsage(ctx, userId)
+	if err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to load usage", err)
+	}
+
+	return c.JSON(http.StatusOK, models.UsageResponse{
+		Tier:  tier,
+		Usage: usage,
+		Limits: models.UsageLimits{
+			MaxNoteBytes:    limits.MaxNoteBytes,
+			MaxNotes:        limits.MaxNotes,
+			MaxBytes:        limits.MaxBytes,
+			AttachmentBytes: h.config.Attachments.QuotaBytes,
+		},
+	})
 }
@@ -0,0 +1,119 @@
+package importer
+
+import (
+	"encoding/xml"
+	"io"
+	"pdm-logic-server/pkg/models"
+	"strings"
+	"time"
+)
+
+const enexTimeLayout = "20060102T150405Z"
+
+type enexNote struct {
+	Title   string `xml:"title"`
+	Content string `xml:"content"`
+	Created string `xml:"created"`
+	Updated string `xml:"updated"`
+}
+
+// parseENEX reads an Evernote export note by note. The ENML body of each
+// note is converted to plain text; attached resources are not imported.
+func (p *parser) parseENEX(r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+
+		var en enexNote
+		if err := decoder.DecodeElement(&en, &start); err != nil {
+			return err
+		}
+
+		note := models.Notes{
+			Heading: strings.TrimSpace(en.Title),
+			Content: enmlToText(en.Content),
+		}
+		note.Time, _ = time.Parse(enexTimeLayout, strings.TrimSpace(en.Created))
+		note.UpdateTime, _ = time.Parse(enexTimeLayout, strings.TrimSpace(en.Updated))
+
+		if err := p.add(withTimes(note)); err != nil {
+			return err
+		}
+	}
+}
+
+// Elements that start a new line in the text version of a note
+var enmlBlocks = map[string]bool{
+	"div": true, "p": true, "br": true, "li": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "hr": true,
+}
+
+// enmlToText flattens ENML to text, turning block elements into line breaks
+// and checkboxes into Markdown task markers.
+func enmlToText(enml string) string {
+	decoder := xml.NewDecoder(strings.NewReader(enml))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var b strings.Builder
+	newline := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch name := strings.ToLower(t.Name.Local); {
+			case name == "en-todo":
+				newline()
+				checked := false
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "checked" && attr.Value == "true" {
+						checked = true
+					}
+				}
+				if checked {
+					b.WriteString("- [x] ")
+				} else {
+					b.WriteString("- [ ] ")
+				}
+			case name == "li":
+				newline()
+				b.WriteString("- ")
+			case enmlBlocks[name]:
+				newline()
+			}
+		case xml.EndElement:
+			if enmlBlocks[strings.ToLower(t.Name.Local)] {
+				newline()
+			}
+		case xml.CharData:
+			b.Write(t)
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,266 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"pdm-logic-server/pkg/models"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownFormat   = errors.New("unrecognized import format")
+	ErrTooManyNotes    = errors.New("archive contains too many notes")
+	ErrNoNotes         = errors.New("archive contains no notes")
+	ErrNoteTooLarge    = errors.New("note exceeds the import size limit")
+	ErrArchiveTooLarge = errors.New("archive expands beyond the import size limit")
+)
+
+// Limits bound what Parse reads from an upload. Zero disables a limit.
+type Limits struct {
+	MaxNotes             int   // Most notes accepted
+	MaxNoteBytes         int64 // Largest note, heading and content together
+	MaxUncompressedBytes int64 // Most bytes read out of a zip, all entries together
+}
+
+// Format is a supported source of imported notes
+type Format string
+
+const (
+	FormatMarkdown Format = "markdown"
+	FormatENEX     Format = "enex"
+	FormatKeep     Format = "keep"
+)
+
+// ParseFormat accepts a client supplied format name. An empty name means
+// the format is detected from the upload.
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case "":
+		return "", nil
+	case FormatMarkdown:
+		return FormatMarkdown, nil
+	case FormatENEX:
+		return FormatENEX, nil
+	case FormatKeep:
+		return FormatKeep, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// Upload is an uploaded file: a zip archive, a single .enex export or a
+// single Markdown file.
+type Upload struct {
+	Name string
+	R    io.ReaderAt
+	Size int64
+}
+
+func (u Upload) isZip() bool {
+	magic := make([]byte, 4)
+	n, _ := u.R.ReadAt(magic, 0)
+	return n == 4 && bytes.Equal(magic, []byte("PK\x03\x04"))
+}
+
+func (u Upload) looksLikeENEX() bool {
+	head := make([]byte, 1024)
+	n, _ := u.R.ReadAt(head, 0)
+	return bytes.Contains(head[:n], []byte("<en-export"))
+}
+
+// Detect works out the format of an upload. A zip archive is a Google Keep
+// Takeout if it contains Keep JSON files, an Evernote export if it contains
+// .enex files, and a Markdown folder otherwise.
+func Detect(u Upload) (Format, error) {
+	if !u.isZip() {
+		switch {
+		case u.looksLikeENEX():
+			return FormatENEX, nil
+		case isMarkdownFile(u.Name):
+			return FormatMarkdown, nil
+		default:
+			return "", ErrUnknownFormat
+		}
+	}
+
+	zr, err := zip.NewReader(u.R, u.Size)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+
+	markdown := false
+	for _, f := range zr.File {
+		switch {
+		case isKeepFile(f.Name):
+			return FormatKeep, nil
+		case strings.EqualFold(path.Ext(f.Name), ".enex"):
+			return FormatENEX, nil
+		case isMarkdownFile(f.Name):
+			markdown = true
+		}
+	}
+	if markdown {
+		return FormatMarkdown, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Parse reads every note of an upload in the given format, within limits.
+// The notes have no ID or user yet.
+func Parse(format Format, u Upload, limits Limits) ([]models.Notes, error) {
+	p := &parser{limits: limits}
+
+	var err error
+	if u.isZip() {
+		err = p.parseZip(format, u)
+	} else {
+		err = p.parseFile(format, u.Name, io.NewSectionReader(u.R, 0, u.Size))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.notes) == 0 {
+		return nil, ErrNoNotes
+	}
+	return p.notes, nil
+}
+
+type parser struct {
+	notes  []models.Notes
+	limits Limits
+	// unzipped counts the bytes read out of zip entries so far
+	unzipped int64
+	// fileTime is the modification time of the zip entry being parsed, the
+	// fallback for Markdown files without dates in their front-matter
+	fileTime time.Time
+}
+
+func (p *parser) add(note models.Notes) error {
+	if p.limits.MaxNotes > 0 && len(p.notes) >= p.limits.MaxNotes {
+		return ErrTooManyNotes
+	}
+	if p.limits.MaxNoteBytes > 0 && int64(len(note.Heading)+len(note.Content)) > p.limits.MaxNoteBytes {
+		return ErrNoteTooLarge
+	}
+	p.notes = append(p.notes, note)
+	return nil
+}
+
+func (p *parser) parseZip(format Format, u Upload) error {
+	zr, err := zip.NewReader(u.R, u.Size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isHidden(f.Name) {
+			continue
+		}
+
+		switch format {
+		case FormatMarkdown:
+			if !isMarkdownFile(f.Name) {
+				continue
+			}
+		case FormatENEX:
+			if !strings.EqualFold(path.Ext(f.Name), ".enex") {
+				continue
+			}
+		case FormatKeep:
+			if !isKeepFile(f.Name) {
+				continue
+			}
+		}
+
+		p.fileTime = f.Modified
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		err = p.parseFile(format, f.Name, &unzipReader{r: rc, p: p})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unzipReader reads a zip entry, failing with ErrArchiveTooLarge once the
+// entries read so far hold more than the limit. The sizes in the zip headers
+// are not trusted.
+type unzipReader struct {
+	r io.Reader
+	p *parser
+}
+
+func (u *unzipReader) Read(buf []byte) (int, error) {
+	n, err := u.r.Read(buf)
+	u.p.unzipped += int64(n)
+	if max := u.p.limits.MaxUncompressedBytes; max > 0 && u.p.unzipped > max {
+		return n, ErrArchiveTooLarge
+	}
+	return n, err
+}
+
+// readNote reads a file that holds a single note, failing with
+// ErrNoteTooLarge if it is larger than a note may be
+func (p *parser) readNote(r io.Reader) ([]byte, error) {
+	if p.limits.MaxNoteBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, p.limits.MaxNoteBytes+1))
+	if err == nil && int64(len(data)) > p.limits.MaxNoteBytes {
+		return nil, ErrNoteTooLarge
+	}
+	return data, err
+}
+
+func (p *parser) parseFile(format Format, name string, r io.Reader) error {
+	var err error
+	switch format {
+	case FormatMarkdown:
+		err = p.parseMarkdown(name, r)
+	case FormatENEX:
+		err = p.parseENEX(r)
+	case FormatKeep:
+		err = p.parseKeep(r)
+	default:
+		return ErrUnknownFormat
+	}
+	if err != nil && !errors.Is(err, ErrTooManyNotes) {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return err
+}
+
+func isMarkdownFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".txt":
+		return !isHidden(name)
+	}
+	return false
+}
+
+// isKeepFile matches the per note JSON files of a Takeout, which live in
+// Takeout/Keep/ next to an HTML copy of each note.
+func isKeepFile(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".json") &&
+		strings.EqualFold(path.Base(path.Dir(name)), "Keep")
+}
+
+func isHidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
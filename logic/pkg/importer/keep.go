@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+	"pdm-logic-server/pkg/models"
+	"strings"
+	"time"
+)
+
+type keepNote struct {
+	Title                   string         `json:"title"`
+	TextContent             string         `json:"textContent"`
+	ListContent             []keepListItem `json:"listContent"`
+	IsTrashed               bool           `json:"isTrashed"`
+	CreatedTimestampUsec    int64          `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64          `json:"userEditedTimestampUsec"`
+}
+
+type keepListItem struct {
+	Text      string `json:"text"`
+	IsChecked bool   `json:"isChecked"`
+}
+
+// parseKeep reads one note of a Google Keep Takeout. Checklists become
+// Markdown task lists and trashed notes are imported as deleted.
+func (p *parser) parseKeep(r io.Reader) error {
+	data, err := p.readNote(r)
+	if err != nil {
+		return err
+	}
+	var kn keepNote
+	if err := json.Unmarshal(data, &kn); err != nil {
+		return err
+	}
+
+	content := kn.TextContent
+	if len(kn.ListContent) > 0 {
+		var b strings.Builder
+		for _, item := range kn.ListContent {
+			if item.IsChecked {
+				b.WriteString("- [x] ")
+			} else {
+				b.WriteString("- [ ] ")
+			}
+			b.WriteString(item.Text)
+			b.WriteString("\n")
+		}
+		content = b.String()
+	}
+
+	note := models.Notes{
+		Heading: strings.TrimSpace(kn.Title),
+		Content: content,
+	}
+	if kn.CreatedTimestampUsec > 0 {
+		note.Time = time.UnixMicro(kn.CreatedTimestampUsec).UTC()
+	}
+	if kn.UserEditedTimestampUsec > 0 {
+		note.UpdateTime = time.UnixMicro(kn.UserEditedTimestampUsec).UTC()
+	}
+	if kn.IsTrashed {
+		note.Deleted = 1
+	}
+
+	return p.add(withTimes(note))
+}
@@ -0,0 +1,146 @@
+package importer
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"pdm-logic-server/pkg/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Front-matter keys understood for each field, covering PDM's own exports
+// and the usual static site and note app conventions.
+var (
+	headingKeys = []string{"heading", "title"}
+	createdKeys = []string{"created", "date", "created_at", "createdAt"}
+	updatedKeys = []string{"updated", "modified", "updated_at", "updatedAt", "lastmod"}
+	deletedKeys = []string{"deleted", "trashed"}
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func (p *parser) parseMarkdown(name string, r io.Reader) error {
+	data, err := p.readNote(r)
+	if err != nil {
+		return err
+	}
+
+	meta, body := splitFrontMatter(strings.ReplaceAll(string(data), "\r\n", "\n"))
+
+	note := models.Notes{
+		Heading: firstValue(meta, headingKeys),
+		Content: body,
+	}
+	if note.Heading == "" {
+		note.Heading = markdownTitle(body)
+	}
+	if note.Heading == "" {
+		note.Heading = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	note.Time = parseTime(firstValue(meta, createdKeys))
+	note.UpdateTime = parseTime(firstValue(meta, updatedKeys))
+	if note.Time.IsZero() && note.UpdateTime.IsZero() && !p.fileTime.IsZero() {
+		note.UpdateTime = p.fileTime.UTC()
+	}
+	if deleted, _ := strconv.ParseBool(firstValue(meta, deletedKeys)); deleted {
+		note.Deleted = 1
+	}
+
+	return p.add(withTimes(note))
+}
+
+// splitFrontMatter separates a leading "---" delimited block of flat
+// "key: value" pairs from the body. Nested YAML is ignored.
+func splitFrontMatter(text string) (map[string]string, string) {
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, text
+	}
+
+	end := strings.Index(text[4:], "\n---")
+	if end < 0 {
+		return nil, text
+	}
+	block := text[4 : 4+end]
+	body := text[4+end+4:]
+	body = strings.TrimPrefix(strings.TrimPrefix(body, "\n"), "\n")
+
+	meta := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(block))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		meta[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+	}
+
+	return meta, body
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+	}
+	return value
+}
+
+func firstValue(meta map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := meta[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// markdownTitle returns the text of a leading "# " heading, if any
+func markdownTitle(body string) string {
+	line, _, _ := strings.Cut(strings.TrimLeft(body, "\n"), "\n")
+	if strings.HasPrefix(line, "# ") {
+		return strings.TrimSpace(line[2:])
+	}
+	return ""
+}
+
+func parseTime(value string) time.Time {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// withTimes fills in missing timestamps so imported notes sort sensibly
+func withTimes(note models.Notes) models.Notes {
+	switch {
+	case note.Time.IsZero() && note.UpdateTime.IsZero():
+		note.Time = time.Now().UTC()
+		note.UpdateTime = note.Time
+	case note.Time.IsZero():
+		note.Time = note.UpdateTime
+	case note.UpdateTime.IsZero():
+		note.UpdateTime = note.Time
+	}
+	return note
+}
@@ -13,6 +13,12 @@ type Metrics struct {
 	requestDuration   *prometheus.HistogramVec
 	responseSizes     *prometheus.HistogramVec
 	activeConnections *prometheus.GaugeVec
+	compressionIn     *prometheus.CounterVec
+	compressionOut    *prometheus.CounterVec
+	compressionSaved  *prometheus.CounterVec
+	cacheOperations   *prometheus.CounterVec
+	cacheDuration     *prometheus.HistogramVec
+	cacheLookups      *prometheus.CounterVec
 }
 
 func NewMetrics() *Metrics {
@@ -47,7 +53,79 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"state"},
 		),
+		compressionIn: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_compression_input_bytes_total",
+				Help: "Bytes of compressed responses before compression",
+			},
+			[]string{"encoding"},
+		),
+		compressionOut: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_compression_output_bytes_total",
+				Help: "Bytes of compressed responses after compression",
+			},
+			[]string{"encoding"},
+		),
+		compressionSaved: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_compression_saved_bytes_total",
+				Help: "Bytes saved by response compression",
+			},
+			[]string{"encoding"},
+		),
+		cacheOperations: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cache_operations_total",
+				Help: "Total number of cache operations",
+			},
+			[]string{"op", "family", "status"},
+		),
+		cacheDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "cache_operation_duration_seconds",
+				Help:    "Cache operation latency",
+				Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+			},
+			[]string{"op", "family"},
+		),
+		cacheLookups: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cache_lookups_total",
+				Help: "Cache reads by the tier that answered them and whether they found anything",
+			},
+			[]string{"tier", "op", "family", "result"},
+		),
+	}
+}
+
+// ObserveCompression records the size of a response before and after
+// compression with the given encoding
+func (m *Metrics) ObserveCompression(encoding string, in, out int64) {
+	m.compressionIn.WithLabelValues(encoding).Add(float64(in))
+	m.compressionOut.WithLabelValues(encoding).Add(float64(out))
+	if in > out {
+		m.compressionSaved.WithLabelValues(encoding).Add(float64(in - out))
+	}
+}
+
+// ObserveCacheOperation records one cache operation on a key family
+func (m *Metrics) ObserveCacheOperation(op, family string, took time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.cacheOperations.WithLabelValues(op, family, status).Inc()
+	m.cacheDuration.WithLabelValues(op, family).Observe(took.Seconds())
+}
+
+// ObserveCacheLookup records a cache read in a tier as a hit or a miss
+func (m *Metrics) ObserveCacheLookup(tier, op, family string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
 	}
+	m.cacheLookups.WithLabelValues(tier, op, family, result).Inc()
 }
 
 func (m *Metrics) Middleware() echo.MiddlewareFunc {
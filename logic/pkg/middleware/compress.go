@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"pdm-logic-server/pkg/config"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+// Supported content codings, in order of preference on equal q-values
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// CompressionObserver is told the size of each compressed response before
+// (in) and after (out) compression
+type CompressionObserver func(encoding string, in, out int64)
+
+// Media types worth compressing; everything else, e.g. zip exports and
+// attachments, is sent as is
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	zstdPool = sync.Pool{New: func() interface{} {
+		// An 8MB window is the most HTTP clients are required to support
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<23))
+		return w
+	}}
+)
+
+// CreateCompressionMiddleware compresses responses with zstd or gzip,
+// whichever the client's Accept-Encoding prefers. Responses are buffered up
+// to cfg.MinBytes first and sent uncompressed if they end before that; a
+// handler that flushes, like a streaming one, starts compression right away.
+//
+// A compressed response is not the same bytes as the identity one, so a
+// strong ETag gets the coding appended, "v" becoming "v-gzip". The suffix is
+// taken off the ETags of If-None-Match and If-Match before the handler sees
+// them, and a 304 answering a suffixed ETag gets it back.
+func CreateCompressionMiddleware(cfg *config.CompressionConfig, observe CompressionObserver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+			encoding := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding))
+			if encoding == "" || c.Request().Method == http.MethodHead {
+				return next(c)
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: c.Response().Writer,
+				encoding:       encoding,
+				minBytes:       cfg.MinBytes,
+				status:         http.StatusOK,
+			}
+			for _, name := range []string{"If-None-Match", "If-Match"} {
+				if header := c.Request().Header.Get(name); header != "" {
+					header, suffixed := unencodedETags(header)
+					c.Request().Header.Set(name, header)
+					cw.encodedETags = cw.encodedETags || suffixed
+				}
+			}
+			c.Response().Writer = cw
+			defer func() {
+				cw.close()
+				c.Response().Writer = cw.ResponseWriter
+				if cw.encoder != nil && observe != nil {
+					observe(encoding, cw.in, cw.out.n)
+				}
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+// negotiateEncoding picks the supported coding with the highest q-value, or
+// "" for none
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != EncodingZstd && name != EncodingGzip {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ || (q == bestQ && name == EncodingZstd) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// encodedETag appends the coding to a strong ETag. Weak ETags are left as
+// they are; they already allow for a different encoding.
+func encodedETag(etag, encoding string) string {
+	if len(etag) < 2 || strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// unencodedETags takes the coding suffix off the ETags of an If-None-Match or
+// If-Match header and reports whether any had one
+func unencodedETags(header string) (string, bool) {
+	candidates := strings.Split(header, ",")
+	suffixed := false
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		for _, encoding := range []string{EncodingZstd, EncodingGzip} {
+			if trimmed, ok := strings.CutSuffix(candidate, "-"+encoding+`"`); ok {
+				candidate, suffixed = trimmed+`"`, true
+				break
+			}
+		}
+		candidates[i] = candidate
+	}
+	return strings.Join(candidates, ", "), suffixed
+}
+
+func compressible(header http.Header) bool {
+	if header.Get(echo.HeaderContentEncoding) != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get(echo.HeaderContentType))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// compressWriter holds back the status and the first minBytes of a response
+// until it knows whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+	// encodedETags is set when the request named ETags of compressed
+	// responses
+	encodedETags bool
+
+	status  int
+	pending []byte
+	started bool
+	encoder io.WriteCloser
+	in      int64
+	out     countingWriter
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if !w.started {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.pending = append(w.pending, p...)
+		if len(w.pending) < w.minBytes {
+			return len(p), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	w.in += int64(len(p))
+	return w.encoder.Write(p)
+}
+
+// start sends the held back status and data, compressed if allowed and the
+// response is suitable
+func (w *compressWriter) start(allowCompression bool) error {
+	w.started = true
+	header := w.ResponseWriter.Header()
+
+	bodyless := w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified
+	if etag := header.Get("ETag"); etag != "" && w.status == http.StatusNotModified && w.encodedETags {
+		header.Set("ETag", encodedETag(etag, w.encoding))
+	}
+	if allowCompression && !bodyless && compressible(header) {
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", encodedETag(etag, w.encoding))
+		}
+		header.Set(echo.HeaderContentEncoding, w.encoding)
+		header.Del(echo.HeaderContentLength)
+		w.out.w = w.ResponseWriter
+		w.encoder = w.newEncoder()
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	pending := w.pending
+	w.pending = nil
+	if len(pending) == 0 {
+		return nil
+	}
+	if w.encoder == nil {
+		_, err := w.ResponseWriter.Write(pending)
+		return err
+	}
+	w.in += int64(len(pending))
+	_, err := w.encoder.Write(pending)
+	return err
+}
+
+func (w *compressWriter) newEncoder() io.WriteCloser {
+	if w.encoding == EncodingZstd {
+		enc := zstdPool.Get().(*zstd.Encoder)
+		enc.Reset(&w.out)
+		return enc
+	}
+	enc := gzipPool.Get().(*gzip.Writer)
+	enc.Reset(&w.out)
+	return enc
+}
+
+// Flush starts compression, regardless of size, and pushes out what the
+// encoder holds
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(true)
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the response: a short one is sent uncompressed, a
+// compressed one gets its trailer and the encoder goes back to its pool
+func (w *compressWriter) close() {
+	if !w.started {
+		// Nothing was written at all, e.g. the handler failed before
+		// writing; leave the response to the error handler
+		if len(w.pending) == 0 && w.status == http.StatusOK {
+			return
+		}
+		w.start(false)
+		return
+	}
+	if w.encoder == nil {
+		return
+	}
+
+	w.encoder.Close()
+	switch enc := w.encoder.(type) {
+	case *zstd.Encoder:
+		enc.Reset(io.Discard)
+		zstdPool.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(io.Discard)
+		gzipPool.Put(enc)
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
 	"pdm-logic-server/pkg/errors"
@@ -15,7 +16,7 @@ func ErrorHandler(err error, c echo.Context) error {
 
 	if he, ok := err.(*echo.HTTPError); ok {
 		return c.JSON(he.Code, map[string]string{
-			"message": he.Message.(string),
+			"message": fmt.Sprint(he.Message),
 		})
 	}
 
@@ -23,3 +24,19 @@ func ErrorHandler(err error, c echo.Context) error {
 		"message": "Internal server error",
 	})
 }
+
+// RenderAppErrors answers the errors of the handlers it wraps through
+// ErrorHandler, so an AppError gets its own status code rather than the
+// generic 500 of Echo's error handler. Other errors are still logged.
+func RenderAppErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil || c.Response().Committed {
+			return err
+		}
+		if e, ok := err.(*errors.AppError); !ok || e.Code >= http.StatusInternalServerError {
+			c.Logger().Error(err)
+		}
+		return ErrorHandler(err, c)
+	}
+}
@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/errors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotency record states
+const (
+	idempotencyPending = "pending"
+	idempotencyDone    = "done"
+)
+
+// idempotencyRecord is what is kept in Redis for a key: first a pending
+// marker while the request runs, then its response
+type idempotencyRecord struct {
+	State       string      `json:"state"`
+	Fingerprint string      `json:"fingerprint,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Response headers replayed with a stored response
+var replayedHeaders = []string{echo.HeaderContentType, echo.HeaderLocation, "ETag"}
+
+// CreateIdempotencyMiddleware makes mutating requests that carry an
+// Idempotency-Key header safe to retry. The first request with a key runs and
+// its response is kept per user for cfg.TTL; later requests with the same key
+// get that response replayed instead of running again. Reusing a key for a
+// different request is rejected with 422, and a retry that arrives while the
+// first request is still running gets 409.
+//
+// Server errors and responses larger than cfg.MaxResponseBytes are not kept,
+// so such requests can be retried with the same key. It must run after the
+// JWT middleware, which sets the user.
+func CreateIdempotencyMiddleware(ch *cache.RedisCache, cfg *config.IdempotencyConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			key := req.Header.Get(HeaderIdempotencyKey)
+			if key == "" || !isMutating(req.Method) {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return errors.NewAppError(http.StatusBadRequest, "Idempotency-Key is too long", nil)
+			}
+
+			ctx := context.Background()
+			userId, _ := c.Get("userId").(string)
+			redisKey := idempotencyKey(ch.Schema, userId, key)
+
+			pending, _ := json.Marshal(idempotencyRecord{State: idempotencyPending})
+			fresh, err := ch.SetNX(ctx, redisKey, string(pending), cfg.PendingTTL)
+			if err != nil {
+				return errors.NewAppError(http.StatusInternalServerError, "Failed to check idempotency key", err)
+			}
+			if !fresh {
+				return replayIdempotent(ctx, c, ch, redisKey)
+			}
+
+			// Fingerprint the body as the handler reads it, so a large
+			// upload is never held in memory
+			hasher := sha256.New()
+			io.WriteString(hasher, req.Method+" "+req.URL.RequestURI()+"\n")
+			req.Body = &hashingBody{ReadCloser: req.Body, hash: hasher}
+
+			capture := &capturingWriter{ResponseWriter: c.Response().Writer, limit: cfg.MaxResponseBytes}
+			c.Response().Writer = capture
+
+			// Long imports and uploads outlive PendingTTL; the marker is
+			// renewed until the handler returns, so a retry cannot run too
+			stopRenewing := renewPending(ctx, ch, redisKey, cfg.PendingTTL)
+			returned := false
+			defer func() {
+				if returned {
+					return
+				}
+				// The handler panicked; release the key so the request
+				// can be retried once the panic is recovered
+				stopRenewing()
+				if err := ch.Delete(ctx, redisKey); err != nil {
+					log.Printf("Failed to release idempotency key %s: %v", redisKey, err)
+				}
+			}()
+			err = next(c)
+			returned = true
+			stopRenewing()
+			if err != nil {
+				// Render the error now so its response can be kept too
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError || capture.overflow {
+				if err := ch.Delete(ctx, redisKey); err != nil {
+					log.Printf("Failed to release idempotency key %s: %v", redisKey, err)
+				}
+				return nil
+			}
+
+			// Whatever the handler did not read still counts
+			io.Copy(io.Discard, req.Body)
+
+			record := idempotencyRecord{
+				State:       idempotencyDone,
+				Fingerprint: hex.EncodeToString(hasher.Sum(nil)),
+				Status:      status,
+				Header:      http.Header{},
+				Body:        capture.body.Bytes(),
+			}
+			for _, name := range replayedHeaders {
+				if value := c.Response().Header().Get(name); value != "" {
+					record.Header.Set(name, value)
+				}
+			}
+			if err := ch.SetJSON(ctx, redisKey, record, cfg.TTL); err != nil {
+				log.Printf("Failed to store idempotent response for %s: %v", redisKey, err)
+			}
+			return nil
+		}
+	}
+}
+
+// renewPending extends the pending marker at redisKey every third of ttl
+// until the returned func is called
+func renewPending(ctx context.Context, ch *cache.RedisCache, redisKey string, ttl time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := ch.Expire(ctx, redisKey, ttl); err != nil {
+					log.Printf("Failed to renew idempotency key %s: %v", redisKey, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func idempotencyKey(schema *keys.Schema, userId, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return schema.Idempotency(userId, hex.EncodeToString(sum[:]))
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// replayIdempotent answers a request whose key was seen before
+func replayIdempotent(ctx context.Context, c echo.Context, ch *cache.RedisCache, redisKey string) error {
+	var record idempotencyRecord
+	if err := ch.GetJSON(ctx, redisKey, &record); err != nil {
+		return errors.NewAppError(http.StatusInternalServerError, "Failed to check idempotency key", err)
+	}
+
+	switch record.State {
+	case idempotencyDone:
+	case idempotencyPending:
+		return errors.NewAppError(http.StatusConflict, "A request with this Idempotency-Key is still in progress", nil)
+	default:
+		// Expired between SetNX and Get; the client can simply retry
+		return errors.NewAppError(http.StatusConflict, "Idempotency-Key expired, retry the request", nil)
+	}
+
+	req := c.Request()
+	hasher := sha256.New()
+	io.WriteString(hasher, req.Method+" "+req.URL.RequestURI()+"\n")
+	if _, err := io.Copy(hasher, req.Body); err != nil {
+		return errors.NewAppError(http.StatusBadRequest, "Failed to read request body", err)
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != record.Fingerprint {
+		return errors.NewAppError(http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", nil)
+	}
+
+	for name, values := range record.Header {
+		for _, value := range values {
+			c.Response().Header().Add(name, value)
+		}
+	}
+	c.Response().Header().Set(HeaderIdempotencyReplayed, "true")
+	c.Response().WriteHeader(record.Status)
+	_, err := c.Response().Write(record.Body)
+	return err
+}
+
+// hashingBody feeds a request body into a hash as it is read
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// capturingWriter keeps a copy of a response up to limit bytes
+type capturingWriter struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (w *capturingWriter) Write(p []byte) (int, error) {
+	if !w.overflow {
+		if int64(w.body.Len()+len(p)) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *capturingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *capturingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
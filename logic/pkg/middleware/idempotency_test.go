@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	ch := cache.NewCache(cache.NewMemoryClient(), keys.NewSchema(&config.CacheKeysConfig{Version: 1}))
+	cfg := &config.IdempotencyConfig{TTL: time.Hour, PendingTTL: time.Minute, MaxResponseBytes: 1 << 20}
+	mw := CreateIdempotencyMiddleware(ch, cfg)
+
+	calls := 0
+	created := func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusCreated, "created")
+	}
+	panicking := func(c echo.Context) error {
+		calls++
+		panic("handler failed")
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		body       string
+		handler    echo.HandlerFunc
+		wantPanic  bool
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "first request runs", key: "a", body: "x", handler: created, wantStatus: http.StatusCreated, wantCalls: 1},
+		{name: "retry is replayed", key: "a", body: "x", handler: created, wantStatus: http.StatusCreated, wantCalls: 0},
+		{name: "key reused for another body", key: "a", body: "y", handler: created, wantStatus: http.StatusUnprocessableEntity, wantCalls: 0},
+		{name: "handler panics", key: "b", body: "x", handler: panicking, wantPanic: true, wantCalls: 1},
+		{name: "retry after a panic runs", key: "b", body: "x", handler: created, wantStatus: http.StatusCreated, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/notes", strings.NewReader(tt.body))
+			req.Header.Set(HeaderIdempotencyKey, tt.key)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("userId", "u1")
+
+			calls = 0
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				if err := RenderAppErrors(mw(tt.handler))(c); err != nil {
+					t.Errorf("request error = %v", err)
+				}
+				return false
+			}()
+
+			if panicked != tt.wantPanic {
+				t.Fatalf("panicked = %v, want %v", panicked, tt.wantPanic)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if !tt.wantPanic && rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
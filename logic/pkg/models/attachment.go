@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// Attachment upload states
+const (
+	AttachmentPending  = "pending"
+	AttachmentComplete = "complete"
+)
+
+type NoteAttachment struct {
+	ID           string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	NoteID       string     `gorm:"column:noteid;type:uuid;not null;index" json:"noteid"`
+	UserID       string     `gorm:"column:userid;type:uuid;not null;index" json:"userid"`
+	Filename     string     `gorm:"column:filename;not null" json:"filename"`
+	ContentType  string     `gorm:"column:content_type" json:"contentType"`
+	Size         int64      `gorm:"column:size;not null" json:"size"`
+	SHA256       string     `gorm:"column:sha256;not null" json:"sha256"`
+	StorageKey   string     `gorm:"column:storage_key;not null" json:"-"`
+	Status       string     `gorm:"column:status;not null;default:'pending'" json:"status"`
+	UploadedSize int64      `gorm:"column:uploaded_size;not null;default:0" json:"uploadedSize"`
+	CreatedAt    time.Time  `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+	CompletedAt  *time.Time `gorm:"column:completed_at;type:timestamptz" json:"completedAt,omitempty"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteAttachment) TableName() string {
+	return "note_attachment"
+}
+
+type CreateAttachmentRequest struct {
+	Filename    string `json:"filename" validate:"required,max=255"`
+	ContentType string `json:"contentType" validate:"max=255"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+	SHA256      string `json:"sha256" validate:"required,len=64,hexadecimal"`
+}
+
+type AttachmentURLResponse struct {
+	URL       string `json:"url"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
@@ -1,10 +1,26 @@
 package models
 
+import (
+	"time"
+)
+
 type EmailVerificationTemplateData struct {
 	Code  string
 	Email string
 }
 
+type ExportReadyTemplateData struct {
+	DownloadURL string
+	ExpiresAt   time.Time
+	NoteCount   int
+}
+
+type ReminderTemplateData struct {
+	Heading string
+	FireAt  time.Time
+	NoteURL string
+}
+
 type EmailAddress struct {
 	Email string `json:"email"`
 	Name  string `json:"name"`
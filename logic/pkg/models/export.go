@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// ExportFormatVersion is bumped whenever the archive layout changes
+const ExportFormatVersion = 1
+
+// ExportManifest is written as manifest.json at the root of an export archive
+type ExportManifest struct {
+	Version    int       `json:"version"`
+	UserID     string    `json:"userid"`
+	ExportedAt time.Time `json:"exportedAt"`
+	NoteCount  int       `json:"noteCount"`
+}
+
+// ExportSession is a session_key row without the key itself
+type ExportSession struct {
+	ID             string    `json:"id"`
+	CreationTime   time.Time `json:"creationTime"`
+	ExpirationTime time.Time `json:"expirationTime"`
+	Valid          string    `json:"valid"`
+}
+
+type ExportJobResponse struct {
+	Job Job `json:"job"`
+}
+
+type ImportJobResponse struct {
+	Job         Job    `json:"job"`
+	Format      string `json:"format"`
+	ProgressURL string `json:"progressUrl"`
+}
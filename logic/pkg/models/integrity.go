@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// IntegrityMismatch is a stored note whose digests no longer verify
+type IntegrityMismatch struct {
+	NoteID     string    `json:"noteid"`
+	UserID     string    `json:"userid"`
+	Reason     string    `json:"reason"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// IntegrityReport is the outcome of an integrity audit. Legacy counts notes
+// whose digests are unversioned and so could not be checked.
+type IntegrityReport struct {
+	JobID      string              `json:"jobId"`
+	StartedAt  time.Time           `json:"startedAt"`
+	Scanned    int                 `json:"scanned"`
+	Valid      int                 `json:"valid"`
+	Legacy     int                 `json:"legacy"`
+	Mismatched int                 `json:"mismatched"`
+	Truncated  bool                `json:"truncated"`
+	Rows       []IntegrityMismatch `json:"rows"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// Job types
+const (
+	JobTypeExport = "export"
+	JobTypeImport = "import"
+	JobTypeAudit  = "integrity_audit"
+)
+
+// AdminJobOwner owns jobs started through the admin API
+const AdminJobOwner = "admin"
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job tracks a long running task started on behalf of a user. Jobs live in
+// Redis only and expire some time after they finish.
+type Job struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"userid"`
+	Type      string            `json:"type"`
+	Status    string            `json:"status"`
+	Done      int               `json:"done"`
+	Total     int               `json:"total"`
+	Error     string            `json:"error,omitempty"`
+	Result    map[string]string `json:"result,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// Finished reports whether the job reached a terminal status
+func (j Job) Finished() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed
+}
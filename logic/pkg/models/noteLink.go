@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// Link origins
+const (
+	LinkParsed   = "parsed"
+	LinkDeclared = "declared"
+)
+
+// NoteLink is a reference from one note to another, found in its content by
+// the sync server or declared by the client that saved it
+type NoteLink struct {
+	SourceID  string    `gorm:"primaryKey;column:source_id;type:uuid" json:"sourceId"`
+	TargetID  string    `gorm:"primaryKey;column:target_id;type:uuid;index" json:"targetId"`
+	UserID    string    `gorm:"column:userid;type:uuid;not null;index" json:"userid"`
+	Origin    string    `gorm:"column:origin;type:varchar(8);not null" json:"origin"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteLink) TableName() string {
+	return "note_link"
+}
+
+// Backlink is a note that links to the requested one
+type Backlink struct {
+	NoteID     string    `gorm:"column:noteid" json:"noteid"`
+	Heading    string    `json:"heading"`
+	Origin     string    `json:"origin"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+type GraphNode struct {
+	NoteID  string `gorm:"column:noteid" json:"noteid"`
+	Heading string `json:"heading"`
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Origin string `json:"origin"`
+}
+
+// NoteGraph is the link graph of a user's notes
+type NoteGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
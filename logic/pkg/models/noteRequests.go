@@ -1,6 +1,63 @@
 package models
 
+import (
+	"encoding/json"
+)
+
 type DeleteNoteRequest struct {
 	NoteID            string `json:"noteid"`
 	DeletePermanently bool   `json:"deletePermanently"`
 }
+
+// Batch operation types
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// Batch operation result statuses
+const (
+	BatchStatusAccepted = "accepted"
+	BatchStatusRejected = "rejected"
+	BatchStatusSkipped  = "skipped"
+)
+
+type BatchNoteOperation struct {
+	Op                string `json:"op" validate:"required,oneof=create update delete"`
+	NoteID            string `json:"noteid" validate:"omitempty,uuid"`
+	ClientRef         string `json:"clientRef,omitempty"`
+	Content           string `json:"content"`
+	Heading           string `json:"heading"`
+	H                 string `json:"h"`
+	Intgrh            string `json:"intgrh"`
+	Deleted           int    `json:"deleted"`
+	DeletePermanently bool   `json:"deletePermanently"`
+	// Links replaces the declared links of a created or updated note
+	Links []string `json:"links,omitempty" validate:"omitempty,max=1000,dive,uuid"`
+}
+
+type BatchNoteRequest struct {
+	Operations []BatchNoteOperation `json:"operations" validate:"required,min=1,max=500,dive"`
+}
+
+type BatchNoteResult struct {
+	Index     int    `json:"index"`
+	Op        string `json:"op"`
+	NoteID    string `json:"noteid,omitempty"`
+	ClientRef string `json:"clientRef,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PatchNoteRequest changes a note by a patch against the content whose h is
+// BaseHash. H and Intgrh are the digests of the patched note; Intgrh may be
+// left out when the heading does not change.
+type PatchNoteRequest struct {
+	BaseHash string          `json:"baseHash" validate:"required"`
+	Format   string          `json:"format" validate:"required,oneof=text json-patch"`
+	Patch    json.RawMessage `json:"patch" validate:"required"`
+	H        string          `json:"h" validate:"required"`
+	Intgrh   string          `json:"intgrh"`
+	Links    []string        `json:"links,omitempty" validate:"omitempty,max=1000,dive,uuid"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+)
+
+// Note permissions
+const (
+	PermissionOwner = "owner"
+	PermissionEdit  = "edit"
+	PermissionRead  = "read"
+)
+
+// NoteShare grants another user access to a note. KeyEnvelope holds the
+// note key encrypted for the recipient by the owner's client; the server
+// only stores and hands it out.
+type NoteShare struct {
+	ID           string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	NoteID       string    `gorm:"column:noteid;type:uuid;not null;uniqueIndex:idx_note_share_recipient" json:"noteid"`
+	OwnerID      string    `gorm:"column:owner_id;type:uuid;not null;index" json:"ownerId"`
+	SharedWithID string    `gorm:"column:shared_with_id;type:uuid;not null;uniqueIndex:idx_note_share_recipient;index" json:"sharedWithId"`
+	Permission   string    `gorm:"column:permission;type:varchar(8);not null" json:"permission"`
+	KeyEnvelope  string    `gorm:"column:key_envelope;not null" json:"keyEnvelope"`
+	CreatedAt    time.Time `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteShare) TableName() string {
+	return "note_share"
+}
+
+type ShareNoteRequest struct {
+	Email       string `json:"email" validate:"required,email"`
+	Permission  string `json:"permission" validate:"required,oneof=read edit"`
+	KeyEnvelope string `json:"keyEnvelope" validate:"required"`
+}
+
+// NoteShareInfo is a share as listed to the note owner
+type NoteShareInfo struct {
+	NoteShare
+	Email string `json:"email"`
+}
+
+// SharedNote is a note as listed to a user it was shared with
+type SharedNote struct {
+	Note        Notes  `json:"note"`
+	ShareID     string `json:"shareId"`
+	Permission  string `json:"permission"`
+	KeyEnvelope string `json:"keyEnvelope"`
+	OwnerEmail  string `json:"ownerEmail"`
+}
+
+// NoteAccess describes what a user may do with a note
+type NoteAccess struct {
+	OwnerID    string
+	Permission string
+}
+
+func (a NoteAccess) CanRead() bool {
+	return a.Permission != ""
+}
+
+func (a NoteAccess) CanEdit() bool {
+	return a.Permission == PermissionOwner || a.Permission == PermissionEdit
+}
@@ -14,6 +14,9 @@ type Notes struct {
 	UpdateTime time.Time `gorm:"column:update_time;type:timestamptz;default:CURRENT_TIMESTAMP" json:"update_time"`
 	Heading    string    `gorm:"column:heading" json:"heading"`
 	Deleted    int       `gorm:"column:deleted;not null;default:0" json:"deleted"`
+	// Links declared by the client, for content the server cannot parse.
+	// Only sent with updates; see models.NoteLink for the stored links.
+	Links []string `gorm:"-" json:"links,omitempty" validate:"omitempty,max=1000,dive,uuid"`
 }
 
 // TableName overrides the default table name for GORM
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// PublicLink publishes a single note read-only at an unguessable URL. Only a
+// hash of the token is stored; the token itself is shown once on creation.
+type PublicLink struct {
+	ID           string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TokenHash    string     `gorm:"column:token_hash;not null;uniqueIndex" json:"-"`
+	NoteID       string     `gorm:"column:noteid;type:uuid;not null;index" json:"noteid"`
+	UserID       string     `gorm:"column:userid;type:uuid;not null" json:"userid"`
+	ExpiresAt    *time.Time `gorm:"column:expires_at;type:timestamptz" json:"expiresAt,omitempty"`
+	PasswordHash string     `gorm:"column:password_hash" json:"-"`
+	MaxViews     int        `gorm:"column:max_views;not null;default:0" json:"maxViews"`
+	Views        int        `gorm:"column:views;not null;default:0" json:"views"`
+	Revoked      bool       `gorm:"column:revoked;not null;default:false" json:"revoked"`
+	CreatedAt    time.Time  `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// TableName overrides the default table name for GORM
+func (PublicLink) TableName() string {
+	return "note_public_link"
+}
+
+// HasPassword is exposed so clients can show which links are protected
+func (l PublicLink) HasPassword() bool {
+	return l.PasswordHash != ""
+}
+
+type CreatePublicLinkRequest struct {
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Password  string     `json:"password" validate:"omitempty,min=4,max=72"`
+	MaxViews  int        `json:"maxViews" validate:"omitempty,min=1"`
+}
+
+type PublicLinkResponse struct {
+	Link        PublicLink `json:"link"`
+	HasPassword bool       `json:"hasPassword"`
+	URL         string     `json:"url,omitempty"`
+}
+
+// PublicNote is what an anonymous visitor of a public link gets to see
+type PublicNote struct {
+	Heading    string    `json:"heading"`
+	Content    string    `json:"content"`
+	UpdateTime time.Time `json:"update_time"`
+}
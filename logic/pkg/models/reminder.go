@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// Reminder statuses
+const (
+	ReminderScheduled = "scheduled"
+	ReminderDone      = "done"
+	ReminderCancelled = "cancelled"
+)
+
+// NoteReminder notifies its user about a note at FireAt, and again at every
+// further occurrence of RRule. StartAt and Timezone anchor the recurrence.
+type NoteReminder struct {
+	ID          string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	NoteID      string     `gorm:"column:noteid;type:uuid;not null;index" json:"noteid"`
+	UserID      string     `gorm:"column:userid;type:uuid;not null;index" json:"userid"`
+	StartAt     time.Time  `gorm:"column:start_at;type:timestamptz;not null" json:"startAt"`
+	Timezone    string     `gorm:"column:timezone;not null" json:"timezone"`
+	RRule       string     `gorm:"column:rrule" json:"rrule,omitempty"`
+	FireAt      time.Time  `gorm:"column:fire_at;type:timestamptz;not null;index" json:"fireAt"`
+	Occurrences int        `gorm:"column:occurrences;not null;default:0" json:"occurrences"`
+	LastFiredAt *time.Time `gorm:"column:last_fired_at;type:timestamptz" json:"lastFiredAt,omitempty"`
+	Status      string     `gorm:"column:status;not null;default:'scheduled'" json:"status"`
+	CreatedAt   time.Time  `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteReminder) TableName() string {
+	return "note_reminder"
+}
+
+type CreateReminderRequest struct {
+	At       time.Time `json:"at" validate:"required"`
+	Timezone string    `json:"timezone" validate:"required"`
+	RRule    string    `json:"rrule" validate:"omitempty,max=255"`
+}
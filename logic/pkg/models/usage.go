@@ -0,0 +1,22 @@
+package models
+
+// Usage is what a user currently stores against their quota
+type Usage struct {
+	Notes           int   `json:"notes"`
+	Bytes           int64 `json:"bytes"`
+	AttachmentBytes int64 `json:"attachmentBytes"`
+}
+
+// UsageLimits mirrors config.QuotaLimits for API responses. Zero means unlimited.
+type UsageLimits struct {
+	MaxNoteBytes    int64 `json:"maxNoteBytes"`
+	MaxNotes        int   `json:"maxNotes"`
+	MaxBytes        int64 `json:"maxBytes"`
+	AttachmentBytes int64 `json:"attachmentBytes"`
+}
+
+type UsageResponse struct {
+	Tier   string      `json:"tier"`
+	Usage  Usage       `json:"usage"`
+	Limits UsageLimits `json:"limits"`
+}
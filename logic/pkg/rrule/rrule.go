@@ -0,0 +1,252 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules used by
+// note reminders: FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT,
+// UNTIL and, for weekly rules, BYDAY with plain weekdays.
+//
+// Occurrences keep the wall clock time of the series start in its location,
+// so a daily 09:00 reminder stays at 09:00 across DST changes. Monthly and
+// yearly rules skip months without the start day, e.g. the 31st or Feb 29.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// maxPeriods bounds the periods searched for the next occurrence, from the
+// one holding the given time on. Only rules whose periods rarely hold an
+// occurrence, such as the 29th of February, need more than a few.
+const maxPeriods = 1000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	Count    int       // Number of occurrences, 0 for no limit
+	Until    time.Time // Last possible occurrence, zero for no limit
+	ByDay    []time.Weekday
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE". An
+// "RRULE:" prefix is accepted.
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return rule, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = Frequency(strings.ToUpper(value))
+			switch rule.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				err = fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+			if err == nil && rule.Interval < 1 {
+				err = errors.New("INTERVAL must be positive")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+			if err == nil && rule.Count < 1 {
+				err = errors.New("COUNT must be positive")
+			}
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		case "BYDAY":
+			for _, day := range strings.Split(strings.ToUpper(value), ",") {
+				wd, ok := weekdays[day]
+				if !ok {
+					err = fmt.Errorf("unsupported BYDAY %q", day)
+					break
+				}
+				rule.ByDay = append(rule.ByDay, wd)
+			}
+		default:
+			err = fmt.Errorf("unsupported part %q", name)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return rule, fmt.Errorf("%w: COUNT and UNTIL are exclusive", ErrInvalidRule)
+	}
+	if len(rule.ByDay) > 0 && rule.Freq != Weekly {
+		return rule, fmt.Errorf("%w: BYDAY is only supported with FREQ=WEEKLY", ErrInvalidRule)
+	}
+
+	// Monday first, matching the week start used for weekly periods
+	sort.Slice(rule.ByDay, func(i, j int) bool {
+		return weekdayIndex(rule.ByDay[i]) < weekdayIndex(rule.ByDay[j])
+	})
+
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+// Next returns the first occurrence of the series starting at start that is
+// after the given time. ok is false once the series has ended. The search
+// starts at the period before the one holding after, so it takes as long for
+// a series started years ago as for a new one.
+func (r Rule) Next(start, after time.Time) (next time.Time, ok bool) {
+	first := max(r.periodOf(start, after)-1, 0)
+	n := r.occurrences(start, first)
+	for period := first; period < first+maxPeriods; period++ {
+		for _, t := range r.period(start, period) {
+			if t.Before(start) {
+				continue
+			}
+			n++
+			if r.Count > 0 && n > r.Count {
+				return time.Time{}, false
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return time.Time{}, false
+			}
+			if t.After(after) {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// periodOf returns the period that holds t. Occurrences keep the wall clock
+// time of start, so t may also fall at the end of the period before.
+func (r Rule) periodOf(start, t time.Time) int {
+	sy, sm, sd := start.Date()
+	ty, tm, td := t.In(start.Location()).Date()
+
+	var k int
+	switch r.Freq {
+	case Daily:
+		k = civilDays(ty, tm, td) - civilDays(sy, sm, sd)
+	case Weekly:
+		// Weeks from the Monday of the start's week
+		k = (civilDays(ty, tm, td) - civilDays(sy, sm, sd) + weekdayIndex(start.Weekday())) / 7
+	case Monthly:
+		k = (ty-sy)*12 + int(tm-sm)
+	case Yearly:
+		k = ty - sy
+	}
+	if k < 0 {
+		return 0
+	}
+	return k / r.Interval
+}
+
+// occurrences returns the number of occurrences in the first k periods
+func (r Rule) occurrences(start time.Time, k int) int {
+	if k == 0 {
+		return 0
+	}
+	switch {
+	case r.Freq == Daily, r.Freq == Weekly && len(r.ByDay) == 0:
+		return k
+	case r.Freq == Weekly:
+		// The first week only has the days from the start on
+		n := 0
+		for _, t := range r.period(start, 0) {
+			if !t.Before(start) {
+				n++
+			}
+		}
+		return n + (k-1)*len(r.ByDay)
+	}
+
+	// Months and years without the start day have none; there are at most
+	// twelve periods a year to look at
+	n := 0
+	for period := 0; period < k; period++ {
+		n += len(r.period(start, period))
+	}
+	return n
+}
+
+// civilDays numbers the calendar days, whatever the clock and location
+func civilDays(y int, m time.Month, d int) int {
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// period returns the candidate occurrences of the k-th period in order
+func (r Rule) period(start time.Time, k int) []time.Time {
+	y, m, d := start.Date()
+	hh, mm, ss := start.Clock()
+	loc := start.Location()
+	step := k * r.Interval
+
+	switch r.Freq {
+	case Daily:
+		return []time.Time{time.Date(y, m, d+step, hh, mm, ss, 0, loc)}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			return []time.Time{time.Date(y, m, d+7*step, hh, mm, ss, 0, loc)}
+		}
+		monday := d - weekdayIndex(start.Weekday()) + 7*step
+		times := make([]time.Time, 0, len(r.ByDay))
+		for _, wd := range r.ByDay {
+			times = append(times, time.Date(y, m, monday+weekdayIndex(wd), hh, mm, ss, 0, loc))
+		}
+		return times
+	case Monthly:
+		if d > daysIn(y, m+time.Month(step), loc) {
+			return nil
+		}
+		return []time.Time{time.Date(y, m+time.Month(step), d, hh, mm, ss, 0, loc)}
+	case Yearly:
+		if d > daysIn(y+step, m, loc) {
+			return nil
+		}
+		return []time.Time{time.Date(y+step, m, d, hh, mm, ss, 0, loc)}
+	}
+	return nil
+}
+
+func weekdayIndex(wd time.Weekday) int {
+	return (int(wd) + 6) % 7
+}
+
+func daysIn(y int, m time.Month, loc *time.Location) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, loc).Day()
+}
@@ -0,0 +1,150 @@
+package rrule
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    Rule
+		wantErr bool
+	}{
+		{rule: "FREQ=DAILY", want: Rule{Freq: Daily, Interval: 1}},
+		{rule: "RRULE:FREQ=weekly;INTERVAL=2;BYDAY=WE,MO", want: Rule{Freq: Weekly, Interval: 2, ByDay: []time.Weekday{time.Monday, time.Wednesday}}},
+		{rule: "FREQ=MONTHLY;COUNT=3", want: Rule{Freq: Monthly, Interval: 1, Count: 3}},
+		{rule: "FREQ=YEARLY;UNTIL=20300101", want: Rule{Freq: Yearly, Interval: 1, Until: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{rule: "", wantErr: true},
+		{rule: "FREQ=HOURLY", wantErr: true},
+		{rule: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{rule: "FREQ=DAILY;COUNT=2;UNTIL=20300101", wantErr: true},
+		{rule: "FREQ=DAILY;BYDAY=MO", wantErr: true},
+		{rule: "FREQ=WEEKLY;BYDAY=1MO", wantErr: true},
+		{rule: "FREQ=DAILY;BYHOUR=9", wantErr: true},
+		{rule: "FREQ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := Parse(tt.rule)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRule) {
+					t.Fatalf("Parse() error = %v, want ErrInvalidRule", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	at := func(y int, m time.Month, d, hh int) time.Time {
+		return time.Date(y, m, d, hh, 0, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name   string
+		rule   string
+		start  time.Time
+		after  time.Time
+		want   time.Time
+		wantOK bool
+	}{
+		{name: "daily before start", rule: "FREQ=DAILY", start: at(2026, 1, 10, 9), after: at(2026, 1, 1, 0), want: at(2026, 1, 10, 9), wantOK: true},
+		{name: "daily", rule: "FREQ=DAILY", start: at(2026, 1, 10, 9), after: at(2026, 1, 10, 9), want: at(2026, 1, 11, 9), wantOK: true},
+		{name: "daily keeps the wall clock across DST", rule: "FREQ=DAILY", start: at(2026, 3, 28, 9), after: at(2026, 3, 28, 12), want: at(2026, 3, 29, 9), wantOK: true},
+		{name: "every third day", rule: "FREQ=DAILY;INTERVAL=3", start: at(2026, 1, 1, 9), after: at(2026, 1, 5, 0), want: at(2026, 1, 7, 9), wantOK: true},
+		{name: "weekly", rule: "FREQ=WEEKLY", start: at(2026, 1, 7, 9), after: at(2026, 1, 8, 0), want: at(2026, 1, 14, 9), wantOK: true},
+		{name: "weekly by day", rule: "FREQ=WEEKLY;BYDAY=MO,TH", start: at(2026, 1, 6, 9), after: at(2026, 1, 9, 0), want: at(2026, 1, 12, 9), wantOK: true},
+		{name: "weekly by day skips days before start", rule: "FREQ=WEEKLY;BYDAY=MO,TH", start: at(2026, 1, 6, 9), after: at(2026, 1, 1, 0), want: at(2026, 1, 8, 9), wantOK: true},
+		{name: "monthly skips short months", rule: "FREQ=MONTHLY", start: at(2026, 1, 31, 9), after: at(2026, 2, 1, 0), want: at(2026, 3, 31, 9), wantOK: true},
+		{name: "yearly on leap day", rule: "FREQ=YEARLY", start: at(2024, 2, 29, 9), after: at(2024, 3, 1, 0), want: at(2028, 2, 29, 9), wantOK: true},
+		{name: "count reached", rule: "FREQ=DAILY;COUNT=3", start: at(2026, 1, 1, 9), after: at(2026, 1, 3, 9)},
+		{name: "last of count", rule: "FREQ=DAILY;COUNT=3", start: at(2026, 1, 1, 9), after: at(2026, 1, 2, 9), want: at(2026, 1, 3, 9), wantOK: true},
+		{name: "count of weekly by day", rule: "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3", start: at(2026, 1, 6, 9), after: at(2026, 1, 12, 9), want: at(2026, 1, 15, 9), wantOK: true},
+		{name: "count of weekly by day reached", rule: "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3", start: at(2026, 1, 6, 9), after: at(2026, 1, 15, 9)},
+		{name: "count of monthly with skipped months", rule: "FREQ=MONTHLY;COUNT=3", start: at(2026, 1, 31, 9), after: at(2026, 5, 1, 0), want: at(2026, 5, 31, 9), wantOK: true},
+		{name: "until reached", rule: "FREQ=DAILY;UNTIL=20260105T000000Z", start: at(2026, 1, 1, 9), after: at(2026, 1, 4, 9)},
+		{name: "decades after start", rule: "FREQ=DAILY", start: at(1970, 1, 1, 9), after: at(2026, 6, 15, 10), want: at(2026, 6, 16, 9), wantOK: true},
+		{name: "count decades after start", rule: "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=5000", start: at(1990, 1, 3, 9), after: at(2026, 1, 1, 0), want: at(2026, 1, 2, 9), wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got, ok := rule.Next(tt.start, tt.after)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// nextByWalking is Next as it was before it jumped to the period of after:
+// every period from the start is walked
+func nextByWalking(r Rule, start, after time.Time) (time.Time, bool) {
+	n := 0
+	for period := 0; period < 100000; period++ {
+		for _, t := range r.period(start, period) {
+			if t.Before(start) {
+				continue
+			}
+			n++
+			if r.Count > 0 && n > r.Count {
+				return time.Time{}, false
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return time.Time{}, false
+			}
+			if t.After(after) {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func TestNextMatchesWalking(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	start := time.Date(2019, 10, 31, 1, 30, 0, 0, newYork)
+
+	rules := []string{
+		"FREQ=DAILY;INTERVAL=5;COUNT=400",
+		"FREQ=WEEKLY;INTERVAL=3",
+		"FREQ=WEEKLY;BYDAY=TU,TH,SU;COUNT=700",
+		"FREQ=MONTHLY;INTERVAL=2;COUNT=20",
+		"FREQ=YEARLY;UNTIL=20300101T000000Z",
+	}
+	for _, s := range rules {
+		rule, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", s, err)
+		}
+		for after := start.AddDate(0, 0, -3); after.Before(start.AddDate(8, 0, 0)); after = after.Add(61 * time.Hour) {
+			got, ok := rule.Next(start, after)
+			want, wantOK := nextByWalking(rule, start, after)
+			if ok != wantOK || !got.Equal(want) {
+				t.Fatalf("%s: Next(%v) = %v, %v, want %v, %v", s, after, got, ok, want, wantOK)
+			}
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"pdm-logic-server/pkg/blob"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/util"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoteNotFound       = errors.New("note not found")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrFileTooLarge       = errors.New("attachment exceeds the maximum file size")
+	ErrQuotaExceeded      = errors.New("storage quota exceeded")
+	ErrChunkOffset        = errors.New("chunk offset does not match the uploaded size")
+	ErrChunkTooLarge      = errors.New("chunk exceeds the maximum chunk size")
+	ErrHashMismatch       = errors.New("content hash mismatch")
+	ErrUploadComplete     = errors.New("upload already complete")
+)
+
+// attachmentSeedAttempts bounds how often a missing attachment usage counter
+// is seeded before a reservation gives up
+const attachmentSeedAttempts = 3
+
+// CreateAttachment registers a pending upload for a note after checking the
+// file size limit and the user's attachment quota. The content is sent
+// afterwards with UploadAttachmentChunk.
+func (s *Storage) CreateAttachment(ctx context.Context, userId, noteID string, req models.CreateAttachmentRequest, cfg *config.AttachmentConfig) (models.NoteAttachment, error) {
+	var attachment models.NoteAttachment
+
+	owned, err := s.NoteOwnedBy(ctx, noteID, userId)
+	if err != nil {
+		return attachment, err
+	}
+	if !owned {
+		return attachment, ErrNoteNotFound
+	}
+
+	if req.Size > cfg.MaxFileSize {
+		return attachment, ErrFileTooLarge
+	}
+
+	if err := s.reserveAttachmentBytes(ctx, userId, req.Size, cfg.QuotaBytes); err != nil {
+		return attachment, err
+	}
+
+	id := util.NewUUID()
+	attachment = models.NoteAttachment{
+		ID:          id,
+		NoteID:      noteID,
+		UserID:      userId,
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		SHA256:      req.SHA256,
+		StorageKey:  fmt.Sprintf("attachments/%s/%s", userId, id),
+		Status:      models.AttachmentPending,
+	}
+	if attachment.ContentType == "" {
+		attachment.ContentType = "application/octet-stream"
+	}
+
+	if err := s.DB.Create(&attachment).Error; err != nil {
+		s.releaseAttachmentBytes(ctx, userId, req.Size)
+		return attachment, err
+	}
+
+	return attachment, nil
+}
+
+// reserveAttachmentBytes reserves size bytes of the user's attachment quota.
+// The reservation and the check are one INCRBY on a counter in Redis, so
+// concurrent uploads cannot both fit into the same free space; one that
+// goes over is undone. A missing counter is seeded from the database, and
+// it expires with the other usage counters, so drift does not last.
+func (s *Storage) reserveAttachmentBytes(ctx context.Context, userId string, size, quotaBytes int64) error {
+	key := s.Ch.Schema.AttachmentUsage(userId)
+	used, ok, err := s.Ch.IncrByIfExists(ctx, key, size)
+	for attempt := 0; !ok && err == nil; attempt++ {
+		if attempt == attachmentSeedAttempts {
+			return fmt.Errorf("attachment usage counter of user %s keeps disappearing", userId)
+		}
+		var stored int64
+		if stored, err = s.AttachmentUsage(ctx, userId); err != nil {
+			break
+		}
+		if _, err = s.Ch.SetNX(ctx, key, strconv.FormatInt(stored, 10), s.Ch.Schema.TTL(keys.Usage)); err != nil {
+			break
+		}
+		used, ok, err = s.Ch.IncrByIfExists(ctx, key, size)
+	}
+	if err != nil {
+		return err
+	}
+
+	if used > quotaBytes {
+		s.releaseAttachmentBytes(ctx, userId, size)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// releaseAttachmentBytes gives back bytes reserved by reserveAttachmentBytes
+func (s *Storage) releaseAttachmentBytes(ctx context.Context, userId string, size int64) {
+	s.adjustUsage(ctx, s.Ch.Schema.AttachmentUsage(userId), -size)
+}
+
+// AttachmentUsage returns the bytes reserved by all of a user's attachments
+// in the database, including uploads that are still in progress.
+func (s *Storage) AttachmentUsage(ctx context.Context, userId string) (int64, error) {
+	var used int64
+	err := s.DB.Model(&models.NoteAttachment{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("userid = ?", userId).
+		Scan(&used).Error
+	return used, err
+}
+
+func (s *Storage) GetAttachment(ctx context.Context, userId, noteID, attachmentID string) (models.NoteAttachment, error) {
+	var attachment models.NoteAttachment
+	err := s.DB.Where("id = ? AND noteid = ? AND userid = ?", attachmentID, noteID, userId).
+		First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return attachment, ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+func (s *Storage) ListAttachments(ctx context.Context, userId, noteID string) ([]models.NoteAttachment, error) {
+	owned, err := s.NoteOwnedBy(ctx, noteID, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrNoteNotFound
+	}
+
+	attachments := []models.NoteAttachment{}
+	err = s.DB.Where("noteid = ? AND userid = ?", noteID, userId).
+		Order("created_at").
+		Find(&attachments).Error
+	return attachments, err
+}
+
+// UploadAttachmentChunk stores the next chunk of a pending upload. Chunks must
+// arrive in order: offset has to equal the bytes uploaded so far, which a
+// client resuming an interrupted upload reads back from the attachment. An
+// optional chunkSHA256 is checked against the chunk. Once the last chunk
+// arrives the parts are assembled and the whole file is verified against the
+// hash declared when the upload was created.
+func (s *Storage) UploadAttachmentChunk(ctx context.Context, userId, noteID, attachmentID string, offset int64, chunkSHA256 string, body io.Reader, cfg *config.AttachmentConfig) (models.NoteAttachment, error) {
+	attachment, err := s.GetAttachment(ctx, userId, noteID, attachmentID)
+	if err != nil {
+		return attachment, err
+	}
+	if attachment.Status == models.AttachmentComplete {
+		return attachment, ErrUploadComplete
+	}
+	if offset != attachment.UploadedSize {
+		return attachment, ErrChunkOffset
+	}
+
+	chunk, err := io.ReadAll(io.LimitReader(body, cfg.ChunkSize+1))
+	if err != nil {
+		return attachment, err
+	}
+	if int64(len(chunk)) > cfg.ChunkSize || offset+int64(len(chunk)) > attachment.Size {
+		return attachment, ErrChunkTooLarge
+	}
+	if len(chunk) == 0 {
+		return attachment, ErrChunkOffset
+	}
+	if chunkSHA256 != "" {
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != chunkSHA256 {
+			return attachment, ErrHashMismatch
+		}
+	}
+
+	if err := s.storeAttachmentPart(ctx, attachment, offset, chunk); err != nil {
+		return attachment, err
+	}
+
+	// Only advance if no concurrent upload of the same chunk got there first
+	uploaded := offset + int64(len(chunk))
+	result := s.DB.Model(&models.NoteAttachment{}).
+		Where("id = ? AND uploaded_size = ?", attachment.ID, offset).
+		Update("uploaded_size", uploaded)
+	if result.Error != nil {
+		return attachment, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return attachment, ErrChunkOffset
+	}
+	attachment.UploadedSize = uploaded
+
+	if uploaded == attachment.Size {
+		return s.completeAttachment(ctx, attachment)
+	}
+	return attachment, nil
+}
+
+// attachmentPartsPrefix is where the parts of an upload are kept until it
+// completes. It is a sibling of the final object rather than below it: on
+// the local backend a key with keys below it is a directory, and the object
+// could not be written there.
+func attachmentPartsPrefix(storageKey string) string {
+	return storageKey + ".parts/"
+}
+
+// storeAttachmentPart stores the chunk of an upload starting at offset
+func (s *Storage) storeAttachmentPart(ctx context.Context, attachment models.NoteAttachment, offset int64, chunk []byte) error {
+	partKey := fmt.Sprintf("%s%020d", attachmentPartsPrefix(attachment.StorageKey), offset)
+	return s.Blob.Put(ctx, partKey, bytes.NewReader(chunk), int64(len(chunk)), "application/octet-stream")
+}
+
+// assembleAttachment concatenates the uploaded parts into the final object
+// and deletes them, returning the hex SHA-256 of the content.
+func (s *Storage) assembleAttachment(ctx context.Context, attachment models.NoteAttachment) (string, error) {
+	parts, err := s.Blob.List(ctx, attachmentPartsPrefix(attachment.StorageKey))
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	reader := io.TeeReader(&partsReader{ctx: ctx, store: s.Blob, keys: parts}, hasher)
+	if err := s.Blob.Put(ctx, attachment.StorageKey, reader, attachment.Size, attachment.ContentType); err != nil {
+		return "", err
+	}
+	s.deleteBlobs(ctx, parts)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// completeAttachment assembles the uploaded parts into the final object,
+// verifying the content hash on the way.
+func (s *Storage) completeAttachment(ctx context.Context, attachment models.NoteAttachment) (models.NoteAttachment, error) {
+	digest, err := s.assembleAttachment(ctx, attachment)
+	if err != nil {
+		return attachment, err
+	}
+
+	if digest != attachment.SHA256 {
+		// Start over: the client has to upload the file again
+		s.deleteBlobs(ctx, []string{attachment.StorageKey})
+		if err := s.DB.Model(&models.NoteAttachment{}).Where("id = ?", attachment.ID).Update("uploaded_size", 0).Error; err != nil {
+			log.Printf("Failed to reset attachment upload: %v", err)
+		}
+		attachment.UploadedSize = 0
+		return attachment, ErrHashMismatch
+	}
+
+	now := time.Now()
+	attachment.Status = models.AttachmentComplete
+	attachment.CompletedAt = &now
+	err = s.DB.Model(&models.NoteAttachment{}).
+		Where("id = ?", attachment.ID).
+		Updates(map[string]interface{}{
+			"status":       attachment.Status,
+			"completed_at": now,
+		}).Error
+	return attachment, err
+}
+
+func (s *Storage) DeleteAttachment(ctx context.Context, userId, noteID, attachmentID string) error {
+	attachment, err := s.GetAttachment(ctx, userId, noteID, attachmentID)
+	if err != nil {
+		return err
+	}
+
+	s.deleteAttachmentBlobs(ctx, attachment.StorageKey)
+
+	if err := s.DB.Delete(&models.NoteAttachment{}, "id = ?", attachment.ID).Error; err != nil {
+		return err
+	}
+	s.releaseAttachmentBytes(ctx, userId, attachment.Size)
+	return nil
+}
+
+// RemoveDeletedAttachments removes the files of attachments the sync server
+// deleted along with their notes, and gives their bytes back to the user's
+// attachment quota.
+func (s *Storage) RemoveDeletedAttachments(ctx context.Context, deleted DeletedAttachments) {
+	log.Printf("Removing %d attachments of deleted notes of user %s", len(deleted.StorageKeys), deleted.UserID)
+	for _, key := range deleted.StorageKeys {
+		s.deleteAttachmentBlobs(ctx, key)
+	}
+	s.releaseAttachmentBytes(ctx, deleted.UserID, deleted.Bytes)
+}
+
+// deleteAttachmentBlobs deletes an attachment's object and any parts of an
+// upload that did not complete
+func (s *Storage) deleteAttachmentBlobs(ctx context.Context, storageKey string) {
+	parts, err := s.Blob.List(ctx, attachmentPartsPrefix(storageKey))
+	if err != nil {
+		log.Printf("Failed to list attachment parts: %v", err)
+	}
+	s.deleteBlobs(ctx, append(parts, storageKey))
+}
+
+// OpenAttachment opens the content of a completed attachment. It does no
+// ownership checks; callers must have verified a signed download URL.
+func (s *Storage) OpenAttachment(ctx context.Context, attachmentID string) (models.NoteAttachment, io.ReadCloser, error) {
+	var attachment models.NoteAttachment
+	err := s.DB.Where("id = ? AND status = ?", attachmentID, models.AttachmentComplete).
+		First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return attachment, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return attachment, nil, err
+	}
+
+	content, err := s.Blob.Get(ctx, attachment.StorageKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return attachment, nil, ErrAttachmentNotFound
+	}
+	return attachment, content, err
+}
+
+func (s *Storage) deleteBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.Blob.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// partsReader reads a list of blobs back to back, opening each one lazily.
+type partsReader struct {
+	ctx     context.Context
+	store   blob.BlobStore
+	keys    []string
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(buf []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := p.store.Get(p.ctx, p.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			p.current = rc
+			p.keys = p.keys[1:]
+		}
+
+		n, err := p.current.Read(buf)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
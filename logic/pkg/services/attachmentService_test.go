@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"pdm-logic-server/pkg/blob"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssembleAttachmentOnLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	s := &Storage{Blob: store}
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	sum := sha256.Sum256(content)
+
+	tests := []struct {
+		name      string
+		chunkSize int
+	}{
+		{name: "single chunk", chunkSize: len(content)},
+		{name: "even chunks", chunkSize: 4000},
+		{name: "short last chunk", chunkSize: 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachment := models.NoteAttachment{
+				ID:          tt.name,
+				StorageKey:  "attachments/u1/" + tt.name,
+				Size:        int64(len(content)),
+				ContentType: "application/octet-stream",
+			}
+			for offset := 0; offset < len(content); offset += tt.chunkSize {
+				end := min(offset+tt.chunkSize, len(content))
+				if err := s.storeAttachmentPart(ctx, attachment, int64(offset), content[offset:end]); err != nil {
+					t.Fatalf("storeAttachmentPart(%d) error = %v", offset, err)
+				}
+			}
+
+			digest, err := s.assembleAttachment(ctx, attachment)
+			if err != nil {
+				t.Fatalf("assembleAttachment() error = %v", err)
+			}
+			if digest != hex.EncodeToString(sum[:]) {
+				t.Errorf("assembleAttachment() digest = %s, want %x", digest, sum)
+			}
+
+			rc, err := store.Get(ctx, attachment.StorageKey)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("reading attachment: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("stored %d bytes, want the %d uploaded", len(got), len(content))
+			}
+
+			parts, err := store.List(ctx, attachmentPartsPrefix(attachment.StorageKey))
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(parts) != 0 {
+				t.Errorf("parts left after assembly: %v", parts)
+			}
+		})
+	}
+}
+
+func TestRemoveDeletedAttachments(t *testing.T) {
+	ctx := context.Background()
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	s := &Storage{
+		Blob: store,
+		Ch:   cache.NewCache(cache.NewMemoryClient(), keys.NewSchema(&config.CacheKeysConfig{Version: 1, UsageTTL: time.Hour})),
+	}
+
+	// A complete attachment, an upload that did not complete and an
+	// attachment that stays
+	complete := models.NoteAttachment{StorageKey: "attachments/u1/a"}
+	partial := models.NoteAttachment{StorageKey: "attachments/u1/b"}
+	kept := "attachments/u1/c"
+	for _, key := range []string{complete.StorageKey, kept} {
+		if err := store.Put(ctx, key, strings.NewReader("content"), 7, "text/plain"); err != nil {
+			t.Fatalf("Put(%q) error = %v", key, err)
+		}
+	}
+	if err := s.storeAttachmentPart(ctx, partial, 0, []byte("part")); err != nil {
+		t.Fatalf("storeAttachmentPart() error = %v", err)
+	}
+	usage := s.Ch.Schema.AttachmentUsage("u1")
+	if err := s.Ch.Set(ctx, usage, "100", time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	s.RemoveDeletedAttachments(ctx, DeletedAttachments{
+		UserID:      "u1",
+		StorageKeys: []string{complete.StorageKey, partial.StorageKey},
+		Bytes:       30,
+	})
+
+	left, err := store.List(ctx, "attachments/u1/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if !reflect.DeepEqual(left, []string{kept}) {
+		t.Errorf("blobs left = %v, want %v", left, []string{kept})
+	}
+	if used, err := s.Ch.Get(ctx, usage); err != nil || used != "70" {
+		t.Errorf("attachment usage = %q, %v, want 70", used, err)
+	}
+}
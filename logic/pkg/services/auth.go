@@ -2,6 +2,7 @@ package services
 
 import (
 	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"github.com/golang-jwt/jwt"
 	"log"
@@ -86,3 +87,26 @@ func (a *AuthService) HealthCheck() error {
 	log.Printf("JWT system health check passed - token generation and validation working correctly")
 	return nil
 }
+
+// SignURL signs a subject (such as an attachment ID) together with an expiry
+// time, for links that grant access without a session.
+func (a *AuthService) SignURL(subject string, expires int64) string {
+	sig := ed25519.Sign(a.PrivateKey, urlSigningMessage(subject, expires))
+	return base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// VerifyURL checks a signature produced by SignURL and that it has not expired.
+func (a *AuthService) VerifyURL(subject string, expires int64, signature string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(a.PublicKey, urlSigningMessage(subject, expires), sig)
+}
+
+func urlSigningMessage(subject string, expires int64) []byte {
+	return []byte(fmt.Sprintf("url:%s:%d", subject, expires))
+}
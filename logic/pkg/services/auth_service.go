@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"pdm-logic-server/pkg/cache/keys"
 	"pdm-logic-server/pkg/models"
 	"strconv"
 	"time"
@@ -107,8 +108,8 @@ func ValidateUser(S *Storage, ctx context.Context, email, password string) (stri
 		if err != nil {
 			log.Printf("Failed to marshal userInfo: %v", err)
 		} else {
-			key := fmt.Sprintf("user:%s:userinfo", user.ID)
-			err = S.Ch.Set(ctx, key, string(jsonData), 24*time.Hour)
+			key := S.Ch.Schema.UserInfo(user.ID)
+			err = S.Ch.Set(ctx, key, string(jsonData), S.Ch.Schema.TTL(keys.UserInfo))
 			if err != nil {
 				log.Printf("Failed to cache userInfo: %v", err)
 			}
@@ -119,40 +120,51 @@ func ValidateUser(S *Storage, ctx context.Context, email, password string) (stri
 }
 
 func GetUserInfo(S *Storage, ctx context.Context, userID string) (*models.UserInfo, error) {
-	var userInfo models.UserInfo
-
-	// Try to get from cache first
-	key := fmt.Sprintf("user:%s:userinfo", userID)
-	jsonData, err := S.Ch.Get(ctx, key)
-	if err == nil {
+	key := S.Ch.Schema.UserInfo(userID)
+	cached := func() (models.UserInfo, bool) {
+		var userInfo models.UserInfo
+		jsonData, err := S.Ch.Get(ctx, key)
+		if err != nil || jsonData == "" {
+			return userInfo, false
+		}
 		// Cache hit - need to deserialize
-		err = json.Unmarshal([]byte(jsonData), &userInfo)
-		if err == nil {
-			return &userInfo, nil
+		if err := json.Unmarshal([]byte(jsonData), &userInfo); err != nil {
+			// If unmarshal fails, log and continue to DB
+			log.Printf("Failed to unmarshal cached userInfo: %v", err)
+			return userInfo, false
 		}
-		// If unmarshal fails, log and continue to DB
-		log.Printf("Failed to unmarshal cached userInfo: %v", err)
+		return userInfo, true
 	}
 
-	// Cache miss or unmarshal error, get from DB
-	err = S.DB.Model(&models.User{}).
-		Select("id", "name", "creation", "product", "email", "registered").
-		Where("id = ?", userID).
-		First(&userInfo).Error
-	if err != nil {
-		return nil, err
+	// Try to get from cache first
+	if userInfo, ok := cached(); ok {
+		return &userInfo, nil
 	}
 
-	// Cache the result for next time
-	bytes, err := json.Marshal(userInfo)
-	jsonData = string(bytes)
-	if err == nil {
-		err = S.Ch.Set(ctx, key, jsonData, 24*time.Hour)
+	// Cache miss or unmarshal error, get from DB, once for all concurrent misses
+	userInfo, err := loadShared(ctx, S, key, cached, func() (models.UserInfo, error) {
+		var userInfo models.UserInfo
+		err := S.DB.Model(&models.User{}).
+			Select("id", "name", "creation", "product", "email", "registered").
+			Where("id = ?", userID).
+			First(&userInfo).Error
 		if err != nil {
-			log.Printf("Failed to cache userInfo: %v", err)
+			return userInfo, err
 		}
-	}
 
+		// Cache the result for next time
+		bytes, err := json.Marshal(userInfo)
+		if err == nil {
+			err = S.Ch.Set(ctx, key, string(bytes), S.Ch.Schema.TTL(keys.UserInfo))
+			if err != nil {
+				log.Printf("Failed to cache userInfo: %v", err)
+			}
+		}
+		return userInfo, nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return &userInfo, nil
 }
 
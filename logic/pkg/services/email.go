@@ -13,24 +13,76 @@ import (
 )
 
 func SendEmail(from, to, subject, body, verificationCode, apiKey string) error {
-	url := "https://send.api.mailtrap.io/api/send"
+	data := models.EmailVerificationTemplateData{
+		Code: verificationCode,
+	}
 
-	tmpl, err := template.New("verification").Parse(templates.EmailTemplate)
+	html, err := renderEmail("verification", templates.EmailTemplate, data)
 	if err != nil {
 		return err
 	}
 
-	var htmlBuffer bytes.Buffer
-	data := models.EmailVerificationTemplateData{
-		Code: verificationCode,
+	emailData := newEmailCall(from, to, subject, "Verification")
+	emailData.Html = html
+	emailData.Text = fmt.Sprintf("Your PDM Notes verification code is: %s", data.Code)
+	emailData.Category = "PDM Notes Email Verification"
+
+	return sendEmailCall(emailData, apiKey)
+}
+
+// SendExportReadyEmail tells a user where to download a finished account export
+func SendExportReadyEmail(from, to string, data models.ExportReadyTemplateData, apiKey string) error {
+	html, err := renderEmail("export", templates.ExportReadyEmailTemplate, data)
+	if err != nil {
+		return err
 	}
 
-	err = tmpl.Execute(&htmlBuffer, data)
+	emailData := newEmailCall(from, to, "Your PDM Notes export is ready", "Export")
+	emailData.Html = html
+	emailData.Text = fmt.Sprintf("Your PDM Notes export is ready. Download it before %s: %s",
+		data.ExpiresAt.Format("Jan 2, 2006 15:04 MST"), data.DownloadURL)
+	emailData.Category = "PDM Notes Account Export"
+
+	return sendEmailCall(emailData, apiKey)
+}
+
+// SendReminderEmail notifies a user of a due note reminder
+func SendReminderEmail(from, to string, data models.ReminderTemplateData, apiKey string) error {
+	html, err := renderEmail("reminder", templates.ReminderEmailTemplate, data)
 	if err != nil {
 		return err
 	}
 
-	emailData := models.EmailCall{
+	heading := data.Heading
+	if heading == "" {
+		heading = "Untitled note"
+	}
+
+	emailData := newEmailCall(from, to, fmt.Sprintf("Reminder: %s", heading), "Reminder")
+	emailData.Html = html
+	emailData.Text = fmt.Sprintf("Reminder for your note %q, due %s.", heading,
+		data.FireAt.Format("Mon, Jan 2, 2006 15:04 MST"))
+	emailData.Category = "PDM Notes Reminder"
+
+	return sendEmailCall(emailData, apiKey)
+}
+
+func renderEmail(name, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var htmlBuffer bytes.Buffer
+	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
+		return "", err
+	}
+
+	return htmlBuffer.String(), nil
+}
+
+func newEmailCall(from, to, subject, emailType string) models.EmailCall {
+	return models.EmailCall{
 		To: []models.EmailAddress{
 			{Email: to, Name: ""},
 		},
@@ -38,10 +90,7 @@ func SendEmail(from, to, subject, body, verificationCode, apiKey string) error {
 			Email: from,
 			Name:  "PDM Notes",
 		},
-		Subject:  subject,
-		Html:     htmlBuffer.String(),
-		Text:     fmt.Sprintf("Your PDM Notes verification code is: %s", data.Code),
-		Category: "PDM Notes Email Verification",
+		Subject: subject,
 		// Add these headers to improve deliverability
 		Headers: &models.Headers{
 			XMessageSource:        "pdm.pw", // Your domain
@@ -52,9 +101,13 @@ func SendEmail(from, to, subject, body, verificationCode, apiKey string) error {
 		},
 		CustomVariables: &models.CustomVariables{
 			App:       "PDM Notes",
-			EmailType: "Verification",
+			EmailType: emailType,
 		},
 	}
+}
+
+func sendEmailCall(emailData models.EmailCall, apiKey string) error {
+	url := "https://send.api.mailtrap.io/api/send"
 
 	jsonData, err := json.Marshal(emailData)
 	if err != nil {
@@ -62,8 +115,6 @@ func SendEmail(from, to, subject, body, verificationCode, apiKey string) error {
 		return err
 	}
 
-	fmt.Println(string(jsonData))
-
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
@@ -73,22 +124,20 @@ func SendEmail(from, to, subject, body, verificationCode, apiKey string) error {
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Api-Token", apiKey)
 
-	//fmt.Printf("api key: %s\n ", apiKey)
-
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
-	// Change this line to use a different variable name
 	responseBody, err := io.ReadAll(res.Body)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(res)
-	fmt.Println(string(responseBody)) // Convert byte slice to string for printing
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("email api returned %s: %s", res.Status, responseBody)
+	}
 
 	return nil
 }
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/models"
+	"strconv"
+)
+
+// notesVersionSeedAttempts bounds how often a missing notes version is
+// seeded before a bump gives up
+const notesVersionSeedAttempts = 3
+
+// NoteETag is the strong ETag of a single note. It only depends on what the
+// note holds, its digests and whether it is in the trash, so clients can
+// work it out from the listing too.
+func NoteETag(note models.Notes) string {
+	return `"note-` + note.H + "." + note.Intgrh + "." + strconv.Itoa(note.Deleted) + `"`
+}
+
+// notesDigest derives a notes version from the user's notes alone, so it
+// comes out the same whenever the cache is rebuilt from the same notes. The
+// notes are digested one at a time and combined regardless of order. The
+// digest is cut to 48 bits, leaving room for the writes that count it up.
+func (s *Storage) notesDigest(ctx context.Context, userID string) (int64, error) {
+	var combined [sha256.Size]byte
+	count := 0
+	err := s.StreamNotes(ctx, userID, func(note models.Notes) error {
+		sum := sha256.Sum256([]byte(note.NoteID + "\x00" + NoteETag(note)))
+		for i := range combined {
+			combined[i] ^= sum[i]
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(append([]byte(strconv.Itoa(count)+":"), combined[:]...))
+	return int64(binary.BigEndian.Uint64(sum[:8]) >> 16), nil
+}
+
+// seedNotesVersion sets the user's notes version to the digest of the notes
+// unless another instance set a version first
+func (s *Storage) seedNotesVersion(ctx context.Context, userID string) error {
+	digest, err := s.notesDigest(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.Ch.SetNX(ctx, s.Ch.Schema.NotesVersion(userID), strconv.FormatInt(digest, 10), s.Ch.Schema.TTL(keys.NotesVersion))
+	return err
+}
+
+// NotesVersion returns a token that changes on every write to the user's
+// notes, for use in ETags. Callers must read it before the notes themselves:
+// writers bump it only after the cache holds the new data, so a response can
+// be tagged with an older version but never with a newer one. A missing
+// version is derived from the notes.
+func (s *Storage) NotesVersion(ctx context.Context, userID string) (string, error) {
+	key := s.Ch.Schema.NotesVersion(userID)
+	version, err := s.Ch.Get(ctx, key)
+	if err != nil || version != "" {
+		return version, err
+	}
+
+	if err := s.seedNotesVersion(ctx, userID); err != nil {
+		return "", err
+	}
+	return s.Ch.Get(ctx, key)
+}
+
+// bumpNotesVersion gives the user's notes a new version after a write. The
+// version is a counter moved on with one INCR, so concurrent writers always
+// end up with versions of their own. A missing version is seeded from the
+// notes first; deleting it instead could let a read that started before the
+// write store a version of the notes without it.
+func (s *Storage) bumpNotesVersion(ctx context.Context, userID string) {
+	key := s.Ch.Schema.NotesVersion(userID)
+	_, ok, err := s.Ch.IncrByIfExists(ctx, key, 1)
+	for attempt := 0; !ok && err == nil && attempt < notesVersionSeedAttempts; attempt++ {
+		// The seed may come from a read that started before the write, so
+		// it is counted up too
+		if err = s.seedNotesVersion(ctx, userID); err == nil {
+			_, ok, err = s.Ch.IncrByIfExists(ctx, key, 1)
+		}
+	}
+	if err == nil && ok {
+		return
+	}
+	if err == nil {
+		err = errors.New("version keeps disappearing")
+	}
+
+	log.Printf("Failed to bump notes version of user %s: %v", userID, err)
+	// Without a version the next read derives one from the notes
+	if err := s.Ch.Delete(ctx, key); err != nil {
+		log.Printf("Failed to reset notes version of user %s: %v", userID, err)
+	}
+}
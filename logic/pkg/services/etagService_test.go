@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNoteETag(t *testing.T) {
+	tests := []struct {
+		name string
+		note models.Notes
+		want string
+	}{
+		{name: "live", note: models.Notes{H: "v1:abc", Intgrh: "v1:def", Deleted: 1}, want: `"note-v1:abc.v1:def.1"`},
+		{name: "in the trash", note: models.Notes{H: "v1:abc", Intgrh: "v1:def", Deleted: 0}, want: `"note-v1:abc.v1:def.0"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NoteETag(tt.note); got != tt.want {
+				t.Errorf("NoteETag() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBumpNotesVersionConcurrently(t *testing.T) {
+	ctx := context.Background()
+	s := &Storage{Ch: cache.NewCache(cache.NewMemoryClient(), keys.NewSchema(&config.CacheKeysConfig{Version: 1, NotesVersionTTL: time.Hour}))}
+	if err := s.Ch.Set(ctx, s.Ch.Schema.NotesVersion("u1"), "1000", time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// Every writer must move the version on; two that read the same version
+	// and derived the next one from it would leave it short
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.bumpNotesVersion(ctx, "u1")
+		}()
+	}
+	wg.Wait()
+
+	version, err := s.NotesVersion(ctx, "u1")
+	if err != nil {
+		t.Fatalf("NotesVersion() error = %v", err)
+	}
+	if version != "1050" {
+		t.Errorf("NotesVersion() = %s after %d bumps of 1000, want 1050", version, writers)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"pdm-logic-server/pkg/blob"
+	"pdm-logic-server/pkg/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExportRunning  = errors.New("an export is already running")
+	ErrExportNotFound = errors.New("export not found")
+)
+
+const (
+	exportBatchSize = 200
+	// exportLockTTL bounds how long a crashed export blocks the next one
+	exportLockTTL = time.Hour
+)
+
+func exportKey(userID, jobID string) string {
+	return fmt.Sprintf("exports/%s/%s.zip", userID, jobID)
+}
+
+// CountNotes returns the number of notes owned by userID, deleted ones included
+func (s *Storage) CountNotes(ctx context.Context, userID string) (int, error) {
+	var count int64
+	err := s.DB.WithContext(ctx).Model(&models.Notes{}).Where("userid = ?", userID).Count(&count).Error
+	return int(count), err
+}
+
+// WriteExport writes a zip archive of everything stored for userID to w:
+// manifest.json, profile.json, sessions.json and every note both as
+// notes/<id>.json and as Markdown with front-matter. Notes are read straight
+// from the database in batches so memory use does not grow with the account.
+func (s *Storage) WriteExport(ctx context.Context, userID string, w io.Writer, progress func(done, total int)) (int, error) {
+	total, err := s.CountNotes(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	profile, err := GetUserInfo(s, ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var sessions []models.ExportSession
+	err = s.DB.WithContext(ctx).Model(&models.SessionKey{}).
+		Select("id", "creation_time", "expiration_time", "valid").
+		Where("userid = ?", userID).
+		Order("creation_time").
+		Scan(&sessions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	zw := zip.NewWriter(w)
+
+	manifest := models.ExportManifest{
+		Version:    models.ExportFormatVersion,
+		UserID:     userID,
+		ExportedAt: time.Now().UTC(),
+		NoteCount:  total,
+	}
+	if err := writeZipJSON(zw, "manifest.json", manifest); err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "profile.json", profile); err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "sessions.json", sessions); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	var notes []models.Notes
+	result := s.DB.WithContext(ctx).Model(&models.Notes{}).
+		Select("noteid", "userid", "heading", "time", "h", "update_time", "intgrh", "content", "deleted").
+		Where("userid = ?", userID).
+		Order("noteid").
+		FindInBatches(&notes, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, note := range notes {
+				if err := writeZipJSON(zw, fmt.Sprintf("notes/%s.json", note.NoteID), note); err != nil {
+					return err
+				}
+				if err := writeZipFile(zw, markdownFileName(note), note.UpdateTime, []byte(noteMarkdown(note))); err != nil {
+					return err
+				}
+				done++
+				if progress != nil {
+					progress(done, total)
+				}
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return done, result.Error
+	}
+
+	return done, zw.Close()
+}
+
+// StartExportJob builds the export of userID in the background and stores it
+// in the blob store. Earlier exports of the user are removed first; only one
+// export per user may run at a time.
+func (s *Storage) StartExportJob(ctx context.Context, userID string, onFinish func(models.Job)) (models.Job, error) {
+	lockKey := s.Ch.Schema.ExportRunning(userID)
+	locked, err := s.Ch.SetNX(ctx, lockKey, "1", exportLockTTL)
+	if err != nil {
+		return models.Job{}, err
+	}
+	if !locked {
+		return models.Job{}, ErrExportRunning
+	}
+
+	job, err := s.StartJob(ctx, userID, models.JobTypeExport, func(ctx context.Context, job models.Job, progress func(done, total int)) (map[string]string, error) {
+		defer func() {
+			if err := s.Ch.Delete(ctx, lockKey); err != nil {
+				log.Printf("Failed to release export lock of user %s: %v", userID, err)
+			}
+		}()
+		return s.buildExport(ctx, userID, job.ID, progress)
+	}, onFinish)
+	if err != nil {
+		if err := s.Ch.Delete(ctx, lockKey); err != nil {
+			log.Printf("Failed to release export lock of user %s: %v", userID, err)
+		}
+	}
+	return job, err
+}
+
+func (s *Storage) buildExport(ctx context.Context, userID, jobID string, progress func(done, total int)) (map[string]string, error) {
+	old, err := s.Blob.List(ctx, fmt.Sprintf("exports/%s/", userID))
+	if err != nil {
+		return nil, err
+	}
+	s.deleteBlobs(ctx, old)
+
+	// The blob store needs the size up front, so spool the archive to disk
+	tmp, err := os.CreateTemp("", "pdm-export-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	count, err := s.WriteExport(ctx, userID, tmp, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err := s.Blob.Put(ctx, exportKey(userID, jobID), tmp, size, "application/zip"); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"noteCount": strconv.Itoa(count),
+		"size":      strconv.FormatInt(size, 10),
+	}, nil
+}
+
+// OpenExport opens the archive of a completed export job. It does no
+// ownership checks; callers must have verified a signed download URL.
+func (s *Storage) OpenExport(ctx context.Context, jobID string) (models.Job, io.ReadCloser, error) {
+	var job models.Job
+	if err := s.Ch.GetJSON(ctx, s.Ch.Schema.Job(jobID), &job); err != nil {
+		return job, nil, err
+	}
+	if job.ID == "" || job.Type != models.JobTypeExport || job.Status != models.JobCompleted {
+		return job, nil, ErrExportNotFound
+	}
+
+	content, err := s.Blob.Get(ctx, exportKey(job.UserID, job.ID))
+	if errors.Is(err, blob.ErrNotFound) {
+		return job, nil, ErrExportNotFound
+	}
+	return job, content, err
+}
+
+func writeZipJSON(zw *zip.Writer, name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeZipFile(zw, name, time.Now(), data)
+}
+
+func writeZipFile(zw *zip.Writer, name string, modified time.Time, data []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^\p{L}\p{N}._ -]+`)
+
+// markdownFileName names a note after its heading, keeping the id so that
+// notes with the same heading do not collide.
+func markdownFileName(note models.Notes) string {
+	name := strings.TrimSpace(unsafeFileChars.ReplaceAllString(note.Heading, ""))
+	if runes := []rune(name); len(runes) > 60 {
+		name = strings.TrimSpace(string(runes[:60]))
+	}
+	if name == "" {
+		name = "Untitled"
+	}
+	return fmt.Sprintf("notes/%s-%s.md", name, note.NoteID)
+}
+
+// noteMarkdown renders a note as Markdown with YAML front-matter. Strings are
+// written as JSON, which is valid YAML and needs no further escaping.
+func noteMarkdown(note models.Notes) string {
+	heading, _ := json.Marshal(note.Heading)
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %s\n", note.NoteID)
+	fmt.Fprintf(&b, "heading: %s\n", heading)
+	fmt.Fprintf(&b, "created: %s\n", note.Time.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated: %s\n", note.UpdateTime.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "deleted: %t\n", note.Deleted != 0)
+	b.WriteString("---\n\n")
+	b.WriteString(note.Content)
+	if !strings.HasSuffix(note.Content, "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/importer"
+	"pdm-logic-server/pkg/models"
+	"pdm-shared/integrity"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const importBatchSize = 100
+
+// StartImportJob imports the upload spooled at path in the background. The
+// job owns the file and removes it when done.
+func (s *Storage) StartImportJob(ctx context.Context, userID, path, name string, format importer.Format, importLimits importer.Limits, quota *config.QuotaConfig) (models.Job, error) {
+	job, err := s.StartJob(ctx, userID, models.JobTypeImport, func(ctx context.Context, job models.Job, progress func(done, total int)) (map[string]string, error) {
+		defer os.Remove(path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		notes, err := importer.Parse(format, importer.Upload{Name: name, R: f, Size: info.Size()}, importLimits)
+		if err != nil {
+			return nil, err
+		}
+
+		_, limits, err := s.QuotaLimits(ctx, userID, quota)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.CreateNotes(ctx, userID, notes, limits, progress); err != nil {
+			return nil, err
+		}
+
+		return map[string]string{
+			"format":   string(format),
+			"imported": strconv.Itoa(len(notes)),
+		}, nil
+	}, nil)
+	if err != nil {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove import upload %s: %v", path, err)
+		}
+	}
+	return job, err
+}
+
+// CreateNotes inserts notes for userID in a single transaction, keeping
+// their Time and UpdateTime. Every note gets a new ID and fresh digests.
+// Nothing is inserted unless all notes fit the user's quota.
+func (s *Storage) CreateNotes(ctx context.Context, userID string, notes []models.Notes, limits config.QuotaLimits, progress func(done, total int)) error {
+	writes := make([]noteWrite, len(notes))
+	for i, note := range notes {
+		writes[i] = noteWrite{size: NoteSize(note.Heading, note.Content), create: true}
+	}
+	if err := s.checkQuota(ctx, userID, writes, limits); err != nil {
+		return err
+	}
+
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(notes); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(notes) {
+				end = len(notes)
+			}
+
+			batch := notes[start:end]
+			for i := range batch {
+				batch[i].NoteID = ""
+				batch[i].UserID = userID
+				batch[i].H, batch[i].Intgrh = integrity.Compute(batch[i].Heading, batch[i].Content)
+			}
+			if err := tx.Create(&batch).Error; err != nil {
+				return err
+			}
+
+			if progress != nil {
+				progress(end, len(notes))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range notes {
+		writes[i].noteID = notes[i].NoteID
+	}
+	s.recordNoteWrites(ctx, userID, writes)
+	s.unmarkNotesLoaded(ctx, userID)
+	s.bumpNotesVersion(ctx, userID)
+
+	return nil
+}
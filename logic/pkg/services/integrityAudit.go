@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"pdm-logic-server/pkg/blob"
+	"pdm-logic-server/pkg/models"
+	"pdm-shared/integrity"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAuditRunning  = errors.New("an integrity audit is already running")
+	ErrAuditNotFound = errors.New("integrity audit report not found")
+)
+
+const (
+	auditLockTTL   = 6 * time.Hour
+	auditBatchSize = 500
+	// auditMaxRows caps the rows kept in a report; the counts stay exact
+	auditMaxRows = 10000
+)
+
+func auditReportKey(jobID string) string {
+	return fmt.Sprintf("audits/integrity/%s.json", jobID)
+}
+
+// StartIntegrityAudit scans every stored note in the background and writes a
+// report of the notes whose h or intgrh no longer match their content and
+// heading. Only one audit runs at a time.
+func (s *Storage) StartIntegrityAudit(ctx context.Context) (models.Job, error) {
+	locked, err := s.Ch.SetNX(ctx, s.Ch.Schema.AuditRunning(), "1", auditLockTTL)
+	if err != nil {
+		return models.Job{}, err
+	}
+	if !locked {
+		return models.Job{}, ErrAuditRunning
+	}
+
+	job, err := s.StartJob(ctx, models.AdminJobOwner, models.JobTypeAudit, func(ctx context.Context, job models.Job, progress func(done, total int)) (map[string]string, error) {
+		defer func() {
+			if err := s.Ch.Delete(ctx, s.Ch.Schema.AuditRunning()); err != nil {
+				log.Printf("Failed to release integrity audit lock: %v", err)
+			}
+		}()
+		return s.runIntegrityAudit(ctx, job.ID, progress)
+	}, nil)
+	if err != nil {
+		if err := s.Ch.Delete(ctx, s.Ch.Schema.AuditRunning()); err != nil {
+			log.Printf("Failed to release integrity audit lock: %v", err)
+		}
+	}
+	return job, err
+}
+
+func (s *Storage) runIntegrityAudit(ctx context.Context, jobID string, progress func(done, total int)) (map[string]string, error) {
+	var total int64
+	if err := s.DB.WithContext(ctx).Model(&models.Notes{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	report := models.IntegrityReport{
+		JobID:     jobID,
+		StartedAt: time.Now().UTC(),
+		Rows:      []models.IntegrityMismatch{},
+	}
+
+	var notes []models.Notes
+	result := s.DB.WithContext(ctx).Model(&models.Notes{}).
+		Select("noteid", "userid", "heading", "content", "h", "intgrh", "update_time").
+		Order("noteid").
+		FindInBatches(&notes, auditBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, note := range notes {
+				report.Scanned++
+
+				err := integrity.Verify(note.Heading, note.Content, note.H, note.Intgrh)
+				switch {
+				case err == nil:
+					report.Valid++
+				case errors.Is(err, integrity.ErrLegacy):
+					report.Legacy++
+				default:
+					report.Mismatched++
+					if len(report.Rows) < auditMaxRows {
+						report.Rows = append(report.Rows, models.IntegrityMismatch{
+							NoteID:     note.NoteID,
+							UserID:     note.UserID,
+							Reason:     err.Error(),
+							UpdateTime: note.UpdateTime,
+						})
+					} else {
+						report.Truncated = true
+					}
+				}
+
+				progress(report.Scanned, int(total))
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Blob.Put(ctx, auditReportKey(jobID), bytes.NewReader(data), int64(len(data)), "application/json"); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Integrity audit %s: %d scanned, %d valid, %d legacy, %d mismatched",
+		jobID, report.Scanned, report.Valid, report.Legacy, report.Mismatched)
+
+	return map[string]string{
+		"scanned":    strconv.Itoa(report.Scanned),
+		"valid":      strconv.Itoa(report.Valid),
+		"legacy":     strconv.Itoa(report.Legacy),
+		"mismatched": strconv.Itoa(report.Mismatched),
+	}, nil
+}
+
+// OpenIntegrityReport opens the JSON report of a finished audit
+func (s *Storage) OpenIntegrityReport(ctx context.Context, jobID string) (io.ReadCloser, error) {
+	job, err := s.GetJob(ctx, models.AdminJobOwner, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Type != models.JobTypeAudit || job.Status != models.JobCompleted {
+		return nil, ErrAuditNotFound
+	}
+
+	content, err := s.Blob.Get(ctx, auditReportKey(jobID))
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, ErrAuditNotFound
+	}
+	return content, err
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"pdm-logic-server/pkg/importer"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/util"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+const (
+	// jobTTL is how long a job stays visible after its last update
+	jobTTL = 24 * time.Hour
+	// jobProgressEvery limits how often progress is written to Redis
+	jobProgressEvery = 50
+)
+
+// reportedJobErrors are the failures a job reports to its user as they are;
+// any other error is reported as "internal error" and only logged
+var reportedJobErrors = []error{
+	importer.ErrUnknownFormat,
+	importer.ErrTooManyNotes,
+	importer.ErrNoNotes,
+	importer.ErrNoteTooLarge,
+	importer.ErrArchiveTooLarge,
+	ErrNoteLimit,
+	ErrNoteTooLarge,
+	ErrQuotaExceeded,
+}
+
+func jobError(err error) string {
+	for _, target := range reportedJobErrors {
+		if errors.Is(err, target) {
+			return target.Error()
+		}
+	}
+	return "internal error"
+}
+
+// JobFunc does the work of a job. It calls progress as it goes and returns
+// the values stored in Job.Result.
+type JobFunc func(ctx context.Context, job models.Job, progress func(done, total int)) (map[string]string, error)
+
+func (s *Storage) saveJob(ctx context.Context, job *models.Job) error {
+	job.UpdatedAt = time.Now().UTC()
+	return s.Ch.SetJSON(ctx, s.Ch.Schema.Job(job.ID), job, jobTTL)
+}
+
+// GetJob returns a job of userID. Jobs of other users are reported as missing.
+func (s *Storage) GetJob(ctx context.Context, userID, jobID string) (models.Job, error) {
+	var job models.Job
+	if err := s.Ch.GetJSON(ctx, s.Ch.Schema.Job(jobID), &job); err != nil {
+		return job, err
+	}
+	if job.ID == "" || job.UserID != userID {
+		return models.Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// StartJob records a queued job and runs fn in the background. onFinish, if
+// set, is called with the final state of the job.
+func (s *Storage) StartJob(ctx context.Context, userID, jobType string, fn JobFunc, onFinish func(models.Job)) (models.Job, error) {
+	job := models.Job{
+		ID:        util.NewUUID(),
+		UserID:    userID,
+		Type:      jobType,
+		Status:    models.JobQueued,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.saveJob(ctx, &job); err != nil {
+		return job, err
+	}
+
+	go s.runJob(job, fn, onFinish)
+
+	return job, nil
+}
+
+func (s *Storage) runJob(job models.Job, fn JobFunc, onFinish func(models.Job)) {
+	ctx := context.Background()
+
+	defer func() {
+		if r := recover(); r != nil {
+			job.Status = models.JobFailed
+			job.Error = "internal error"
+			log.Printf("Job %s (%s) panicked: %v", job.ID, job.Type, r)
+			if err := s.saveJob(ctx, &job); err != nil {
+				log.Printf("Failed to save job %s: %v", job.ID, err)
+			}
+		}
+	}()
+
+	job.Status = models.JobRunning
+	if err := s.saveJob(ctx, &job); err != nil {
+		log.Printf("Failed to save job %s: %v", job.ID, err)
+	}
+
+	progress := func(done, total int) {
+		job.Done, job.Total = done, total
+		if done%jobProgressEvery != 0 && done != total {
+			return
+		}
+		if err := s.saveJob(ctx, &job); err != nil {
+			log.Printf("Failed to save progress of job %s: %v", job.ID, err)
+		}
+	}
+
+	result, err := fn(ctx, job, progress)
+	if err != nil {
+		log.Printf("Job %s (%s) failed: %v", job.ID, job.Type, err)
+		job.Status = models.JobFailed
+		job.Error = jobError(err)
+	} else {
+		job.Status = models.JobCompleted
+		job.Result = result
+	}
+
+	if err := s.saveJob(ctx, &job); err != nil {
+		log.Printf("Failed to save job %s: %v", job.ID, err)
+	}
+
+	if onFinish != nil {
+		onFinish(job)
+	}
+}
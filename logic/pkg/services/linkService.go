@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"pdm-logic-server/pkg/models"
+)
+
+// GetBacklinks lists the notes linking to noteID that userID can read:
+// their own and those shared with them. Deleted notes are left out.
+func (s *Storage) GetBacklinks(ctx context.Context, userID, noteID string) ([]models.Backlink, error) {
+	access, err := s.GetNoteAccess(ctx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.CanRead() {
+		return nil, ErrNoteNotFound
+	}
+
+	backlinks := []models.Backlink{}
+	err = s.DB.WithContext(ctx).Table("note_link").
+		Select("notes.noteid, notes.heading, notes.update_time, note_link.origin").
+		Joins("JOIN notes ON notes.noteid = note_link.source_id").
+		Where("note_link.target_id = ? AND notes.deleted = 0", noteID).
+		Where("notes.userid = ? OR EXISTS (SELECT 1 FROM note_share WHERE note_share.noteid = notes.noteid AND note_share.shared_with_id = ?)", userID, userID).
+		Order("notes.update_time DESC").
+		Scan(&backlinks).Error
+	return backlinks, err
+}
+
+// GetNoteGraph returns the user's notes and the links between them. Links to
+// notes the user does not own, or to deleted ones, are left out.
+func (s *Storage) GetNoteGraph(ctx context.Context, userID string) (models.NoteGraph, error) {
+	graph := models.NoteGraph{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}
+
+	err := s.DB.WithContext(ctx).Model(&models.Notes{}).
+		Select("noteid, heading").
+		Where("userid = ? AND deleted = 0", userID).
+		Order("time").
+		Scan(&graph.Nodes).Error
+	if err != nil {
+		return graph, err
+	}
+
+	err = s.DB.WithContext(ctx).Table("note_link").
+		Select("note_link.source_id AS source, note_link.target_id AS target, note_link.origin").
+		Joins("JOIN notes src ON src.noteid = note_link.source_id").
+		Joins("JOIN notes dst ON dst.noteid = note_link.target_id").
+		Where("note_link.userid = ? AND src.deleted = 0 AND dst.userid = ? AND dst.deleted = 0", userID, userID).
+		Scan(&graph.Edges).Error
+	return graph, err
+}
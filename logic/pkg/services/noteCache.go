@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/models"
+	"sort"
+	"time"
+)
+
+// Cached notes live at user:<id>:note:<noteid> for the notes TTL, see
+// cache/keys; pending ones for the much longer pending TTL, until a load from
+// the database finds their write there or the sync server rejects it. Each
+// user also has an index, a set of the IDs of their cached notes, so reads
+// never have to scan the keyspace for a user's notes.
+//
+// The cache is written behind: note writes are queued for the sync server
+// and cached straight away, before they reach the database. Each entry
+// carries the version of the note it holds, its update time in seconds, the
+// resolution the database keeps. Reads merge the cache with the database by
+// note ID and the newer version wins, the cache on a tie, so a queued write
+// is never hidden by the row it is about to replace. Pending entries are
+// writes that may not be in the database yet; a note only in the cache is
+// listed when its entry is pending. Entries filled from the database never
+// overwrite an existing entry.
+//
+// Once all of a user's notes have been loaded into the cache, a marker at
+// user:<id>:notes:loaded says so and the notes are listed from the cache
+// alone until it expires. An entry that expires before the marker leaves a
+// gap in the index, and the marker is dropped when that is noticed. Loads
+// run through loadShared, and hot users have theirs refreshed early.
+
+// notesLoad is the marker of a complete load of a user's notes
+type notesLoad struct {
+	At   time.Time     `json:"at"`
+	Took time.Duration `json:"took"`
+}
+
+// cachedNote is a cache entry for a note
+type cachedNote struct {
+	Version int64 `json:"version"`
+	Pending bool  `json:"pending,omitempty"`
+	// Removed marks a permanent delete that is still queued
+	Removed bool         `json:"removed,omitempty"`
+	Note    models.Notes `json:"note"`
+}
+
+func noteVersion(t time.Time) int64 {
+	return t.Unix()
+}
+
+// supersedes reports whether the entry is at least as new as the stored note
+func (e cachedNote) supersedes(stored models.Notes) bool {
+	return e.Version >= noteVersion(stored.UpdateTime)
+}
+
+// settledBy reports whether stored, as just read from the database, may
+// replace the entry: it is newer, or it is the very write the entry holds
+func (e cachedNote) settledBy(stored models.Notes) bool {
+	if e.Removed {
+		return false
+	}
+	version := noteVersion(stored.UpdateTime)
+	if version != e.Version || !e.Pending {
+		return version >= e.Version
+	}
+	// A tie may be another write within the same second
+	return stored.Heading == e.Note.Heading && stored.Content == e.Note.Content && stored.Deleted == e.Note.Deleted
+}
+
+// mergeNotes merges notes read from the database with the user's cache
+// entries, keeping the database order. Pending notes that are not in the
+// database yet come last.
+func mergeNotes(stored []models.Notes, cached map[string]cachedNote) []models.Notes {
+	notes := make([]models.Notes, 0, len(stored)+len(cached))
+	seen := make(map[string]bool, len(stored))
+	for _, note := range stored {
+		seen[note.NoteID] = true
+		entry, ok := cached[note.NoteID]
+		if !ok || !entry.supersedes(note) {
+			notes = append(notes, note)
+			continue
+		}
+		if !entry.Removed {
+			notes = append(notes, entry.Note)
+		}
+	}
+
+	for id, entry := range cached {
+		if !seen[id] && entry.Pending && !entry.Removed {
+			notes = append(notes, entry.Note)
+		}
+	}
+	return notes
+}
+
+// listNotes lists the notes of a complete set of cache entries, oldest first
+func listNotes(cached map[string]cachedNote) []models.Notes {
+	notes := make([]models.Notes, 0, len(cached))
+	for _, entry := range cached {
+		if !entry.Removed {
+			notes = append(notes, entry.Note)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		if !notes[i].Time.Equal(notes[j].Time) {
+			return notes[i].Time.Before(notes[j].Time)
+		}
+		return notes[i].NoteID < notes[j].NoteID
+	})
+	return notes
+}
+
+// writeNoteEntries caches entries of one user and adds them to the user's
+// index. The index expiry is pushed out with every write, so it outlives the
+// notes it lists; entries for notes that expired on their own are pruned on
+// read. Fills only take keys that are free.
+func (s *Storage) writeNoteEntries(ctx context.Context, userID string, entries []cachedNote, fill bool) {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ttl := s.noteEntryTTL(entry)
+		bytes, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Failed to marshal note: %v", err)
+			continue
+		}
+
+		key := s.Ch.Schema.Note(userID, entry.Note.NoteID)
+		if fill {
+			_, err = s.Ch.SetNX(ctx, key, string(bytes), ttl)
+		} else {
+			err = s.Ch.Set(ctx, key, string(bytes), ttl)
+		}
+		if err != nil {
+			log.Printf("Failed to cache note: %v", err)
+			continue
+		}
+		ids = append(ids, entry.Note.NoteID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	index := s.Ch.Schema.NoteIndex(userID)
+	if err := s.Ch.SAdd(ctx, index, ids...); err != nil {
+		log.Printf("Failed to index cached notes of user %s: %v", userID, err)
+		return
+	}
+	if err, _ := s.Ch.Expire(ctx, index, s.Ch.Schema.TTL(keys.NoteIndex)).(error); err != nil {
+		log.Printf("Failed to set expiry of note index of user %s: %v", userID, err)
+	}
+}
+
+// noteEntryTTL is how long an entry is kept: a pending write must outlive
+// any backlog of the sync server, or the row it replaces would be served
+func (s *Storage) noteEntryTTL(entry cachedNote) time.Duration {
+	if entry.Pending {
+		return s.Ch.Schema.TTL(keys.PendingNote)
+	}
+	return s.Ch.Schema.TTL(keys.Note)
+}
+
+// fillNoteCache caches notes as read from the database
+func (s *Storage) fillNoteCache(ctx context.Context, userID string, notes []models.Notes) {
+	entries := make([]cachedNote, len(notes))
+	for i, note := range notes {
+		entries[i] = cachedNote{Version: noteVersion(note.UpdateTime), Note: note}
+	}
+	s.writeNoteEntries(ctx, userID, entries, true)
+}
+
+// refillNoteCache caches a complete load of a user's notes, stored as just
+// read from the database and cached as read from the cache right after, so
+// every entry outlives the loaded marker written next. Settled entries are
+// replaced with the stored notes, and entries of notes that are gone are
+// dropped, and settled pending entries go back to the notes TTL. Entries
+// ahead of the database, pending writes mostly, are kept and get another TTL
+// while they are younger than that; older ones are left to expire, and the
+// gap they leave sends the next read back to the database.
+func (s *Storage) refillNoteCache(ctx context.Context, userID string, stored []models.Notes, cached map[string]cachedNote) {
+	var fresh, settled []cachedNote
+	seen := make(map[string]bool, len(stored))
+	for _, note := range stored {
+		seen[note.NoteID] = true
+		clean := cachedNote{Version: noteVersion(note.UpdateTime), Note: note}
+		entry, ok := cached[note.NoteID]
+		switch {
+		case !ok:
+			fresh = append(fresh, clean)
+		case entry.settledBy(note):
+			settled = append(settled, clean)
+		default:
+			s.extendNoteEntry(ctx, userID, entry)
+		}
+	}
+
+	var gone []string
+	for id, entry := range cached {
+		if seen[id] {
+			continue
+		}
+		if entry.Pending && !entry.Removed {
+			s.extendNoteEntry(ctx, userID, entry)
+		} else {
+			gone = append(gone, id)
+		}
+	}
+
+	s.writeNoteEntries(ctx, userID, fresh, true)
+	s.writeNoteEntries(ctx, userID, settled, false)
+	if len(gone) > 0 {
+		s.uncacheNotes(ctx, userID, gone...)
+	}
+}
+
+// extendNoteEntry gives an entry another TTL, unless its version is older
+// than that
+func (s *Storage) extendNoteEntry(ctx context.Context, userID string, entry cachedNote) {
+	ttl := s.noteEntryTTL(entry)
+	if time.Since(time.Unix(entry.Version, 0)) >= ttl {
+		return
+	}
+	if err, _ := s.Ch.Expire(ctx, s.Ch.Schema.Note(userID, entry.Note.NoteID), ttl).(error); err != nil {
+		log.Printf("Failed to extend cached note %s: %v", entry.Note.NoteID, err)
+	}
+}
+
+// markNotesLoaded records that the cache holds all of the user's notes. The
+// marker expires a notes TTL after the load started, before any entry it
+// wrote.
+func (s *Storage) markNotesLoaded(ctx context.Context, userID string, load notesLoad) {
+	remaining := s.Ch.Schema.TTL(keys.NotesLoaded) - time.Since(load.At)
+	if remaining <= 0 {
+		return
+	}
+	if err := s.Ch.SetJSON(ctx, s.Ch.Schema.NotesLoaded(userID), load, remaining); err != nil {
+		log.Printf("Failed to mark notes of user %s loaded: %v", userID, err)
+	}
+}
+
+// unmarkNotesLoaded sends the next listing of the user's notes to the
+// database, after notes were written to it without going through the cache
+func (s *Storage) unmarkNotesLoaded(ctx context.Context, userID string) {
+	if err := s.Ch.Delete(ctx, s.Ch.Schema.NotesLoaded(userID)); err != nil {
+		log.Printf("Failed to unmark notes of user %s loaded: %v", userID, err)
+	}
+}
+
+// cachedListing lists the user's notes from the cache, if it holds them all
+func (s *Storage) cachedListing(ctx context.Context, userID string) ([]models.Notes, notesLoad, bool) {
+	var load notesLoad
+	if err := s.Ch.GetJSON(ctx, s.Ch.Schema.NotesLoaded(userID), &load); err != nil {
+		log.Printf("Failed to read notes load of user %s: %v", userID, err)
+		return nil, load, false
+	}
+	if load.At.IsZero() {
+		return nil, load, false
+	}
+
+	cached, complete, err := s.cachedNotes(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to read cached notes: %v", err)
+		return nil, load, false
+	}
+	if !complete {
+		return nil, load, false
+	}
+	return listNotes(cached), load, true
+}
+
+// cacheNoteWrite caches a note write that was queued for the sync server
+func (s *Storage) cacheNoteWrite(ctx context.Context, userID string, note models.Notes) {
+	entry := cachedNote{Version: noteVersion(note.UpdateTime), Pending: true, Note: note}
+	s.writeNoteEntries(ctx, userID, []cachedNote{entry}, false)
+}
+
+// cacheNoteRemoval caches a queued permanent delete, so the note is gone
+// from reads before it is gone from the database
+func (s *Storage) cacheNoteRemoval(ctx context.Context, userID, noteID string, at time.Time) {
+	entry := cachedNote{
+		Version: noteVersion(at),
+		Pending: true,
+		Removed: true,
+		Note:    models.Notes{NoteID: noteID, UserID: userID},
+	}
+	s.writeNoteEntries(ctx, userID, []cachedNote{entry}, false)
+}
+
+// cacheNoteRestore caches a queued delete that is not permanent, which the
+// sync server applies by clearing the note's deleted flag
+func (s *Storage) cacheNoteRestore(ctx context.Context, userID, noteID string, at time.Time) {
+	entry, ok := s.cachedNote(ctx, userID, noteID)
+	if !ok || entry.Removed {
+		entry = cachedNote{}
+		if err := s.DB.WithContext(ctx).First(&entry.Note, "noteid = ?", noteID).Error; err != nil {
+			log.Printf("Failed to load note %s to cache its delete: %v", noteID, err)
+			s.uncacheNotes(ctx, userID, noteID)
+			return
+		}
+	}
+
+	entry.Version = noteVersion(at)
+	entry.Pending = true
+	entry.Removed = false
+	entry.Note.Deleted = 0
+	s.writeNoteEntries(ctx, userID, []cachedNote{entry}, false)
+}
+
+// uncacheNotes drops notes of one user from the cache and the user's index
+func (s *Storage) uncacheNotes(ctx context.Context, userID string, noteIDs ...string) {
+	for _, noteID := range noteIDs {
+		if err := s.Ch.Delete(ctx, s.Ch.Schema.Note(userID, noteID)); err != nil {
+			log.Printf("Failed to delete note from cache: %v", err)
+		}
+	}
+	if err := s.Ch.SRem(ctx, s.Ch.Schema.NoteIndex(userID), noteIDs...); err != nil {
+		log.Printf("Failed to unindex notes of user %s: %v", userID, err)
+	}
+}
+
+// cachedNote returns the cache entry for a note, if there is one
+func (s *Storage) cachedNote(ctx context.Context, userID, noteID string) (cachedNote, bool) {
+	var entry cachedNote
+	if err := s.Ch.GetJSON(ctx, s.Ch.Schema.Note(userID, noteID), &entry); err != nil {
+		log.Printf("Failed to read cached note %s: %v", noteID, err)
+		return entry, false
+	}
+	return entry, entry.Note.NoteID != ""
+}
+
+// cachedNotes returns the user's cache entries by note ID, read through the
+// user's index with one pipelined MGET, and whether every indexed entry was
+// still there. Entries that expired are pruned from the index, and the
+// user's notes are no longer marked loaded.
+func (s *Storage) cachedNotes(ctx context.Context, userID string) (map[string]cachedNote, bool, error) {
+	ids, err := s.Ch.SMembers(ctx, s.Ch.Schema.NoteIndex(userID))
+	if err != nil || len(ids) == 0 {
+		return nil, true, err
+	}
+	return s.noteEntries(ctx, userID, ids)
+}
+
+// noteEntries reads the cache entries of the given notes of the user as
+// cachedNotes does, pruning the ones that expired from the index
+func (s *Storage) noteEntries(ctx context.Context, userID string, ids []string) (map[string]cachedNote, bool, error) {
+	if len(ids) == 0 {
+		return nil, true, nil
+	}
+
+	noteKeys := make([]string, len(ids))
+	for i, id := range ids {
+		noteKeys[i] = s.Ch.Schema.Note(userID, id)
+	}
+	values, err := s.Ch.MGet(ctx, noteKeys...)
+	if err != nil {
+		return nil, false, err
+	}
+
+	entries := make(map[string]cachedNote, len(values))
+	var expired []string
+	for i, value := range values {
+		if value == "" {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var entry cachedNote
+		if err := json.Unmarshal([]byte(value), &entry); err != nil || entry.Note.NoteID != ids[i] {
+			log.Printf("Failed to unmarshal cached note %s: %v", ids[i], err)
+			continue
+		}
+		entries[ids[i]] = entry
+	}
+
+	if len(expired) > 0 {
+		s.unmarkNotesLoaded(ctx, userID)
+		if err := s.Ch.SRem(ctx, s.Ch.Schema.NoteIndex(userID), expired...); err != nil {
+			log.Printf("Failed to prune note index of user %s: %v", userID, err)
+		}
+	}
+	return entries, len(expired) == 0, nil
+}
+
+// DropRejectedNotes drops the pending entries of a note write the sync
+// server rejected, so reads go back to the database. Entries of a later
+// write, or that settled already, are kept.
+func (s *Storage) DropRejectedNotes(ctx context.Context, rejected RejectedNotes) {
+	var dropped []string
+	for _, noteID := range rejected.NoteIDs {
+		entry, ok := s.cachedNote(ctx, rejected.UserID, noteID)
+		if ok && entry.Pending && entry.Version == rejected.UpdateTime {
+			dropped = append(dropped, noteID)
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+
+	log.Printf("Dropping rejected writes of notes %v of user %s: %s", dropped, rejected.UserID, rejected.Reason)
+	s.uncacheNotes(ctx, rejected.UserID, dropped...)
+	// The listing from the cache alone would now miss the stored notes
+	s.unmarkNotesLoaded(ctx, rejected.UserID)
+	s.bumpNotesVersion(ctx, rejected.UserID)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/models"
+	"pdm-shared/integrity"
+	"pdm-shared/patch"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrPatchConflict is returned when a patch was made against a version of the
+// note other than the current one
+var ErrPatchConflict = errors.New("note has changed since the patch base")
+
+// ErrInvalidPatch is returned for patches that cannot be applied
+var ErrInvalidPatch = patch.ErrInvalidPatch
+
+// PatchNote applies a text or JSON patch to the current version of a note
+// owned by ownerID and checks the result against the digests in req. Only
+// the patch is dispatched to the sync server; the patched note is cached as
+// a pending write, dropped again if the sync server rejects the patch, and
+// returned.
+func (s *Storage) PatchNote(ctx context.Context, ownerID, noteID string, req models.PatchNoteRequest, integrityCfg *config.IntegrityConfig, quota *config.QuotaConfig) (models.Notes, error) {
+	current, err := s.GetNoteByID(ctx, ownerID, noteID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return current, ErrNoteNotFound
+	}
+	if err != nil {
+		return current, err
+	}
+
+	// The base is named by the digest of the content, whatever version of
+	// digest the stored note still carries
+	baseHash, _ := integrity.Compute(current.Heading, current.Content)
+	if !strings.EqualFold(req.BaseHash, baseHash) {
+		return current, fmt.Errorf("%w: base %s, current %s", ErrPatchConflict, req.BaseHash, baseHash)
+	}
+
+	doc, err := patch.Apply(patch.Document{
+		Content: current.Content,
+		Heading: current.Heading,
+		Deleted: current.Deleted,
+	}, req.Format, req.Patch)
+	if err != nil {
+		return current, err
+	}
+
+	note := current
+	note.Content = doc.Content
+	note.Heading = doc.Heading
+	note.Deleted = doc.Deleted
+	note.H = req.H
+	note.Intgrh = req.Intgrh
+	if note.Intgrh == "" && note.Heading == current.Heading {
+		note.Intgrh = current.Intgrh
+	}
+	note.UpdateTime = time.Now()
+
+	if err := VerifyNoteIntegrity(note.Heading, note.Content, note.H, note.Intgrh, integrityCfg); err != nil {
+		return current, err
+	}
+
+	_, limits, err := s.QuotaLimits(ctx, ownerID, quota)
+	if err != nil {
+		return current, err
+	}
+	writes := []noteWrite{{noteID: noteID, size: NoteSize(note.Heading, note.Content)}}
+	if err := s.checkQuota(ctx, ownerID, writes, limits); err != nil {
+		return current, err
+	}
+
+	participants, err := s.noteParticipants(ctx, ownerID, noteID)
+	if err != nil {
+		log.Printf("Failed to look up note participants: %v", err)
+	}
+
+	if err := s.R.DispatchNotePatch(note, req.BaseHash, req, participants[noteID]); err != nil {
+		return current, err
+	}
+	s.recordNoteWrites(ctx, ownerID, writes)
+
+	s.cacheNoteWrite(ctx, ownerID, note)
+	s.bumpNotesVersion(ctx, ownerID)
+
+	return note, nil
+}
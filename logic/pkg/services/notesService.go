@@ -2,109 +2,211 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
 	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/util"
+	"pdm-shared/integrity"
 	"time"
+
+	"gorm.io/gorm"
 )
 
-func (s *Storage) GetNotes(ctx context.Context, userID string, cacheTTL int) ([]models.Notes, error) {
-	var notes []models.Notes
+// ErrBatchRejected is returned when at least one operation of a note batch
+// fails validation and the batch was not dispatched.
+var ErrBatchRejected = errors.New("note batch rejected")
+
+// ErrBatchTooLarge and ErrBatchQuota are returned instead of ErrBatchRejected,
+// which they wrap, when a note of the batch is too large or the batch would
+// take the user over their quota.
+var (
+	ErrBatchTooLarge = fmt.Errorf("%w: %v", ErrBatchRejected, ErrNoteTooLarge)
+	ErrBatchQuota    = fmt.Errorf("%w: quota exceeded", ErrBatchRejected)
+)
+
+// ErrBatchIntegrity is returned instead of ErrBatchRejected, which it wraps,
+// when an operation was rejected because its digests did not verify.
+var ErrBatchIntegrity = fmt.Errorf("%w: integrity check failed", ErrBatchRejected)
+
+// VerifyNoteIntegrity checks the h and intgrh digests sent with a note.
+// Unversioned digests are accepted unless cfg requires versioned ones.
+func VerifyNoteIntegrity(heading, content, h, intgrh string, cfg *config.IntegrityConfig) error {
+	err := integrity.Verify(heading, content, h, intgrh)
+	if errors.Is(err, integrity.ErrLegacy) && !cfg.RequireVersioned {
+		return nil
+	}
+	return err
+}
+
+// GetNotes returns the user's notes: from the cache alone while it holds
+// them all, and otherwise the database rows merged with the cache; see
+// noteCache.go for how the two are reconciled.
+func (s *Storage) GetNotes(ctx context.Context, userID string) ([]models.Notes, error) {
+	key := s.Ch.Schema.NoteIndex(userID)
+	if notes, load, ok := s.cachedListing(ctx, userID); ok {
+		log.Printf("Found %d cached notes", len(notes))
+		if s.refreshDue(load.At, load.Took, s.Ch.Schema.TTL(keys.NotesLoaded)) {
+			s.refreshShared(key, func(ctx context.Context) error {
+				_, err := s.loadNotes(ctx, userID)
+				return err
+			})
+		}
+		return notes, nil
+	}
 
-	keysPattern := fmt.Sprintf("user:%s:note:*", userID)
-	keys, err := s.Ch.Keys(ctx, keysPattern)
+	return loadShared(ctx, s, key, func() ([]models.Notes, bool) {
+		notes, _, ok := s.cachedListing(ctx, userID)
+		return notes, ok
+	}, func() ([]models.Notes, error) {
+		return s.loadNotes(ctx, userID)
+	})
+}
+
+// loadNotes reads all of the user's notes from the database, merges them
+// with the cache and loads them into it
+func (s *Storage) loadNotes(ctx context.Context, userID string) ([]models.Notes, error) {
+	start := time.Now()
+
+	// The database has every note the sync server has written; the cache
+	// has the writes still on their way there. It is read second, so a
+	// write that lands in between is not lost from either.
+	var stored []models.Notes
+	err := s.DB.Model(&models.Notes{}).
+		Select("noteid", "userid", "heading", "time", "h", "update_time", "intgrh", "content", "deleted").
+		Where("userid = ?", userID).
+		Find(&stored).Error
 	if err != nil {
-		log.Printf("Failed to retrieve keys: %v", err)
 		return nil, err
 	}
-	if len(keys) == 0 {
-		log.Printf("Cache miss or failure to find note in cache: %v", err)
 
-	} else {
-		log.Printf("Cache hit - found %d keys", len(keys))
-		for _, key := range keys {
-			jsonData, err := s.Ch.Get(ctx, key)
-			if err == nil {
-				// Cache hit - need to deserialize
-				var note models.Notes
-				err = json.Unmarshal([]byte(jsonData), &note)
-				if err == nil {
-					notes = append(notes, note)
-				} else {
-					// If unmarshal fails, log and continue to next key
-					log.Printf("Failed to unmarshal cached note: %v", err)
-				}
-			} else {
-				// Log cache miss or error
-				log.Printf("Cache retrieve of existing key failed %v", err)
+	cached, _, err := s.cachedNotes(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to read cached notes: %v", err)
+		return nil, err
+	}
+
+	s.refillNoteCache(ctx, userID, stored, cached)
+	s.markNotesLoaded(ctx, userID, notesLoad{At: start, Took: time.Since(start)})
+
+	return mergeNotes(stored, cached), nil
+}
+
+// streamBatchSize is how many notes StreamNotes merges with the cache at a
+// time
+const streamBatchSize = 100
+
+// StreamNotes calls fn for each of the user's notes as they are read from a
+// DB cursor, so the whole vault is never held in memory. Rows are merged
+// with the cache as in GetNotes, a batch at a time: only the IDs of the
+// cached notes are read up front, and the entries of a batch are read with
+// one MGET when it is sent.
+func (s *Storage) StreamNotes(ctx context.Context, userID string, fn func(models.Notes) error) error {
+	ids, err := s.Ch.SMembers(ctx, s.Ch.Schema.NoteIndex(userID))
+	if err != nil {
+		return err
+	}
+	uncached := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		uncached[id] = true
+	}
+
+	// send merges a batch of rows, or of cached notes alone, with their
+	// cache entries and hands the notes to fn
+	send := func(stored []models.Notes, cachedIDs []string) error {
+		entries, _, err := s.noteEntries(ctx, userID, cachedIDs)
+		if err != nil {
+			return err
+		}
+		for _, note := range mergeNotes(stored, entries) {
+			if err := fn(note); err != nil {
+				return err
 			}
 		}
+		return nil
 	}
 
-	// Cache miss or unmarshal error, get from DB
-	err = s.DB.Model(&models.Notes{}).
+	rows, err := s.DB.WithContext(ctx).Model(&models.Notes{}).
 		Select("noteid", "userid", "heading", "time", "h", "update_time", "intgrh", "content", "deleted").
 		Where("userid = ?", userID).
-		Find(&notes).Error
+		Order("time").
+		Rows()
 	if err != nil {
-		// Handle the error
-		fmt.Println("Error:", err)
-	} else {
-		// Use the notes slice
-		//fmt.Println("Notes:", notes)
+		return err
 	}
+	defer rows.Close()
 
-	// Cache the result for next time
-	for _, note := range notes {
-		key := fmt.Sprintf("user:%s:note:%s", note.UserID, note.NoteID)
-		bytes, err := json.Marshal(note)
-		if err == nil {
-			jsonData := string(bytes)
-			err = s.Ch.Set(ctx, key, jsonData, time.Duration(cacheTTL)*time.Minute)
-			if err != nil {
-				log.Printf("Failed to cache notes: %v", err)
+	batch := make([]models.Notes, 0, streamBatchSize)
+	var cachedIDs []string
+	for rows.Next() {
+		var note models.Notes
+		if err := s.DB.ScanRows(rows, &note); err != nil {
+			return err
+		}
+		batch = append(batch, note)
+		if uncached[note.NoteID] {
+			delete(uncached, note.NoteID)
+			cachedIDs = append(cachedIDs, note.NoteID)
+		}
+
+		if len(batch) == streamBatchSize {
+			if err := send(batch, cachedIDs); err != nil {
+				return err
 			}
-		} else {
-			log.Printf("Failed to marshal note: %v", err)
+			batch, cachedIDs = batch[:0], cachedIDs[:0]
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := send(batch, cachedIDs); err != nil {
+		return err
+	}
 
-	return notes, nil
-}
-
-func (s *Storage) GetNoteByID(ctx context.Context, userID string, noteID string, cacheTTL int) (models.Notes, error) {
-	var note models.Notes
-
-	key := fmt.Sprintf("user:%s:note:%s", userID, noteID)
-	jsonData, err := s.Ch.Get(ctx, key)
-	if err == nil {
-		// Cache hit - need to deserialize
-		err = json.Unmarshal([]byte(jsonData), &note)
-		if err == nil {
-			return note, nil
+	// What is left is either pending or no longer in the database
+	cachedIDs = cachedIDs[:0]
+	for id := range uncached {
+		cachedIDs = append(cachedIDs, id)
+		if len(cachedIDs) == streamBatchSize {
+			if err := send(nil, cachedIDs); err != nil {
+				return err
+			}
+			cachedIDs = cachedIDs[:0]
 		}
-		// If unmarshal fails, log and continue to DB
-		log.Printf("Failed to unmarshal cached noteInfo: %v", err)
 	}
+	return send(nil, cachedIDs)
+}
 
-	// Cache miss or unmarshal error, get from DB
-	err = s.DB.First(&note, noteID).Error
-	if err != nil {
-		return note, err
+func (s *Storage) GetNoteByID(ctx context.Context, userID string, noteID string) (models.Notes, error) {
+	cached := func() (cachedNote, bool) {
+		return s.cachedNote(ctx, userID, noteID)
 	}
 
-	// Cache the result for next time
-	bytes, err := json.Marshal(note)
-	jsonData = string(bytes)
-	if err == nil {
-		err = s.Ch.Set(ctx, key, jsonData, time.Duration(cacheTTL)*time.Minute)
+	// A cache entry is never older than the database
+	entry, ok := cached()
+	if !ok {
+		var err error
+		entry, err = loadShared(ctx, s, s.Ch.Schema.Note(userID, noteID), cached, func() (cachedNote, error) {
+			var note models.Notes
+			if err := s.DB.First(&note, "noteid = ?", noteID).Error; err != nil {
+				return cachedNote{}, err
+			}
+
+			// Cache the result for next time
+			s.fillNoteCache(ctx, userID, []models.Notes{note})
+			return cachedNote{Note: note}, nil
+		})
 		if err != nil {
-			log.Printf("Failed to cache noteInfo: %v", err)
+			return models.Notes{}, err
 		}
 	}
 
-	return note, nil
+	if entry.Removed {
+		return models.Notes{}, gorm.ErrRecordNotFound
+	}
+	return entry.Note, nil
 }
 
 // CreateNote creates a new note for the given user in the storage layer.
@@ -118,59 +220,70 @@ func (s *Storage) GetNoteByID(ctx context.Context, userID string, noteID string,
 //   - models.Notes: the newly created note
 //   - error: nil if successful, otherwise contains the error that occurred
 //
-// The cache key is formatted as "user:{userId}:note:{noteId}". If caching fails,
-// the error is logged but the function will still return successfully.
-func (s *Storage) CreateNote(ctx context.Context, userId string, cacheTTL int) (models.Notes, error) {
+// The note is cached under s.Ch.Schema.Note(userId, noteId), that is
+// "v<N>:user:{userId}:note:{noteId}". If caching fails, the error is logged
+// but the function will still return successfully.
+func (s *Storage) CreateNote(ctx context.Context, userId string, quota *config.QuotaConfig) (models.Notes, error) {
 	note := models.Notes{
 		UserID: userId,
 	}
+	note.H, note.Intgrh = integrity.Compute(note.Heading, note.Content)
+
+	_, limits, err := s.QuotaLimits(ctx, userId, quota)
+	if err != nil {
+		return note, err
+	}
+	if err := s.checkQuota(ctx, userId, []noteWrite{{create: true}}, limits); err != nil {
+		return note, err
+	}
 
 	// Save the note to the database
 	db := s.DB.Create(&note)
 	if db.Error != nil {
 		return note, db.Error
 	}
+	s.recordNoteWrites(ctx, userId, []noteWrite{{noteID: note.NoteID, create: true}})
 
 	// Cache the result for next time
-	key := fmt.Sprintf("user:%s:note:%s", note.UserID, note.NoteID)
-	bytes, err := json.Marshal(note)
-	if err == nil {
-		jsonData := string(bytes)
-		err = s.Ch.Set(ctx, key, jsonData, time.Duration(cacheTTL)*time.Minute)
-		if err != nil {
-			log.Printf("Failed to cache note: %v", err)
-		}
-	} else {
-		log.Printf("Failed to marshal note: %v", err)
-	}
+	s.fillNoteCache(ctx, userId, []models.Notes{note})
+	s.bumpNotesVersion(ctx, userId)
 
 	return note, nil
 }
 
-func (s *Storage) UpdateNote(ctx context.Context, note models.Notes, cacheTTL int) error {
+func (s *Storage) UpdateNote(ctx context.Context, note models.Notes, quota *config.QuotaConfig) error {
 	note.UpdateTime = time.Now()
 
 	log.Printf("[DEBUG, func (s *Storage) UpdateNote] note.Time: %v", note.Time)
 
+	// Shared notes count against the owner's quota
+	_, limits, err := s.QuotaLimits(ctx, note.UserID, quota)
+	if err != nil {
+		return err
+	}
+	writes := []noteWrite{{noteID: note.NoteID, size: NoteSize(note.Heading, note.Content)}}
+	if err := s.checkQuota(ctx, note.UserID, writes, limits); err != nil {
+		return err
+	}
+
+	// Everyone the note is shared with gets the update too
+	participants, err := s.noteParticipants(ctx, note.UserID, note.NoteID)
+	if err != nil {
+		log.Printf("Failed to look up note participants: %v", err)
+	}
+
 	// Save the note to the database through rabbitmq
-	err := s.R.DispatchNoteUpdate(note)
+	err = s.R.DispatchNoteUpdate(note, participants[note.NoteID])
 	if err != nil {
 		log.Printf("Failed to dispatch note update: %v", err)
 		return err
 	}
+	s.recordNoteWrites(ctx, note.UserID, writes)
 
-	// Cache the changed note
-	key := fmt.Sprintf("user:%s:note:%s", note.UserID, note.NoteID)
-	bytes, err := json.Marshal(note)
-	if err == nil {
-		jsonData := string(bytes)
-		err = s.Ch.Set(ctx, key, jsonData, time.Duration(cacheTTL)*time.Minute)
-		if err != nil {
-			log.Printf("Failed to cache note: %v", err)
-		}
-	} else {
-		log.Printf("Failed to marshal note: %v", err)
-	}
+	// Cache the changed note. Declared links live in note_link, not on notes.
+	note.Links = nil
+	s.cacheNoteWrite(ctx, note.UserID, note)
+	s.bumpNotesVersion(ctx, note.UserID)
 
 	return nil
 }
@@ -183,13 +296,241 @@ func (s *Storage) DeleteNote(ctx context.Context, userId string, req models.Dele
 		log.Printf("Failed to dispatch note update: %v", err)
 		return err
 	}
+	if req.DeletePermanently {
+		s.recordNoteDeletes(ctx, userId, req.NoteID)
+	}
 
-	// Delete the note from the cache
-	key := fmt.Sprintf("user:%s:note:%s", userId, req.NoteID)
-	err = s.Ch.Delete(ctx, key)
-	if err != nil {
-		log.Printf("Failed to delete note from cache: %v", err)
+	// Cache the delete until the sync server has applied it
+	if req.DeletePermanently {
+		s.cacheNoteRemoval(ctx, userId, req.NoteID, time.Now())
+	} else {
+		s.cacheNoteRestore(ctx, userId, req.NoteID, time.Now())
 	}
+	s.bumpNotesVersion(ctx, userId)
 
 	return nil
 }
+
+// ApplyNoteBatch validates an ordered list of note operations and dispatches
+// them to the sync server as a single unit, which applies them in one DB
+// transaction.
+//
+// Created notes get their ID here. A create may carry a clientRef, the
+// client's temporary ID for the note, and later operations of the batch may
+// name the note by that clientRef instead of a noteid; the results map each
+// clientRef to the ID it resolved to.
+//
+// Ownership of every updated or deleted note is checked up front. If any
+// operation is rejected nothing is dispatched, the rejected operations carry
+// the reason and the rest are reported as skipped, and ErrBatchRejected is
+// returned alongside the results. The cache is only written once the sync
+// server has the batch.
+func (s *Storage) ApplyNoteBatch(ctx context.Context, userId string, ops []models.BatchNoteOperation, integrityCfg *config.IntegrityConfig, quota *config.QuotaConfig) ([]models.BatchNoteResult, error) {
+	results := make([]models.BatchNoteResult, len(ops))
+
+	_, limits, err := s.QuotaLimits(ctx, userId, quota)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look up every referenced note in one query
+	var noteIDs []string
+	for _, op := range ops {
+		if op.Op != models.BatchOpCreate && op.NoteID != "" {
+			noteIDs = append(noteIDs, op.NoteID)
+		}
+	}
+	owned := make(map[string]bool, len(noteIDs))
+	if len(noteIDs) > 0 {
+		var ids []string
+		err := s.DB.Model(&models.Notes{}).
+			Where("noteid IN ? AND userid = ?", noteIDs, userId).
+			Pluck("noteid", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			owned[id] = true
+		}
+	}
+
+	rejected, integrityFailed, tooLarge := false, false, false
+	var writes []noteWrite
+	var deletes []string
+	// created maps the clientRef of each create to the note ID it was given,
+	// and createWrites the IDs of created notes to their write
+	created := make(map[string]string)
+	createWrites := make(map[string]int)
+	for i := range ops {
+		op := &ops[i]
+		refError := ""
+		switch {
+		case op.Op == models.BatchOpCreate:
+			op.NoteID = util.NewUUID()
+			if op.ClientRef != "" {
+				if _, ok := created[op.ClientRef]; ok {
+					refError = "duplicate clientRef"
+				}
+				created[op.ClientRef] = op.NoteID
+			}
+		case op.NoteID == "" && op.ClientRef != "":
+			// A note created earlier in the batch
+			op.NoteID = created[op.ClientRef]
+			if op.NoteID == "" {
+				refError = "unknown clientRef"
+			}
+		}
+
+		results[i] = models.BatchNoteResult{
+			Index:     i,
+			Op:        op.Op,
+			NoteID:    op.NoteID,
+			ClientRef: op.ClientRef,
+			Status:    models.BatchStatusAccepted,
+		}
+
+		_, createdHere := createWrites[op.NoteID]
+		switch {
+		case refError != "":
+			results[i].Error = refError
+		case op.Op != models.BatchOpCreate && !owned[op.NoteID] && !createdHere:
+			results[i].Error = "note not found"
+		}
+		if results[i].Error != "" {
+			results[i].Status = models.BatchStatusRejected
+			rejected = true
+			continue
+		}
+
+		if op.Op == models.BatchOpDelete {
+			if op.DeletePermanently {
+				deletes = append(deletes, op.NoteID)
+			}
+			continue
+		}
+
+		if err := VerifyNoteIntegrity(op.Heading, op.Content, op.H, op.Intgrh, integrityCfg); err != nil {
+			log.Printf("Batch operation %d of user %s failed its integrity check: %v", i, userId, err)
+			results[i].Status = models.BatchStatusRejected
+			results[i].Error = "integrity check failed"
+			rejected, integrityFailed = true, true
+			continue
+		}
+
+		size := NoteSize(op.Heading, op.Content)
+		if limits.MaxNoteBytes > 0 && size > limits.MaxNoteBytes {
+			results[i].Status = models.BatchStatusRejected
+			results[i].Error = ErrNoteTooLarge.Error()
+			rejected, tooLarge = true, true
+			continue
+		}
+		if j, ok := createWrites[op.NoteID]; ok {
+			// Only the last content of a note created in the batch is stored
+			writes[j].size = size
+			continue
+		}
+		if op.Op == models.BatchOpCreate {
+			createWrites[op.NoteID] = len(writes)
+		}
+		writes = append(writes, noteWrite{noteID: op.NoteID, size: size, create: op.Op == models.BatchOpCreate})
+	}
+
+	// The batch as a whole has to fit the quota
+	if !rejected {
+		if err := s.checkQuota(ctx, userId, writes, limits); err != nil {
+			if !errors.Is(err, ErrNoteLimit) && !errors.Is(err, ErrQuotaExceeded) {
+				return nil, err
+			}
+			log.Printf("Batch of user %s exceeds the quota: %v", userId, err)
+			reason := ErrQuotaExceeded.Error()
+			if errors.Is(err, ErrNoteLimit) {
+				reason = ErrNoteLimit.Error()
+			}
+			for i := range results {
+				results[i].Status = models.BatchStatusRejected
+				results[i].Error = reason
+			}
+			return results, fmt.Errorf("%w: %v", ErrBatchQuota, err)
+		}
+	}
+
+	if rejected {
+		for i := range results {
+			if results[i].Status == models.BatchStatusAccepted {
+				results[i].Status = models.BatchStatusSkipped
+			}
+		}
+		switch {
+		case integrityFailed:
+			return results, ErrBatchIntegrity
+		case tooLarge:
+			return results, ErrBatchTooLarge
+		}
+		return results, ErrBatchRejected
+	}
+
+	participants, err := s.noteParticipants(ctx, userId, noteIDs...)
+	if err != nil {
+		log.Printf("Failed to look up note participants: %v", err)
+	}
+
+	updateTime := time.Now()
+	if err := s.R.DispatchNoteBatch(userId, ops, participants, updateTime); err != nil {
+		log.Printf("Failed to dispatch note batch: %v", err)
+		return nil, err
+	}
+	s.recordNoteWrites(ctx, userId, writes)
+	if len(deletes) > 0 {
+		s.recordNoteDeletes(ctx, userId, deletes...)
+	}
+
+	s.cacheNoteBatch(ctx, userId, ops, updateTime)
+	s.bumpNotesVersion(ctx, userId)
+
+	return results, nil
+}
+
+// cacheNoteBatch mirrors a dispatched batch into the cache, in order. It
+// must only be called once DispatchNoteBatch returned nil, or the cache
+// would serve writes the sync server never got.
+func (s *Storage) cacheNoteBatch(ctx context.Context, userId string, ops []models.BatchNoteOperation, updateTime time.Time) {
+	createTimes := make(map[string]time.Time)
+	for _, op := range ops {
+		if op.Op == models.BatchOpDelete {
+			if op.DeletePermanently {
+				s.cacheNoteRemoval(ctx, userId, op.NoteID, updateTime)
+			} else {
+				s.cacheNoteRestore(ctx, userId, op.NoteID, updateTime)
+			}
+			continue
+		}
+
+		note := models.Notes{
+			NoteID:     op.NoteID,
+			UserID:     userId,
+			Content:    op.Content,
+			H:          op.H,
+			Intgrh:     op.Intgrh,
+			Heading:    op.Heading,
+			Deleted:    op.Deleted,
+			UpdateTime: updateTime,
+		}
+		if op.Op == models.BatchOpCreate {
+			createTimes[op.NoteID] = updateTime
+		}
+		note.Time = createTimes[op.NoteID]
+		s.cacheNoteWrite(ctx, userId, note)
+	}
+}
+
+// NoteOwnedBy reports whether the note exists and belongs to the given user.
+func (s *Storage) NoteOwnedBy(ctx context.Context, noteID string, userId string) (bool, error) {
+	var count int64
+	err := s.DB.Model(&models.Notes{}).
+		Where("noteid = ? AND userid = ?", noteID, userId).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
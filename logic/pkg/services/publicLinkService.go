@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/util"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLinkNotFound         = errors.New("link not found")
+	ErrLinkExpired          = errors.New("link expired")
+	ErrLinkPasswordRequired = errors.New("link password required")
+	ErrLinkPasswordInvalid  = errors.New("invalid link password")
+	ErrTooManyAttempts      = errors.New("too many attempts")
+)
+
+const (
+	linkPasswordAttempts       = 10
+	linkPasswordAttemptsWindow = 15 * time.Minute
+)
+
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePublicLink publishes a note owned by userID and returns the link
+// together with its token, which is not stored and cannot be shown again.
+func (s *Storage) CreatePublicLink(ctx context.Context, userID, noteID string, req models.CreatePublicLinkRequest) (models.PublicLink, string, error) {
+	var link models.PublicLink
+
+	owned, err := s.NoteOwnedBy(ctx, noteID, userID)
+	if err != nil {
+		return link, "", err
+	}
+	if !owned {
+		return link, "", ErrNoteNotFound
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return link, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	link = models.PublicLink{
+		ID:        util.NewUUID(),
+		TokenHash: hashLinkToken(token),
+		NoteID:    noteID,
+		UserID:    userID,
+		ExpiresAt: req.ExpiresAt,
+		MaxViews:  req.MaxViews,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return link, "", err
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := s.DB.Create(&link).Error; err != nil {
+		return link, "", err
+	}
+	return link, token, nil
+}
+
+func (s *Storage) ListPublicLinks(ctx context.Context, userID, noteID string) ([]models.PublicLink, error) {
+	links := []models.PublicLink{}
+	err := s.DB.Where("noteid = ? AND userid = ?", noteID, userID).
+		Order("created_at").
+		Find(&links).Error
+	return links, err
+}
+
+func (s *Storage) RevokePublicLink(ctx context.Context, userID, noteID, linkID string) error {
+	result := s.DB.Model(&models.PublicLink{}).
+		Where("id = ? AND noteid = ? AND userid = ?", linkID, noteID, userID).
+		Update("revoked", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+// OpenPublicLink resolves a link token to its note and counts the view.
+// Password guesses are rate limited per link.
+func (s *Storage) OpenPublicLink(ctx context.Context, token, password string) (models.PublicNote, error) {
+	var link models.PublicLink
+	err := s.DB.Where("token_hash = ?", hashLinkToken(token)).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.PublicNote{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return models.PublicNote{}, err
+	}
+
+	if link.Revoked {
+		return models.PublicNote{}, ErrLinkNotFound
+	}
+	if linkUsedUp(link) {
+		return models.PublicNote{}, ErrLinkExpired
+	}
+
+	if link.PasswordHash != "" {
+		if password == "" {
+			return models.PublicNote{}, ErrLinkPasswordRequired
+		}
+		attempts, err := s.Ch.IncrWithReset(ctx, s.Ch.Schema.PublicLinkAttempts(link.ID), linkPasswordAttemptsWindow)
+		if err == nil && attempts > linkPasswordAttempts {
+			return models.PublicNote{}, ErrTooManyAttempts
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return models.PublicNote{}, ErrLinkPasswordInvalid
+		}
+	}
+
+	// A note in the trash is not published; its links work again once it
+	// is restored
+	var note models.Notes
+	err = s.DB.Select("heading", "content", "update_time").
+		Where("noteid = ? AND userid = ? AND deleted = 0", link.NoteID, link.UserID).
+		First(&note).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.PublicNote{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return models.PublicNote{}, err
+	}
+
+	// Count the view, re-checking the limits so concurrent views cannot overshoot
+	result := s.DB.Model(&models.PublicLink{}).
+		Where("id = ? AND revoked = ? AND (max_views = 0 OR views < max_views) AND (expires_at IS NULL OR expires_at > ?)",
+			link.ID, false, time.Now()).
+		Update("views", gorm.Expr("views + 1"))
+	if result.Error != nil {
+		return models.PublicNote{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.PublicNote{}, ErrLinkExpired
+	}
+
+	return models.PublicNote{
+		Heading:    note.Heading,
+		Content:    note.Content,
+		UpdateTime: note.UpdateTime,
+	}, nil
+}
+
+func linkUsedUp(link models.PublicLink) bool {
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		return true
+	}
+	return link.MaxViews > 0 && link.Views >= link.MaxViews
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"pdm-logic-server/pkg/cache/keys"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/models"
+	"strconv"
+)
+
+var (
+	ErrNoteTooLarge = errors.New("note exceeds the maximum note size")
+	ErrNoteLimit    = errors.New("note count limit reached")
+)
+
+// Usage is tracked in Redis per user as a note counter, a byte counter and a
+// hash of the size of every note, so updates can apply the size difference.
+// It is trusted for the usage TTL, then recomputed from the database.
+
+// NoteSize is the size of a note counted against quotas
+func NoteSize(heading, content string) int64 {
+	return int64(len(heading) + len(content))
+}
+
+// noteWrite is a pending create or update of a note
+type noteWrite struct {
+	noteID string
+	size   int64
+	create bool
+}
+
+// QuotaLimits returns the limits of the tier userID belongs to
+func (s *Storage) QuotaLimits(ctx context.Context, userID string, cfg *config.QuotaConfig) (string, config.QuotaLimits, error) {
+	userInfo, err := GetUserInfo(s, ctx, userID)
+	if err != nil {
+		return "", config.QuotaLimits{}, err
+	}
+	return userInfo.Product, cfg.For(userInfo.Product), nil
+}
+
+// GetUsage returns the tracked usage of userID, recomputing it from the
+// database when Redis has none.
+func (s *Storage) GetUsage(ctx context.Context, userID string) (models.Usage, error) {
+	notesKey, bytesKey, _ := s.Ch.Schema.Usage(userID)
+
+	notes, err := s.Ch.Get(ctx, notesKey)
+	if err != nil {
+		return models.Usage{}, err
+	}
+	bytes, err := s.Ch.Get(ctx, bytesKey)
+	if err != nil {
+		return models.Usage{}, err
+	}
+	if notes == "" || bytes == "" {
+		return s.ReconcileUsage(ctx, userID)
+	}
+
+	var usage models.Usage
+	usage.Notes, _ = strconv.Atoi(notes)
+	usage.Bytes, _ = strconv.ParseInt(bytes, 10, 64)
+	return usage, nil
+}
+
+// ReconcileUsage recomputes the usage of userID from the database and
+// replaces what Redis tracked. Writes still queued for the sync server are
+// picked up by the next reconciliation.
+func (s *Storage) ReconcileUsage(ctx context.Context, userID string) (models.Usage, error) {
+	var rows []struct {
+		NoteID string
+		Size   int64
+	}
+	err := s.DB.WithContext(ctx).Model(&models.Notes{}).
+		Select("noteid AS note_id, OCTET_LENGTH(COALESCE(heading, '')) + OCTET_LENGTH(COALESCE(content, '')) AS size").
+		Where("userid = ?", userID).
+		Scan(&rows).Error
+	if err != nil {
+		return models.Usage{}, err
+	}
+
+	usage := models.Usage{Notes: len(rows)}
+	notesKey, bytesKey, sizesKey := s.Ch.Schema.Usage(userID)
+
+	sizes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		usage.Bytes += row.Size
+		sizes[row.NoteID] = strconv.FormatInt(row.Size, 10)
+	}
+	if err := s.Ch.Delete(ctx, sizesKey); err != nil {
+		return usage, err
+	}
+	if err := s.Ch.HSetAll(ctx, sizesKey, sizes); err != nil {
+		return usage, err
+	}
+	if len(rows) > 0 {
+		if err := s.Ch.Expire(ctx, sizesKey, s.Ch.Schema.TTL(keys.Usage)); err != nil {
+			log.Printf("Failed to set usage TTL of user %s: %v", userID, err)
+		}
+	}
+
+	if err := s.Ch.Set(ctx, notesKey, strconv.Itoa(usage.Notes), s.Ch.Schema.TTL(keys.Usage)); err != nil {
+		return usage, err
+	}
+	if err := s.Ch.Set(ctx, bytesKey, strconv.FormatInt(usage.Bytes, 10), s.Ch.Schema.TTL(keys.Usage)); err != nil {
+		return usage, err
+	}
+
+	return usage, nil
+}
+
+// checkQuota rejects writes that would take userID over its limits: a note
+// above the size limit gives ErrNoteTooLarge, too many notes ErrNoteLimit and
+// too many bytes ErrQuotaExceeded. Shrinking writes are always allowed.
+func (s *Storage) checkQuota(ctx context.Context, userID string, writes []noteWrite, limits config.QuotaLimits) error {
+	for _, w := range writes {
+		if limits.MaxNoteBytes > 0 && w.size > limits.MaxNoteBytes {
+			return ErrNoteTooLarge
+		}
+	}
+
+	usage, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, _, sizesKey := s.Ch.Schema.Usage(userID)
+	newNotes, delta := 0, int64(0)
+	for _, w := range writes {
+		if w.create {
+			newNotes++
+			delta += w.size
+			continue
+		}
+		delta += w.size - s.trackedNoteSize(ctx, sizesKey, w.noteID)
+	}
+
+	if limits.MaxNotes > 0 && newNotes > 0 && usage.Notes+newNotes > limits.MaxNotes {
+		return ErrNoteLimit
+	}
+	if limits.MaxBytes > 0 && delta > 0 && usage.Bytes+delta > limits.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (s *Storage) trackedNoteSize(ctx context.Context, sizesKey, noteID string) int64 {
+	value, err := s.Ch.HGet(ctx, sizesKey, noteID)
+	if err != nil {
+		log.Printf("Failed to read tracked note size: %v", err)
+		return 0
+	}
+	size, _ := strconv.ParseInt(value, 10, 64)
+	return size
+}
+
+// recordNoteWrites applies accepted writes to the tracked usage
+func (s *Storage) recordNoteWrites(ctx context.Context, userID string, writes []noteWrite) {
+	notesKey, bytesKey, sizesKey := s.Ch.Schema.Usage(userID)
+
+	newNotes, delta := int64(0), int64(0)
+	for _, w := range writes {
+		if w.create {
+			newNotes++
+		} else {
+			delta -= s.trackedNoteSize(ctx, sizesKey, w.noteID)
+		}
+		delta += w.size
+		if err := s.Ch.HSet(ctx, sizesKey, w.noteID, strconv.FormatInt(w.size, 10)); err != nil {
+			log.Printf("Failed to track note size: %v", err)
+		}
+	}
+
+	s.adjustUsage(ctx, notesKey, newNotes)
+	s.adjustUsage(ctx, bytesKey, delta)
+}
+
+// recordNoteDeletes removes permanently deleted notes from the tracked usage
+func (s *Storage) recordNoteDeletes(ctx context.Context, userID string, noteIDs ...string) {
+	notesKey, bytesKey, sizesKey := s.Ch.Schema.Usage(userID)
+
+	delta := int64(0)
+	for _, noteID := range noteIDs {
+		delta -= s.trackedNoteSize(ctx, sizesKey, noteID)
+	}
+	if err := s.Ch.HDel(ctx, sizesKey, noteIDs...); err != nil {
+		log.Printf("Failed to untrack note sizes: %v", err)
+	}
+
+	s.adjustUsage(ctx, notesKey, -int64(len(noteIDs)))
+	s.adjustUsage(ctx, bytesKey, delta)
+}
+
+// adjustUsage changes a usage counter only while it exists, so an expired
+// counter is rebuilt from the database rather than from a partial delta. The
+// check and the change are one step, so a counter expiring in between is
+// not recreated holding only the delta.
+func (s *Storage) adjustUsage(ctx context.Context, key string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if _, _, err := s.Ch.IncrByIfExists(ctx, key, delta); err != nil {
+		log.Printf("Failed to update usage counter %s: %v", key, err)
+	}
+}
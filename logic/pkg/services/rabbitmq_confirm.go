@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// A dispatch only returns once the broker has confirmed it: the message was
+// routed to the logic_to_sync queue and, the queue being durable and the
+// message persistent, written to disk. The channel is in confirm mode, so
+// the broker acks or nacks every publish by its delivery tag, 1, 2, 3, ...
+// in publish order; messages are published mandatory, so one that reaches
+// no queue is returned to us before it is acked.
+
+// confirmBuffer is the capacity of the confirm and return channels
+const confirmBuffer = 64
+
+var (
+	// ErrNotDispatched is wrapped by every error of a dispatch that the
+	// broker did not take; the write did not happen and may be retried
+	ErrNotDispatched = errors.New("message not dispatched")
+
+	ErrDispatchNacked     = fmt.Errorf("%w: rejected by the broker", ErrNotDispatched)
+	ErrDispatchUnroutable = fmt.Errorf("%w: no queue bound for it", ErrNotDispatched)
+	ErrDispatchTimeout    = fmt.Errorf("%w: not confirmed in time", ErrNotDispatched)
+	ErrDispatchClosed     = fmt.Errorf("%w: channel closed", ErrNotDispatched)
+	// ErrDispatchUnavailable fails dispatches at once while the connection
+	// to the broker is being restored
+	ErrDispatchUnavailable = fmt.Errorf("%w: reconnecting to the broker", ErrNotDispatched)
+)
+
+// pendingPublish is a publish waiting for its confirmation
+type pendingPublish struct {
+	id       string
+	returned bool
+	done     chan error
+}
+
+// confirmer publishes on one channel in confirm mode; delivery tags start
+// over with every channel, so a new one is made on every reconnect
+type confirmer struct {
+	channel *amqp.Channel
+	mu      sync.Mutex
+	nextTag uint64
+	pending map[uint64]*pendingPublish
+	byID    map[string]*pendingPublish
+}
+
+// newConfirmer puts ch in confirm mode and resolves the pending publishes
+// as the broker confirms them
+func newConfirmer(ch *amqp.Channel) (*confirmer, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	c := &confirmer{
+		channel: ch,
+		pending: make(map[uint64]*pendingPublish),
+		byID:    make(map[string]*pendingPublish),
+	}
+
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer))
+	returns := ch.NotifyReturn(make(chan amqp.Return, confirmBuffer))
+	go c.watch(confirms, returns)
+	return c, nil
+}
+
+func (c *confirmer) watch(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.markReturned(ret)
+		case confirm, ok := <-confirms:
+			if !ok {
+				c.failPending(ErrDispatchClosed)
+				return
+			}
+			// The return of a message comes before its ack; make sure it has
+			// been seen
+			for drained := false; !drained && returns != nil; {
+				select {
+				case ret, ok := <-returns:
+					if !ok {
+						returns = nil
+						break
+					}
+					c.markReturned(ret)
+				default:
+					drained = true
+				}
+			}
+			c.resolve(confirm)
+		}
+	}
+}
+
+func (c *confirmer) markReturned(ret amqp.Return) {
+	log.Printf("RabbitMQ returned message %s: %d %s", ret.MessageId, ret.ReplyCode, ret.ReplyText)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.byID[ret.MessageId]; ok {
+		p.returned = true
+	}
+}
+
+func (c *confirmer) resolve(confirm amqp.Confirmation) {
+	c.mu.Lock()
+	p, ok := c.pending[confirm.DeliveryTag]
+	if ok {
+		c.forget(confirm.DeliveryTag, p)
+	}
+	c.mu.Unlock()
+	if !ok {
+		// The dispatch timed out and is no longer waiting
+		return
+	}
+
+	switch {
+	case !confirm.Ack:
+		p.done <- ErrDispatchNacked
+	case p.returned:
+		p.done <- ErrDispatchUnroutable
+	default:
+		p.done <- nil
+	}
+}
+
+// failPending fails every publish still waiting for a confirmation
+func (c *confirmer) failPending(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tag, p := range c.pending {
+		c.forget(tag, p)
+		p.done <- err
+	}
+}
+
+// forget stops tracking a publish; c.mu must be held
+func (c *confirmer) forget(tag uint64, p *pendingPublish) {
+	delete(c.pending, tag)
+	delete(c.byID, p.id)
+}
+
+// publish publishes msg to the logic_to_sync queue and waits up to timeout
+// for the broker to confirm it
+func (c *confirmer) publish(msg amqp.Publishing, timeout time.Duration) error {
+	p := &pendingPublish{id: msg.MessageId, done: make(chan error, 1)}
+
+	// Delivery tags count successful publishes, so they are taken in the
+	// same order as the publishes themselves
+	c.mu.Lock()
+	err := c.channel.Publish(
+		"",              // Exchange
+		"logic_to_sync", // Routing key (queue name)
+		true,            // Mandatory
+		false,           // Immediate
+		msg,
+	)
+	if err != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrNotDispatched, err)
+	}
+	c.nextTag++
+	tag := c.nextTag
+	c.pending[tag] = p
+	c.byID[p.id] = p
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-p.done:
+		return err
+	case <-timer.C:
+		c.mu.Lock()
+		if _, ok := c.pending[tag]; ok {
+			c.forget(tag, p)
+			c.mu.Unlock()
+			return ErrDispatchTimeout
+		}
+		c.mu.Unlock()
+		// Resolved just as the timer fired
+		return <-p.done
+	}
+}
@@ -5,28 +5,49 @@ import (
 	"log"
 	"pdm-logic-server/pkg/config"
 	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/util"
+	"sync"
+	"time"
 
 	"github.com/streadway/amqp"
 )
 
+// RabbitMQCtx holds the connection to the broker and restores it when it
+// is lost, see rabbitmq_reconnect.go
 type RabbitMQCtx struct {
-	Conn    *amqp.Connection
-	Channel *amqp.Channel
+	cfg *config.RabbitMQConfig
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	confirmer *confirmer // nil while reconnecting
+	closed    bool
+	done      chan struct{}
 }
 
-// InitRabbitMQ initializes the RabbitMQ connection and channel
-func NewRabbitMQHandler() (*RabbitMQCtx, error) {
-	conn, channel, err := config.InitRabbitMQ()
-	if err != nil {
+// NewRabbitMQHandler connects to RabbitMQ; the first connection must
+// succeed, later ones are retried
+func NewRabbitMQHandler(cfg *config.RabbitMQConfig) (*RabbitMQCtx, error) {
+	c := &RabbitMQCtx{cfg: cfg, done: make(chan struct{})}
+	if err := c.connect(); err != nil {
 		return nil, err
 	}
-	return &RabbitMQCtx{
-		Conn:    conn,
-		Channel: channel,
-	}, nil
+	return c, nil
+}
+
+// publish publishes msg on the current channel, failing at once if there
+// is none
+func (c *RabbitMQCtx) publish(msg amqp.Publishing) error {
+	c.mu.RLock()
+	confirmer := c.confirmer
+	c.mu.RUnlock()
+	if confirmer == nil {
+		return ErrDispatchUnavailable
+	}
+	return confirmer.publish(msg, c.cfg.ConfirmTimeout)
 }
 
-// DispatchRabbitMQMessage sends a message to the "logic_to_sync" queue
+// DispatchRabbitMQMessage sends a message to the "logic_to_sync" queue and
+// returns once the broker has stored it; errors wrap ErrNotDispatched
 func (c *RabbitMQCtx) DispatchRabbitMQMessage(taskType string, payload map[string]interface{}) error {
 	message := map[string]interface{}{
 		"type":    taskType,
@@ -37,16 +58,12 @@ func (c *RabbitMQCtx) DispatchRabbitMQMessage(taskType string, payload map[strin
 		return err
 	}
 
-	err = c.Channel.Publish(
-		"",              // Exchange
-		"logic_to_sync", // Routing key (queue name)
-		false,           // Mandatory
-		false,           // Immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        messageBody,
-		},
-	)
+	err = c.publish(amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    util.NewUUID(),
+		Body:         messageBody,
+	})
 	if err != nil {
 		return err
 	}
@@ -55,10 +72,17 @@ func (c *RabbitMQCtx) DispatchRabbitMQMessage(taskType string, payload map[strin
 	return nil
 }
 
-// DispatchNoteUpdate sends a "note update" task to RabbitMQ
-func (c *RabbitMQCtx) DispatchNoteUpdate(note models.Notes) error {
+// DispatchNoteUpdate sends a "note update" task to RabbitMQ. The sync server
+// forwards the saved note to every recipient through the notes exchange.
+func (c *RabbitMQCtx) DispatchNoteUpdate(note models.Notes, recipients []string) error {
+	if len(recipients) == 0 {
+		recipients = []string{note.UserID}
+	}
+
 	payload := map[string]interface{}{
 		"noteid":      note.NoteID,
+		"userid":      note.UserID,
+		"recipients":  recipients,
 		"content":     note.Content,
 		"heading":     note.Heading,
 		"h":           note.H,
@@ -66,6 +90,9 @@ func (c *RabbitMQCtx) DispatchNoteUpdate(note models.Notes) error {
 		"deleted":     note.Deleted,
 		"update_time": note.UpdateTime.Unix(),
 	}
+	if note.Links != nil {
+		payload["links"] = note.Links
+	}
 
 	if err := c.DispatchRabbitMQMessage("note_update", payload); err != nil {
 		log.Printf("Failed to dispatch note update: %v", err)
@@ -74,6 +101,36 @@ func (c *RabbitMQCtx) DispatchNoteUpdate(note models.Notes) error {
 	return nil
 }
 
+// DispatchNotePatch sends a "note patch" task to RabbitMQ. Only the patch
+// travels; the sync server applies it to the stored note whose content hash
+// is baseHash and checks the result against note.H.
+func (c *RabbitMQCtx) DispatchNotePatch(note models.Notes, baseHash string, req models.PatchNoteRequest, recipients []string) error {
+	if len(recipients) == 0 {
+		recipients = []string{note.UserID}
+	}
+
+	payload := map[string]interface{}{
+		"noteid":      note.NoteID,
+		"userid":      note.UserID,
+		"recipients":  recipients,
+		"base_h":      baseHash,
+		"format":      req.Format,
+		"patch":       req.Patch,
+		"h":           note.H,
+		"intgrh":      note.Intgrh,
+		"update_time": note.UpdateTime.Unix(),
+	}
+	if req.Links != nil {
+		payload["links"] = req.Links
+	}
+
+	if err := c.DispatchRabbitMQMessage("note_patch", payload); err != nil {
+		log.Printf("Failed to dispatch note patch: %v", err)
+		return err
+	}
+	return nil
+}
+
 // DispatchNoteUpdate sends a "note update" task to RabbitMQ
 func (c *RabbitMQCtx) DispatchNoteDelete(req models.DeleteNoteRequest) error {
 	payload := map[string]interface{}{
@@ -88,6 +145,66 @@ func (c *RabbitMQCtx) DispatchNoteDelete(req models.DeleteNoteRequest) error {
 	return nil
 }
 
+// DispatchNoteBatch sends an ordered "note batch" task to RabbitMQ, which the
+// sync server applies in a single transaction. recipients maps note IDs to
+// the users that are sent the result; the batch owner is the default.
+func (c *RabbitMQCtx) DispatchNoteBatch(userID string, ops []models.BatchNoteOperation, recipients map[string][]string, updateTime time.Time) error {
+	operations := make([]map[string]interface{}, 0, len(ops))
+	for _, op := range ops {
+		opRecipients, ok := recipients[op.NoteID]
+		if !ok {
+			opRecipients = []string{userID}
+		}
+
+		operation := map[string]interface{}{
+			"recipients":        opRecipients,
+			"op":                op.Op,
+			"noteid":            op.NoteID,
+			"content":           op.Content,
+			"heading":           op.Heading,
+			"h":                 op.H,
+			"intgrh":            op.Intgrh,
+			"deleted":           op.Deleted,
+			"deletePermanently": op.DeletePermanently,
+		}
+		if op.Links != nil {
+			operation["links"] = op.Links
+		}
+		operations = append(operations, operation)
+	}
+
+	payload := map[string]interface{}{
+		"userId":      userID,
+		"operations":  operations,
+		"update_time": updateTime.Unix(),
+	}
+
+	if err := c.DispatchRabbitMQMessage("note_batch", payload); err != nil {
+		log.Printf("Failed to dispatch note batch: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DispatchReminder sends a due reminder to the sync server, which pushes it
+// to the user's connected WebSocket clients.
+func (c *RabbitMQCtx) DispatchReminder(reminder models.NoteReminder, heading string) error {
+	payload := map[string]interface{}{
+		"id":      reminder.ID,
+		"noteid":  reminder.NoteID,
+		"userid":  reminder.UserID,
+		"heading": heading,
+		"fire_at": reminder.FireAt.Unix(),
+	}
+
+	if err := c.DispatchRabbitMQMessage("reminder_due", payload); err != nil {
+		log.Printf("Failed to dispatch reminder: %v", err)
+		return err
+	}
+
+	return nil
+}
+
 // DispatchAddRefresh sends an "add session" task to RabbitMQ
 func (c *RabbitMQCtx) DispatchAddRefresh(userID, refreshKey string) {
 	payload := map[string]interface{}{
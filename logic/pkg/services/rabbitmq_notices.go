@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The sync server dead-letters a task that fails for good. When the task
+// was a note write, it also publishes a "note_rejected" notice on the
+// sync_to_logic queue, so the pending cache entries of the write are
+// dropped instead of being served until they expire. It does the same for
+// the notes of a write it skips because they were written after it. When it
+// deletes notes for good, it publishes an "attachments_deleted" notice, so
+// the files of their attachments are removed and their bytes given back.
+
+// RejectedNotes is the payload of a "note_rejected" notice: the notes of a
+// write the sync server rejected and the update time it carried
+type RejectedNotes struct {
+	UserID     string   `json:"userid"`
+	NoteIDs    []string `json:"noteids"`
+	UpdateTime int64    `json:"update_time"`
+	Reason     string   `json:"reason"`
+}
+
+// DeletedAttachments is the payload of an "attachments_deleted" notice: the
+// storage keys and total size of attachments of a user whose rows the sync
+// server deleted with their notes
+type DeletedAttachments struct {
+	UserID      string   `json:"userid"`
+	StorageKeys []string `json:"storage_keys"`
+	Bytes       int64    `json:"bytes"`
+}
+
+// SyncNoticeHandlers handle the notices of the sync server, one func per
+// type
+type SyncNoticeHandlers struct {
+	RejectedNotes      func(RejectedNotes)
+	DeletedAttachments func(DeletedAttachments)
+}
+
+// ConsumeSyncNotices hands the notices of the sync server to handlers
+// until ctx is cancelled or the connection is closed for good. It consumes
+// again whenever the connection is restored.
+func (c *RabbitMQCtx) ConsumeSyncNotices(ctx context.Context, handlers SyncNoticeHandlers) {
+	for {
+		if err := c.consumeSyncNotices(ctx, handlers); err != nil {
+			log.Printf("Failed to consume sync notices: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		case <-time.After(c.cfg.ReconnectMinDelay):
+		}
+	}
+}
+
+// consumeSyncNotices consumes sync_to_logic on a channel of its own until
+// the channel closes or ctx is cancelled. A notice is acked once handled.
+func (c *RabbitMQCtx) consumeSyncNotices(ctx context.Context, handlers SyncNoticeHandlers) error {
+	c.mu.RLock()
+	conn, connected := c.conn, c.confirmer != nil
+	c.mu.RUnlock()
+	if !connected {
+		return ErrDispatchUnavailable
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	deliveries, err := ch.Consume(
+		"sync_to_logic", // Queue name
+		"",              // Consumer tag
+		false,           // Auto-acknowledge
+		false,           // Exclusive
+		false,           // No-local
+		false,           // No-wait
+		nil,             // Arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-deliveries:
+			if !ok {
+				return nil
+			}
+
+			if err := handlers.handle(msg.Body); err != nil {
+				log.Printf("Dropped invalid notice from the sync server: %v: %s", err, string(msg.Body))
+			}
+			if err := msg.Ack(false); err != nil {
+				log.Printf("Failed to ack notice: %v", err)
+			}
+		}
+	}
+}
+
+// handle decodes a notice and hands it to the handler of its type
+func (h SyncNoticeHandlers) handle(body []byte) error {
+	var notice struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &notice); err != nil {
+		return err
+	}
+
+	switch notice.Type {
+	case "note_rejected":
+		var rejected RejectedNotes
+		if err := json.Unmarshal(notice.Payload, &rejected); err != nil {
+			return err
+		}
+		h.RejectedNotes(rejected)
+	case "attachments_deleted":
+		var deleted DeletedAttachments
+		if err := json.Unmarshal(notice.Payload, &deleted); err != nil {
+			return err
+		}
+		h.DeletedAttachments(deleted)
+	default:
+		return fmt.Errorf("unknown notice type %q", notice.Type)
+	}
+	return nil
+}
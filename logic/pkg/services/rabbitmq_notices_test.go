@@ -0,0 +1,48 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSyncNoticeHandlers(t *testing.T) {
+	var got []interface{}
+	handlers := SyncNoticeHandlers{
+		RejectedNotes:      func(rejected RejectedNotes) { got = append(got, rejected) },
+		DeletedAttachments: func(deleted DeletedAttachments) { got = append(got, deleted) },
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		want    []interface{}
+		wantErr bool
+	}{
+		{
+			name: "note rejected",
+			body: `{"type":"note_rejected","payload":{"userid":"u1","noteids":["n1"],"update_time":1767323045,"reason":"stale"}}`,
+			want: []interface{}{RejectedNotes{UserID: "u1", NoteIDs: []string{"n1"}, UpdateTime: 1767323045, Reason: "stale"}},
+		},
+		{
+			name: "attachments deleted",
+			body: `{"type":"attachments_deleted","payload":{"userid":"u1","storage_keys":["attachments/u1/a"],"bytes":42}}`,
+			want: []interface{}{DeletedAttachments{UserID: "u1", StorageKeys: []string{"attachments/u1/a"}, Bytes: 42}},
+		},
+		{name: "unknown type", body: `{"type":"note_update","payload":{}}`, wantErr: true},
+		{name: "invalid payload", body: `{"type":"attachments_deleted","payload":{"bytes":"many"}}`, wantErr: true},
+		{name: "not JSON", body: `note_rejected`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			err := handlers.handle([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handle() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handled %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
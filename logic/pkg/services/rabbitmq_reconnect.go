@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"pdm-logic-server/pkg/config"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// When the connection or its channel closes, say the broker restarts, the
+// publishes waiting for a confirmation fail, dispatches fail at once with
+// ErrDispatchUnavailable, and the connection is dialled again with a
+// doubling delay until it is back. InitRabbitMQ declares the queue again on
+// every connection.
+
+// errRabbitMQClosed is returned by connect after Close
+var errRabbitMQClosed = errors.New("rabbitmq connection closed")
+
+// connect dials the broker and opens a channel in confirm mode to publish
+// on, and watches them for a close
+func (c *RabbitMQCtx) connect() error {
+	conn, ch, err := config.InitRabbitMQ()
+	if err != nil {
+		return err
+	}
+	confirmer, err := newConfirmer(ch)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return errRabbitMQClosed
+	}
+	c.conn = conn
+	c.confirmer = confirmer
+	c.mu.Unlock()
+
+	go c.watchConnection(conn, ch)
+	return nil
+}
+
+// watchConnection waits for conn or ch to close and then reconnects
+func (c *RabbitMQCtx) watchConnection(conn *amqp.Connection, ch *amqp.Channel) {
+	var reason *amqp.Error
+	select {
+	case reason = <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+	case reason = <-ch.NotifyClose(make(chan *amqp.Error, 1)):
+	}
+
+	c.mu.Lock()
+	c.confirmer = nil
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+
+	// A channel can close on its own; start over on a fresh connection
+	conn.Close()
+	log.Printf("RabbitMQ connection lost: %v", reason)
+	c.reconnect()
+}
+
+func (c *RabbitMQCtx) reconnect() {
+	delay := c.cfg.ReconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+
+		err := c.connect()
+		if err == nil {
+			log.Printf("RabbitMQ reconnected after %d attempts", attempt)
+			return
+		}
+		if errors.Is(err, errRabbitMQClosed) {
+			return
+		}
+		log.Printf("Failed to reconnect to RabbitMQ (attempt %d): %v", attempt, err)
+		delay = min(delay*2, c.cfg.ReconnectMaxDelay)
+	}
+}
+
+// Close closes the connection for good
+func (c *RabbitMQCtx) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.confirmer = nil
+	close(c.done)
+	// Closed already if it was lost
+	if err := c.conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"pdm-logic-server/pkg/config"
+	"pdm-logic-server/pkg/models"
+	"strings"
+	"time"
+)
+
+// Delivery channels of a reminder, recorded per occurrence so a retried
+// firing does not repeat the ones that already went out
+const (
+	reminderChannelEmail = "email"
+	reminderChannelWS    = "ws"
+)
+
+const reminderSentTTL = 24 * time.Hour
+
+// ReminderScheduler fires due reminders by email and over the sync server's
+// WebSocket fan-out. Every instance may run one; a Redis lock per occurrence
+// makes sure only one of them fires it. An occurrence is only advanced once
+// all channels delivered, so failures are retried after the lock expires and
+// delivery is at-least-once.
+type ReminderScheduler struct {
+	storage *Storage
+	cfg     *config.ReminderConfig
+	email   *config.EmailConfig
+}
+
+func NewReminderScheduler(storage *Storage, cfg *config.Config) *ReminderScheduler {
+	return &ReminderScheduler{
+		storage: storage,
+		cfg:     &cfg.Reminders,
+		email:   &cfg.Email,
+	}
+}
+
+// Run polls for due reminders until ctx is cancelled
+func (r *ReminderScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	log.Printf("Reminder scheduler started, polling every %s", r.cfg.PollInterval)
+	for {
+		r.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("Reminder scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *ReminderScheduler) tick(ctx context.Context) {
+	now := time.Now()
+	reminders, err := r.storage.dueReminders(ctx, now, r.cfg.BatchSize)
+	if err != nil {
+		log.Printf("Failed to look up due reminders: %v", err)
+		return
+	}
+
+	for _, reminder := range reminders {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := r.fire(ctx, reminder, now); err != nil {
+			log.Printf("Failed to fire reminder %s: %v", reminder.ID, err)
+		}
+	}
+}
+
+func (r *ReminderScheduler) fire(ctx context.Context, reminder models.NoteReminder, now time.Time) error {
+	// The lock is left to expire rather than released, so a failed firing is
+	// retried no sooner than LockTTL later
+	locked, err := r.storage.Ch.SetNX(ctx, r.storage.Ch.Schema.ReminderLock(reminder.ID, reminder.FireAt), "1", r.cfg.LockTTL)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+
+	// The note may have been deleted or unshared since the reminder was set
+	access, err := r.storage.GetNoteAccess(ctx, reminder.NoteID, reminder.UserID)
+	if err != nil {
+		return err
+	}
+	if !access.CanRead() {
+		return r.storage.cancelReminder(ctx, reminder.ID)
+	}
+
+	var note models.Notes
+	if err := r.storage.DB.WithContext(ctx).Select("noteid", "heading").
+		Where("noteid = ?", reminder.NoteID).First(&note).Error; err != nil {
+		return err
+	}
+
+	sentKey := r.storage.Ch.Schema.ReminderSent(reminder.ID, reminder.FireAt)
+	sent, err := r.storage.Ch.HGetAll(ctx, sentKey)
+	if err != nil {
+		return err
+	}
+
+	if sent[reminderChannelEmail] == "" {
+		if err := r.sendEmail(ctx, reminder, note.Heading); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+		r.markSent(ctx, sentKey, reminderChannelEmail)
+	}
+
+	if sent[reminderChannelWS] == "" {
+		if err := r.storage.R.DispatchReminder(reminder, note.Heading); err != nil {
+			return fmt.Errorf("websocket: %w", err)
+		}
+		r.markSent(ctx, sentKey, reminderChannelWS)
+	}
+
+	return r.storage.advanceReminder(ctx, reminder, now)
+}
+
+func (r *ReminderScheduler) sendEmail(ctx context.Context, reminder models.NoteReminder, heading string) error {
+	userInfo, err := GetUserInfo(r.storage, ctx, reminder.UserID)
+	if err != nil {
+		return err
+	}
+
+	fireAt := reminder.FireAt
+	if loc, err := time.LoadLocation(reminder.Timezone); err == nil {
+		fireAt = fireAt.In(loc)
+	}
+
+	data := models.ReminderTemplateData{
+		Heading: heading,
+		FireAt:  fireAt,
+		NoteURL: strings.ReplaceAll(r.cfg.NoteURL, "{noteid}", url.PathEscape(reminder.NoteID)),
+	}
+	return SendReminderEmail(r.email.From, userInfo.Email, data, r.email.ApiKey)
+}
+
+func (r *ReminderScheduler) markSent(ctx context.Context, key, channel string) {
+	if err := r.storage.Ch.HSet(ctx, key, channel, "1"); err != nil {
+		log.Printf("Failed to record reminder delivery on %s: %v", key, err)
+		return
+	}
+	r.storage.Ch.Expire(ctx, key, reminderSentTTL)
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/rrule"
+	"pdm-logic-server/pkg/util"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrReminderNotFound  = errors.New("reminder not found")
+	ErrInvalidTimezone   = errors.New("unknown timezone")
+	ErrReminderInPast    = errors.New("reminder has no occurrence in the future")
+	ErrTooManyReminders  = errors.New("too many reminders on this note")
+	ErrInvalidRecurrence = rrule.ErrInvalidRule
+)
+
+const maxRemindersPerNote = 20
+
+// CreateReminder schedules a reminder for userID on a note they can read.
+// Without a recurrence rule req.At must be in the future; with one, the
+// first occurrence from now on is scheduled.
+func (s *Storage) CreateReminder(ctx context.Context, userID, noteID string, req models.CreateReminderRequest) (models.NoteReminder, error) {
+	var reminder models.NoteReminder
+
+	access, err := s.GetNoteAccess(ctx, noteID, userID)
+	if err != nil {
+		return reminder, err
+	}
+	if !access.CanRead() {
+		return reminder, ErrNoteNotFound
+	}
+
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		return reminder, fmt.Errorf("%w: %q", ErrInvalidTimezone, req.Timezone)
+	}
+
+	reminder = models.NoteReminder{
+		ID:       util.NewUUID(),
+		NoteID:   noteID,
+		UserID:   userID,
+		StartAt:  req.At.UTC(),
+		Timezone: req.Timezone,
+		RRule:    req.RRule,
+		Status:   models.ReminderScheduled,
+	}
+
+	next, ok, err := nextReminderTime(reminder, time.Now(), loc)
+	if err != nil {
+		return reminder, err
+	}
+	if !ok {
+		return reminder, ErrReminderInPast
+	}
+	reminder.FireAt = next.UTC()
+
+	var count int64
+	err = s.DB.WithContext(ctx).Model(&models.NoteReminder{}).
+		Where("noteid = ? AND userid = ? AND status = ?", noteID, userID, models.ReminderScheduled).
+		Count(&count).Error
+	if err != nil {
+		return reminder, err
+	}
+	if count >= maxRemindersPerNote {
+		return reminder, ErrTooManyReminders
+	}
+
+	return reminder, s.DB.WithContext(ctx).Create(&reminder).Error
+}
+
+// nextReminderTime returns the first occurrence of a reminder after the
+// given time, evaluating its recurrence rule in its own timezone
+func nextReminderTime(reminder models.NoteReminder, after time.Time, loc *time.Location) (time.Time, bool, error) {
+	start := reminder.StartAt.In(loc)
+	if reminder.RRule == "" {
+		return start, start.After(after), nil
+	}
+
+	rule, err := rrule.Parse(reminder.RRule)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	next, ok := rule.Next(start, after)
+	return next, ok, nil
+}
+
+// ListReminders returns the scheduled reminders of userID on a note
+func (s *Storage) ListReminders(ctx context.Context, userID, noteID string) ([]models.NoteReminder, error) {
+	reminders := []models.NoteReminder{}
+	err := s.DB.WithContext(ctx).
+		Where("noteid = ? AND userid = ? AND status = ?", noteID, userID, models.ReminderScheduled).
+		Order("fire_at").
+		Find(&reminders).Error
+	return reminders, err
+}
+
+// DeleteReminder cancels a reminder of userID
+func (s *Storage) DeleteReminder(ctx context.Context, userID, noteID, reminderID string) error {
+	result := s.DB.WithContext(ctx).Model(&models.NoteReminder{}).
+		Where("id = ? AND noteid = ? AND userid = ? AND status = ?", reminderID, noteID, userID, models.ReminderScheduled).
+		Update("status", models.ReminderCancelled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReminderNotFound
+	}
+	return nil
+}
+
+// dueReminders returns up to limit scheduled reminders whose time has come
+func (s *Storage) dueReminders(ctx context.Context, now time.Time, limit int) ([]models.NoteReminder, error) {
+	var reminders []models.NoteReminder
+	err := s.DB.WithContext(ctx).
+		Where("status = ? AND fire_at <= ?", models.ReminderScheduled, now).
+		Order("fire_at").
+		Limit(limit).
+		Find(&reminders).Error
+	return reminders, err
+}
+
+// advanceReminder moves a fired reminder to its next occurrence, or marks it
+// done when there is none. It only applies if the reminder is still at the
+// occurrence that was fired, so a firing is never recorded twice; if it is
+// not, the reminder is left alone.
+func (s *Storage) advanceReminder(ctx context.Context, reminder models.NoteReminder, now time.Time) error {
+	loc, err := time.LoadLocation(reminder.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	after := now
+	if reminder.FireAt.After(after) {
+		after = reminder.FireAt
+	}
+	next, ok, err := nextReminderTime(reminder, after, loc)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"occurrences":   gorm.Expr("occurrences + 1"),
+		"last_fired_at": now,
+	}
+	if ok {
+		updates["fire_at"] = next.UTC()
+	} else {
+		updates["status"] = models.ReminderDone
+	}
+
+	result := s.DB.WithContext(ctx).Model(&models.NoteReminder{}).
+		Where("id = ? AND fire_at = ? AND status = ?", reminder.ID, reminder.FireAt, models.ReminderScheduled).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Reminder %s was advanced, changed or cancelled meanwhile, skipping it", reminder.ID)
+	}
+	return nil
+}
+
+func (s *Storage) cancelReminder(ctx context.Context, reminderID string) error {
+	return s.DB.WithContext(ctx).Model(&models.NoteReminder{}).
+		Where("id = ?", reminderID).
+		Update("status", models.ReminderCancelled).Error
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pdm-logic-server/pkg/models"
+	"pdm-logic-server/pkg/util"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrShareWithSelf = errors.New("cannot share a note with its owner")
+	ErrShareNotFound = errors.New("share not found")
+)
+
+// ShareNote shares a note owned by ownerID with the registered user behind
+// req.Email. Sharing again with the same user replaces the permission and
+// key envelope.
+func (s *Storage) ShareNote(ctx context.Context, ownerID, noteID string, req models.ShareNoteRequest) (models.NoteShare, error) {
+	var share models.NoteShare
+
+	owned, err := s.NoteOwnedBy(ctx, noteID, ownerID)
+	if err != nil {
+		return share, err
+	}
+	if !owned {
+		return share, ErrNoteNotFound
+	}
+
+	var recipient models.User
+	err = s.DB.Select("id").
+		Where("email = ? AND registered = ?", strings.TrimSpace(req.Email), "1").
+		First(&recipient).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return share, ErrUserNotFound
+	}
+	if err != nil {
+		return share, err
+	}
+	if recipient.ID == ownerID {
+		return share, ErrShareWithSelf
+	}
+
+	err = s.DB.Where("noteid = ? AND shared_with_id = ?", noteID, recipient.ID).First(&share).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		share = models.NoteShare{
+			ID:           util.NewUUID(),
+			NoteID:       noteID,
+			OwnerID:      ownerID,
+			SharedWithID: recipient.ID,
+			Permission:   req.Permission,
+			KeyEnvelope:  req.KeyEnvelope,
+		}
+		return share, s.DB.Create(&share).Error
+	}
+	if err != nil {
+		return share, err
+	}
+
+	share.Permission = req.Permission
+	share.KeyEnvelope = req.KeyEnvelope
+	return share, s.DB.Save(&share).Error
+}
+
+// ListNoteShares lists who a note is shared with, for its owner.
+func (s *Storage) ListNoteShares(ctx context.Context, ownerID, noteID string) ([]models.NoteShareInfo, error) {
+	owned, err := s.NoteOwnedBy(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrNoteNotFound
+	}
+
+	shares := []models.NoteShareInfo{}
+	err = s.DB.Table("note_share").
+		Select("note_share.*, userinfo.email").
+		Joins("JOIN userinfo ON userinfo.id = note_share.shared_with_id").
+		Where("note_share.noteid = ? AND note_share.owner_id = ?", noteID, ownerID).
+		Order("note_share.created_at").
+		Scan(&shares).Error
+	return shares, err
+}
+
+// DeleteShare revokes a share. Both the owner and the recipient may remove it.
+func (s *Storage) DeleteShare(ctx context.Context, userID, noteID, shareID string) error {
+	result := s.DB.
+		Where("id = ? AND noteid = ? AND (owner_id = ? OR shared_with_id = ?)", shareID, noteID, userID, userID).
+		Delete(&models.NoteShare{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// SharedWithMe lists the notes other users have shared with userID.
+func (s *Storage) SharedWithMe(ctx context.Context, userID string) ([]models.SharedNote, error) {
+	var shares []models.NoteShare
+	if err := s.DB.Where("shared_with_id = ?", userID).Order("created_at").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+
+	shared := []models.SharedNote{}
+	if len(shares) == 0 {
+		return shared, nil
+	}
+
+	noteIDs := make([]string, 0, len(shares))
+	ownerIDs := make([]string, 0, len(shares))
+	for _, share := range shares {
+		noteIDs = append(noteIDs, share.NoteID)
+		ownerIDs = append(ownerIDs, share.OwnerID)
+	}
+
+	var notes []models.Notes
+	if err := s.DB.Where("noteid IN ?", noteIDs).Find(&notes).Error; err != nil {
+		return nil, err
+	}
+	notesByID := make(map[string]models.Notes, len(notes))
+	for _, note := range notes {
+		notesByID[note.NoteID] = note
+	}
+
+	var owners []models.User
+	if err := s.DB.Select("id", "email").Where("id IN ?", ownerIDs).Find(&owners).Error; err != nil {
+		return nil, err
+	}
+	emails := make(map[string]string, len(owners))
+	for _, owner := range owners {
+		emails[owner.ID] = owner.Email
+	}
+
+	for _, share := range shares {
+		note, ok := notesByID[share.NoteID]
+		if !ok {
+			continue
+		}
+		shared = append(shared, models.SharedNote{
+			Note:        note,
+			ShareID:     share.ID,
+			Permission:  share.Permission,
+			KeyEnvelope: share.KeyEnvelope,
+			OwnerEmail:  emails[share.OwnerID],
+		})
+	}
+
+	return shared, nil
+}
+
+// GetNoteAccess works out whether userID owns the note or has it shared with
+// them. A zero NoteAccess means no access, including for missing notes.
+func (s *Storage) GetNoteAccess(ctx context.Context, noteID, userID string) (models.NoteAccess, error) {
+	var note models.Notes
+	err := s.DB.Select("noteid", "userid").Where("noteid = ?", noteID).First(&note).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.NoteAccess{}, nil
+	}
+	if err != nil {
+		return models.NoteAccess{}, err
+	}
+
+	if note.UserID == userID {
+		return models.NoteAccess{OwnerID: note.UserID, Permission: models.PermissionOwner}, nil
+	}
+
+	var share models.NoteShare
+	err = s.DB.Where("noteid = ? AND shared_with_id = ?", noteID, userID).First(&share).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.NoteAccess{}, nil
+	}
+	if err != nil {
+		return models.NoteAccess{}, err
+	}
+
+	return models.NoteAccess{OwnerID: note.UserID, Permission: share.Permission}, nil
+}
+
+// noteParticipants returns the users that should be notified of changes to
+// the given notes: the owner followed by everyone the note is shared with.
+func (s *Storage) noteParticipants(ctx context.Context, ownerID string, noteIDs ...string) (map[string][]string, error) {
+	participants := make(map[string][]string, len(noteIDs))
+	for _, noteID := range noteIDs {
+		participants[noteID] = []string{ownerID}
+	}
+	if len(noteIDs) == 0 {
+		return participants, nil
+	}
+
+	var shares []models.NoteShare
+	err := s.DB.Select("noteid", "shared_with_id").Where("noteid IN ?", noteIDs).Find(&shares).Error
+	if err != nil {
+		return participants, err
+	}
+	for _, share := range shares {
+		participants[share.NoteID] = append(participants[share.NoteID], share.SharedWithID)
+	}
+	return participants, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"pdm-logic-server/pkg/util"
+	"time"
+)
+
+// When a hot cache entry expires, every request for it misses at once. Misses
+// are coalesced twice over: within an instance by singleflight, so one
+// goroutine rebuilds the entry for all of them, and across instances by a
+// lock in Redis, so one instance rebuilds it while the others wait for the
+// cache to fill. Entries that are rebuilt in the background before they
+// expire, see refreshDue, are never missed at all.
+
+// rebuildPoll is how often a miss waiting for another instance's rebuild
+// looks at the cache again
+const rebuildPoll = 50 * time.Millisecond
+
+// rebuildLock takes the lock for rebuilding key across instances, and
+// returns the func that releases it. It returns false if another instance
+// holds the lock; if Redis cannot tell, the caller goes ahead without it.
+func (s *Storage) rebuildLock(ctx context.Context, key string) (func(), bool) {
+	lockKey := s.Ch.Schema.RebuildLock(key)
+	token := util.NewUUID()
+	locked, err := s.Ch.SetNX(ctx, lockKey, token, s.Stampede.LockTTL)
+	if err != nil {
+		log.Printf("Failed to lock rebuild of %s: %v", key, err)
+		return func() {}, true
+	}
+	if !locked {
+		return nil, false
+	}
+
+	return func() {
+		// The lock may have expired and been taken by another instance
+		holder, err := s.Ch.Get(ctx, lockKey)
+		if err != nil || holder != token {
+			return
+		}
+		if err := s.Ch.Delete(ctx, lockKey); err != nil {
+			log.Printf("Failed to unlock rebuild of %s: %v", key, err)
+		}
+	}, true
+}
+
+// loadShared returns what load returns for key, with concurrent calls for
+// the same key coalesced: one call in the instance that holds the rebuild
+// lock runs load, which fills the cache. Calls in other instances wait for
+// cached to find the result, and run load themselves if it takes longer
+// than the configured wait.
+func loadShared[T any](ctx context.Context, s *Storage, key string, cached func() (T, bool), load func() (T, error)) (T, error) {
+	v, err, _ := s.flights.Do(key, func() (any, error) {
+		release, locked := s.rebuildLock(ctx, key)
+		if locked {
+			defer release()
+			return load()
+		}
+
+		deadline := time.Now().Add(s.Stampede.Wait)
+		for time.Now().Before(deadline) {
+			time.Sleep(rebuildPoll)
+			if v, ok := cached(); ok {
+				return v, nil
+			}
+		}
+		return load()
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return t, fmt.Errorf("rebuild of %s returned %T", key, v)
+	}
+	return t, nil
+}
+
+// refreshShared runs load for key in the background, unless this instance
+// is already refreshing it or another instance holds the rebuild lock.
+// Refreshes have flights of their own, so a miss never joins one and gets
+// nothing back.
+func (s *Storage) refreshShared(key string, load func(ctx context.Context) error) {
+	s.flights.DoChan("refresh:"+key, func() (any, error) {
+		ctx := context.Background()
+		release, locked := s.rebuildLock(ctx, key)
+		if !locked {
+			return nil, nil
+		}
+		defer release()
+
+		if err := load(ctx); err != nil {
+			log.Printf("Failed to refresh %s: %v", key, err)
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// refreshDue decides whether to rebuild an entry that was loaded at
+// loadedAt, and took took to load, ahead of its expiry after ttl. It is
+// the XFetch rule: the closer the expiry, and the longer a rebuild takes,
+// the likelier a refresh. Loads are taken to take at least RefreshWindow,
+// so users making a request every second or so find a fresh entry.
+func (s *Storage) refreshDue(loadedAt time.Time, took, ttl time.Duration) bool {
+	if s.Stampede.RefreshBeta <= 0 {
+		return false
+	}
+	delta := max(took, s.Stampede.RefreshWindow)
+	// 1-rand.Float64() is in (0, 1], so the log is finite
+	gap := -float64(delta) * s.Stampede.RefreshBeta * math.Log(1-rand.Float64())
+	return time.Now().Add(time.Duration(gap)).After(loadedAt.Add(ttl))
+}
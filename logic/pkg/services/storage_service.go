@@ -1,16 +1,24 @@
 package services
 
 import (
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
+	"pdm-logic-server/pkg/blob"
 	"pdm-logic-server/pkg/cache"
+	"pdm-logic-server/pkg/config"
 )
 
 type Storage struct {
-	DB *gorm.DB
-	R  *RabbitMQCtx
-	Ch *cache.RedisCache
+	DB       *gorm.DB
+	R        *RabbitMQCtx
+	Ch       *cache.RedisCache
+	Blob     blob.BlobStore
+	Stampede *config.StampedeConfig
+
+	// flights coalesces concurrent cache rebuilds in this instance
+	flights singleflight.Group
 }
 
-func NewStorage(db *gorm.DB, r *RabbitMQCtx, ch *cache.RedisCache) *Storage {
-	return &Storage{DB: db, R: r, Ch: ch}
+func NewStorage(db *gorm.DB, r *RabbitMQCtx, ch *cache.RedisCache, blobStore blob.BlobStore, stampede *config.StampedeConfig) *Storage {
+	return &Storage{DB: db, R: r, Ch: ch, Blob: blobStore, Stampede: stampede}
 }
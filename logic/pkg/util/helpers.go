@@ -1 +1,28 @@
 package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+)
+
+// NewUUID returns a random (version 4) UUID string, for rows whose ID has to
+// be known before the sync server writes them.
+func NewUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate uuid: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsUUID reports whether s is a canonically formatted UUID, so path
+// parameters can be rejected before they reach a uuid column.
+func IsUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
@@ -170,3 +170,287 @@ const EmailTemplate = `<!DOCTYPE html>
     </table>
 </body>
 </html>`
+
+const ExportReadyEmailTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="color-scheme" content="light dark">
+    <meta name="supported-color-schemes" content="light dark">
+    <title>Your PDM Notes export is ready</title>
+    <style>
+        body, table, td, p, a {
+            -webkit-text-size-adjust: 100%;
+            -ms-text-size-adjust: 100%;
+            margin: 0;
+            padding: 0;
+        }
+
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Helvetica, Arial, sans-serif;
+            line-height: 1.6;
+            color: #333333;
+            background-color: #f9f9f9;
+        }
+
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            padding: 20px;
+            background-color: #ffffff;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+
+        .header {
+            text-align: center;
+            padding: 20px 0;
+            border-bottom: 2px solid #f0f0f0;
+        }
+
+        .header h1 {
+            color: #2563eb;
+            font-size: 24px;
+            margin: 0;
+            padding: 0;
+        }
+
+        .content {
+            padding: 30px 20px;
+            background-color: #ffffff;
+        }
+
+        .button {
+            display: inline-block;
+            margin: 25px 0;
+            padding: 12px 24px;
+            background-color: #2563eb;
+            border-radius: 6px;
+            color: #ffffff !important;
+            font-weight: bold;
+            text-decoration: none;
+        }
+
+        .warning {
+            color: #6b7280;
+            font-size: 14px;
+            margin: 20px 0;
+            padding: 15px;
+            background-color: #fff9f9;
+            border-left: 4px solid #ef4444;
+            border-radius: 4px;
+        }
+
+        .footer {
+            text-align: center;
+            padding: 20px 0;
+            color: #6b7280;
+            font-size: 12px;
+            border-top: 1px solid #f0f0f0;
+            background-color: #f9fafb;
+        }
+
+        @media (prefers-color-scheme: dark) {
+            body {
+                background-color: #1f2937;
+                color: #f9fafb;
+            }
+            .container, .content {
+                background-color: #374151;
+            }
+            .warning {
+                background-color: #422424;
+                border-left-color: #dc2626;
+                color: #fca5a5;
+            }
+            .footer {
+                background-color: #374151;
+                color: #9ca3af;
+            }
+        }
+    </style>
+</head>
+<body>
+    <table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0">
+        <tr>
+            <td align="center" style="padding: 20px 0;">
+                <div class="container">
+                    <div class="header">
+                        <h1>Your export is ready</h1>
+                    </div>
+                    <div class="content">
+                        <p>Hello,</p>
+                        <p>The export of your PDM Notes account ({{.NoteCount}} notes) has finished.</p>
+
+                        <a class="button" href="{{.DownloadURL}}">Download archive</a>
+
+                        <div class="warning">
+                            <p>This link expires on {{.ExpiresAt.Format "Jan 2, 2006 15:04 MST"}}.</p>
+                            <p>Anyone with the link can download your notes, so do not forward this email.</p>
+                            <p>If you didn't request an export, please change your password.</p>
+                        </div>
+                    </div>
+                    <div class="footer">
+                        <p>PDM Notes - Secure Note-Taking Platform</p>
+                        <p>© 2024 PDM Notes. All rights reserved.</p>
+                        <p style="margin-top: 10px; font-size: 11px;">
+                            This is an automated message, please do not reply.
+                        </p>
+                    </div>
+                </div>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>`
+
+const ReminderEmailTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="color-scheme" content="light dark">
+    <meta name="supported-color-schemes" content="light dark">
+    <title>PDM Notes reminder</title>
+    <style>
+        body, table, td, p, a {
+            -webkit-text-size-adjust: 100%;
+            -ms-text-size-adjust: 100%;
+            margin: 0;
+            padding: 0;
+        }
+
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Helvetica, Arial, sans-serif;
+            line-height: 1.6;
+            color: #333333;
+            background-color: #f9f9f9;
+        }
+
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            padding: 20px;
+            background-color: #ffffff;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+
+        .header {
+            text-align: center;
+            padding: 20px 0;
+            border-bottom: 2px solid #f0f0f0;
+        }
+
+        .header h1 {
+            color: #2563eb;
+            font-size: 24px;
+            margin: 0;
+            padding: 0;
+        }
+
+        .content {
+            padding: 30px 20px;
+            background-color: #ffffff;
+        }
+
+        .button {
+            display: inline-block;
+            margin: 25px 0;
+            padding: 12px 24px;
+            background-color: #2563eb;
+            border-radius: 6px;
+            color: #ffffff !important;
+            font-weight: bold;
+            text-decoration: none;
+        }
+
+        .note-heading {
+            background-color: #f3f4f6;
+            border: 1px solid #e5e7eb;
+            border-radius: 8px;
+            padding: 20px;
+            margin: 25px 0;
+            font-size: 18px;
+            font-weight: bold;
+            color: #1f2937;
+        }
+
+        .warning {
+            color: #6b7280;
+            font-size: 14px;
+            margin: 20px 0;
+            padding: 15px;
+            background-color: #fff9f9;
+            border-left: 4px solid #ef4444;
+            border-radius: 4px;
+        }
+
+        .footer {
+            text-align: center;
+            padding: 20px 0;
+            color: #6b7280;
+            font-size: 12px;
+            border-top: 1px solid #f0f0f0;
+            background-color: #f9fafb;
+        }
+
+        @media (prefers-color-scheme: dark) {
+            body {
+                background-color: #1f2937;
+                color: #f9fafb;
+            }
+            .container, .content {
+                background-color: #374151;
+            }
+            .note-heading {
+                background-color: #4b5563;
+                border-color: #6b7280;
+                color: #f9fafb;
+            }
+            .warning {
+                background-color: #422424;
+                border-left-color: #dc2626;
+                color: #fca5a5;
+            }
+            .footer {
+                background-color: #374151;
+                color: #9ca3af;
+            }
+        }
+    </style>
+</head>
+<body>
+    <table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0">
+        <tr>
+            <td align="center" style="padding: 20px 0;">
+                <div class="container">
+                    <div class="header">
+                        <h1>Reminder</h1>
+                    </div>
+                    <div class="content">
+                        <p>Hello,</p>
+                        <p>You asked to be reminded about this note on {{.FireAt.Format "Mon, Jan 2, 2006 15:04 MST"}}:</p>
+
+                        <div class="note-heading">{{.Heading}}</div>
+
+                        {{if .NoteURL}}<a class="button" href="{{.NoteURL}}">Open PDM Notes</a>{{end}}
+
+                        <div class="warning">
+                            <p>You can change or remove this reminder from the note at any time.</p>
+                        </div>
+                    </div>
+                    <div class="footer">
+                        <p>PDM Notes - Secure Note-Taking Platform</p>
+                        <p>© 2024 PDM Notes. All rights reserved.</p>
+                        <p style="margin-top: 10px; font-size: 11px;">
+                            This is an automated message, please do not reply.
+                        </p>
+                    </div>
+                </div>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>`
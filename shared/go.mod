@@ -0,0 +1,3 @@
+module pdm-shared
+
+go 1.22
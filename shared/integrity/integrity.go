@@ -0,0 +1,94 @@
+// Package integrity computes and verifies the digests a note carries: h over
+// its content and intgrh over its heading.
+//
+// Digests are versioned as "v<N>:<digest>". Version 1, the current one, is
+//
+//	h      = "v1:" + lowercase hex(SHA-256(UTF-8 bytes of content))
+//	intgrh = "v1:" + lowercase hex(SHA-256(UTF-8 bytes of heading))
+//
+// Digests without a version prefix, including empty ones, were written
+// before the server verified anything. They cannot be checked and are
+// reported as legacy; whether to accept them is up to the caller.
+//
+// Both the logic server and the sync server verify notes with this package.
+package integrity
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CurrentVersion is the version Compute produces
+const CurrentVersion = 1
+
+var (
+	ErrLegacy             = errors.New("digest has no version")
+	ErrUnsupportedVersion = errors.New("unsupported digest version")
+	ErrContentMismatch    = errors.New("content digest h does not match")
+	ErrHeadingMismatch    = errors.New("heading digest intgrh does not match")
+)
+
+// Compute returns the current version digests of a note
+func Compute(heading, content string) (h, intgrh string) {
+	return digest(CurrentVersion, content), digest(CurrentVersion, heading)
+}
+
+// Verify checks both digests of a note. Every versioned digest is checked
+// and the first failure returned, so an unversioned digest cannot hide a
+// mismatch of the other; only when nothing failed is ErrLegacy returned for
+// an unversioned one. It returns nil when both verify.
+func Verify(heading, content, h, intgrh string) error {
+	contentErr := verifyOne(content, h, ErrContentMismatch)
+	headingErr := verifyOne(heading, intgrh, ErrHeadingMismatch)
+	for _, err := range []error{contentErr, headingErr} {
+		if err != nil && !errors.Is(err, ErrLegacy) {
+			return err
+		}
+	}
+	if contentErr != nil {
+		return contentErr
+	}
+	return headingErr
+}
+
+func verifyOne(value, stored string, mismatch error) error {
+	version, ok := parseVersion(stored)
+	if !ok {
+		return ErrLegacy
+	}
+	if version != CurrentVersion {
+		return fmt.Errorf("%w: v%d", ErrUnsupportedVersion, version)
+	}
+
+	expected := digest(version, value)
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(stored)), []byte(expected)) != 1 {
+		return mismatch
+	}
+	return nil
+}
+
+func digest(version int, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("v%d:%s", version, hex.EncodeToString(sum[:]))
+}
+
+// parseVersion reads the version of a "v<N>:" prefixed digest
+func parseVersion(stored string) (int, bool) {
+	prefix, _, ok := strings.Cut(stored, ":")
+	if !ok || len(prefix) < 2 || len(prefix) > 6 || (prefix[0] != 'v' && prefix[0] != 'V') {
+		return 0, false
+	}
+
+	version := 0
+	for _, c := range prefix[1:] {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		version = version*10 + int(c-'0')
+	}
+	return version, true
+}
@@ -0,0 +1,60 @@
+package integrity
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	h, intgrh := Compute("title", "")
+	// SHA-256 of the empty string
+	if want := "v1:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"; h != want {
+		t.Errorf("Compute() h = %q, want %q", h, want)
+	}
+	if !strings.HasPrefix(intgrh, "v1:") || len(intgrh) != len("v1:")+64 {
+		t.Errorf("Compute() intgrh = %q, want a v1 SHA-256 digest", intgrh)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const heading, content = "title", "body"
+	h, intgrh := Compute(heading, content)
+	otherH, otherIntgrh := Compute("other", "other")
+
+	tests := []struct {
+		name   string
+		h      string
+		intgrh string
+		want   error
+	}{
+		{name: "both match", h: h, intgrh: intgrh},
+		{name: "digests are case-insensitive", h: strings.ToUpper(h), intgrh: intgrh},
+		{name: "content mismatch", h: otherH, intgrh: intgrh, want: ErrContentMismatch},
+		{name: "heading mismatch", h: h, intgrh: otherIntgrh, want: ErrHeadingMismatch},
+		{name: "both mismatch", h: otherH, intgrh: otherIntgrh, want: ErrContentMismatch},
+		{name: "both legacy", h: "abc", intgrh: "", want: ErrLegacy},
+		{name: "legacy content", h: "abc", intgrh: intgrh, want: ErrLegacy},
+		{name: "legacy heading", h: h, intgrh: "", want: ErrLegacy},
+		{name: "legacy content does not hide a heading mismatch", h: "", intgrh: otherIntgrh, want: ErrHeadingMismatch},
+		{name: "legacy heading does not hide a content mismatch", h: otherH, intgrh: "", want: ErrContentMismatch},
+		{name: "unsupported version", h: "v2:" + h[3:], intgrh: intgrh, want: ErrUnsupportedVersion},
+		{name: "unsupported version with legacy heading", h: "v2:" + h[3:], intgrh: "", want: ErrUnsupportedVersion},
+		{name: "malformed prefix is legacy", h: "vx:" + h[3:], intgrh: intgrh, want: ErrLegacy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(heading, content, tt.h, tt.intgrh)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Verify() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package patch applies the partial note updates sent with PATCH
+// /api/notes/:id. Two formats are understood:
+//
+// "text" is a list of edits to the content, applied in order. Each edit
+// deletes Delete characters at Pos and inserts Insert there; positions and
+// lengths count Unicode code points in the content as left by the previous
+// edit.
+//
+//	[{"pos": 120, "delete": 3, "insert": "new"}]
+//
+// "json-patch" is an RFC 6902 patch against the note as the document
+// {"content": ..., "heading": ..., "deleted": ...}. The members cannot be
+// removed, so only the add, replace and test operations are supported.
+//
+// The logic server checks a patch and the sync server applies it to the
+// stored note, so both use this package.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Patch formats
+const (
+	FormatText      = "text"
+	FormatJSONPatch = "json-patch"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrTestFailed   = errors.New("patch test operation failed")
+)
+
+// Document is the part of a note a patch can change
+type Document struct {
+	Content string `json:"content"`
+	Heading string `json:"heading"`
+	Deleted int    `json:"deleted"`
+}
+
+// TextEdit is one edit of a text patch
+type TextEdit struct {
+	Pos    int    `json:"pos"`
+	Delete int    `json:"delete"`
+	Insert string `json:"insert"`
+}
+
+// Operation is one operation of a JSON Patch
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply returns doc with the patch applied
+func Apply(doc Document, format string, raw json.RawMessage) (Document, error) {
+	switch format {
+	case FormatText:
+		var edits []TextEdit
+		if err := json.Unmarshal(raw, &edits); err != nil {
+			return doc, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		content, err := ApplyText(doc.Content, edits)
+		if err != nil {
+			return doc, err
+		}
+		doc.Content = content
+		return doc, nil
+
+	case FormatJSONPatch:
+		var ops []Operation
+		if err := json.Unmarshal(raw, &ops); err != nil {
+			return doc, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		return ApplyJSONPatch(doc, ops)
+
+	default:
+		return doc, fmt.Errorf("%w: unknown format %q", ErrInvalidPatch, format)
+	}
+}
+
+// ApplyText applies text edits to s in order
+func ApplyText(s string, edits []TextEdit) (string, error) {
+	text := []rune(s)
+	for i, edit := range edits {
+		if edit.Pos < 0 || edit.Delete < 0 || edit.Pos+edit.Delete > len(text) {
+			return s, fmt.Errorf("%w: edit %d is out of range", ErrInvalidPatch, i)
+		}
+
+		insert := []rune(edit.Insert)
+		next := make([]rune, 0, len(text)-edit.Delete+len(insert))
+		next = append(next, text[:edit.Pos]...)
+		next = append(next, insert...)
+		next = append(next, text[edit.Pos+edit.Delete:]...)
+		text = next
+	}
+	return string(text), nil
+}
+
+// ApplyJSONPatch applies JSON Patch operations to doc in order. Like RFC
+// 6902 requires, nothing is applied if any operation fails.
+func ApplyJSONPatch(doc Document, ops []Operation) (Document, error) {
+	result := doc
+	for i, op := range ops {
+		if op.Op != "add" && op.Op != "replace" && op.Op != "test" {
+			return doc, fmt.Errorf("%w: operation %d: unsupported op %q", ErrInvalidPatch, i, op.Op)
+		}
+		if len(op.Value) == 0 {
+			return doc, fmt.Errorf("%w: operation %d: missing value", ErrInvalidPatch, i)
+		}
+
+		var err error
+		switch op.Path {
+		case "/content":
+			err = applyMember(&result.Content, op)
+		case "/heading":
+			err = applyMember(&result.Heading, op)
+		case "/deleted":
+			err = applyMember(&result.Deleted, op)
+		default:
+			err = fmt.Errorf("%w: unsupported path %q", ErrInvalidPatch, op.Path)
+		}
+		if err != nil {
+			return doc, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return result, nil
+}
+
+func applyMember[T comparable](member *T, op Operation) error {
+	var value T
+	if err := json.Unmarshal(op.Value, &value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	if op.Op == "test" {
+		if *member != value {
+			return fmt.Errorf("%w on %s", ErrTestFailed, op.Path)
+		}
+		return nil
+	}
+	*member = value
+	return nil
+}
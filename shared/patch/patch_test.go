@@ -0,0 +1,131 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApplyText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		edits   []TextEdit
+		want    string
+		wantErr error
+	}{
+		{name: "no edits", content: "hello", want: "hello"},
+		{name: "insert", content: "hello", edits: []TextEdit{{Pos: 5, Insert: " world"}}, want: "hello world"},
+		{name: "delete", content: "hello world", edits: []TextEdit{{Pos: 5, Delete: 6}}, want: "hello"},
+		{name: "replace", content: "hello world", edits: []TextEdit{{Pos: 6, Delete: 5, Insert: "there"}}, want: "hello there"},
+		{
+			name:    "edits see the result of earlier ones",
+			content: "abc",
+			edits:   []TextEdit{{Pos: 0, Delete: 1}, {Pos: 0, Delete: 1, Insert: "x"}},
+			want:    "xc",
+		},
+		{name: "positions count code points", content: "héllo", edits: []TextEdit{{Pos: 1, Delete: 1, Insert: "e"}}, want: "hello"},
+		{name: "past the end", content: "abc", edits: []TextEdit{{Pos: 2, Delete: 2}}, wantErr: ErrInvalidPatch},
+		{name: "negative position", content: "abc", edits: []TextEdit{{Pos: -1}}, wantErr: ErrInvalidPatch},
+		{name: "negative delete", content: "abc", edits: []TextEdit{{Pos: 1, Delete: -1}}, wantErr: ErrInvalidPatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyText(tt.content, tt.edits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ApplyText() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if got != tt.content {
+					t.Errorf("ApplyText() = %q after an error, want the content unchanged", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ApplyText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := Document{Content: "body", Heading: "title", Deleted: 1}
+
+	tests := []struct {
+		name    string
+		format  string
+		patch   string
+		want    Document
+		wantErr error
+	}{
+		{
+			name:   "text",
+			format: FormatText,
+			patch:  `[{"pos": 4, "insert": "!"}]`,
+			want:   Document{Content: "body!", Heading: "title", Deleted: 1},
+		},
+		{
+			name:   "json-patch replace",
+			format: FormatJSONPatch,
+			patch:  `[{"op": "replace", "path": "/heading", "value": "new"}, {"op": "add", "path": "/deleted", "value": 0}]`,
+			want:   Document{Content: "body", Heading: "new", Deleted: 0},
+		},
+		{
+			name:   "json-patch test passes",
+			format: FormatJSONPatch,
+			patch:  `[{"op": "test", "path": "/content", "value": "body"}, {"op": "replace", "path": "/content", "value": "x"}]`,
+			want:   Document{Content: "x", Heading: "title", Deleted: 1},
+		},
+		{
+			name:    "json-patch test fails",
+			format:  FormatJSONPatch,
+			patch:   `[{"op": "replace", "path": "/content", "value": "x"}, {"op": "test", "path": "/heading", "value": "other"}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:    "json-patch remove",
+			format:  FormatJSONPatch,
+			patch:   `[{"op": "remove", "path": "/content"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "json-patch unknown path",
+			format:  FormatJSONPatch,
+			patch:   `[{"op": "replace", "path": "/owner", "value": "x"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "json-patch missing value",
+			format:  FormatJSONPatch,
+			patch:   `[{"op": "replace", "path": "/content"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "json-patch wrong type",
+			format:  FormatJSONPatch,
+			patch:   `[{"op": "replace", "path": "/deleted", "value": "yes"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{name: "malformed", format: FormatText, patch: `{"pos": 1}`, wantErr: ErrInvalidPatch},
+		{name: "unknown format", format: "diff", patch: `[]`, wantErr: ErrInvalidPatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply(doc, tt.format, json.RawMessage(tt.patch))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if got != doc {
+					t.Errorf("Apply() = %+v after an error, want the document unchanged", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Apply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
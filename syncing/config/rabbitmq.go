@@ -1,56 +1,266 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/streadway/amqp"
 )
 
-func InitRabbitMQ() (*amqp.Connection, *amqp.Channel, error) {
+const (
+	// LogicToSyncQueue carries the tasks of the logic server
+	LogicToSyncQueue = "logic_to_sync"
+	// NotesExchange fans saved notes out to the WebSocket clients of a user
+	NotesExchange = "notes_exchange"
+	// DeadLetterQueue keeps the tasks that failed for good, with the reason
+	// in their headers
+	DeadLetterQueue = "logic_to_sync.dead"
+	// SyncToLogicQueue tells the logic server about the note writes that were
+	// dead-lettered, so it can drop them from its cache
+	SyncToLogicQueue = "sync_to_logic"
+)
+
+// RetryPolicy says how often a failed task of the logic server is tried
+// again before it is dead-lettered
+type RetryPolicy struct {
+	MaxAttempts int           // Deliveries of a task before it is dead-lettered
+	BaseDelay   time.Duration // Delay of the first retry, doubled for each next one
+	MaxDelay    time.Duration // Longest delay of a retry
+}
+
+// Delay returns how long the nth retry of a task waits, at most MaxDelay
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// ErrRabbitMQUnavailable is returned by Channel while the connection is
+// being restored
+var ErrRabbitMQUnavailable = errors.New("rabbitmq is reconnecting")
+
+// RabbitMQ holds the connection to the broker and one channel on it. When
+// either closes, say the broker restarts, it dials again with a doubling
+// delay, from RABBITMQ_RECONNECT_MIN_DELAY (500ms) up to
+// RABBITMQ_RECONNECT_MAX_DELAY (30s), and declares the topology again.
+// Consumers find the new channel with Wait.
+type RabbitMQ struct {
+	url      string
+	minDelay time.Duration
+	maxDelay time.Duration
+	Retry    RetryPolicy
+
+	mu     sync.RWMutex
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	ready  chan struct{} // closed once ch is set
+	closed bool
+	done   chan struct{}
+}
+
+func InitRabbitMQ() (*RabbitMQ, error) {
 
 	rabbitUser := os.Getenv("RABBITMQ_USER")
 	rabbitPass := os.Getenv("RABBITMQ_PASSWORD")
 	rabbitHost := os.Getenv("RABBITMQ_HOST")
 	rabbitPort := os.Getenv("RABBITMQ_PORT")
 
+	r := &RabbitMQ{
+		url:      fmt.Sprintf("amqp://%s:%s@%s:%s/", rabbitUser, rabbitPass, rabbitHost, rabbitPort),
+		minDelay: durationEnv("RABBITMQ_RECONNECT_MIN_DELAY", 500*time.Millisecond),
+		maxDelay: durationEnv("RABBITMQ_RECONNECT_MAX_DELAY", 30*time.Second),
+		Retry: RetryPolicy{
+			MaxAttempts: max(intEnv("SYNC_RETRY_MAX_ATTEMPTS", 5), 1),
+			BaseDelay:   durationEnv("SYNC_RETRY_BASE_DELAY", time.Second),
+			MaxDelay:    durationEnv("SYNC_RETRY_MAX_DELAY", 30*time.Second),
+		},
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	log.Println("Connected to RabbitMQ successfully!")
+	return r, nil
+}
+
+// declareTopology declares the queues and exchanges both servers use, and
+// the dead-letter queue of the sync server
+func declareTopology(ch *amqp.Channel) error {
+	for _, queue := range []string{"task_queue", LogicToSyncQueue, DeadLetterQueue, SyncToLogicQueue} {
+		_, err := ch.QueueDeclare(
+			queue, // Queue name
+			true,  // Durable
+			false, // Delete when unused
+			false, // Exclusive
+			false, // No-wait
+			nil,   // Arguments
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ch.ExchangeDeclare(
+		NotesExchange,
+		"topic",
+		true,  // Durable
+		false, // Auto-deleted
+		false, // Internal
+		false, // No-wait
+		nil,
+	)
+}
+
+func (r *RabbitMQ) connect() error {
 	// Connect to RabbitMQ
-	rabbitmqUrl := fmt.Sprintf("amqp://%s:%s@%s:%s/", rabbitUser, rabbitPass, rabbitHost, rabbitPort)
-	conn, err := amqp.Dial(rabbitmqUrl)
+	conn, err := amqp.Dial(r.url)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
 	// Open a channel
 	ch, err := conn.Channel()
 	if err != nil {
-		return nil, nil, err
+		conn.Close()
+		return err
 	}
 
-	// Declare a queue
-	_, err = ch.QueueDeclare(
-		"task_queue", // Queue name
-		true,         // Durable
-		false,        // Delete when unused
-		false,        // Exclusive
-		false,        // No-wait
-		nil,          // Arguments
-	)
-	// Declare the queue
-	_, err = ch.QueueDeclare(
-		"logic_to_sync", // Queue name
-		true,            // Durable
-		false,           // Delete when unused
-		false,           // Exclusive
-		false,           // No-wait
-		nil,             // Arguments
-	)
+	if err := declareTopology(ch); err != nil {
+		conn.Close()
+		return err
+	}
 
-	if err != nil {
-		return nil, nil, err
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		conn.Close()
+		return nil
 	}
+	r.conn = conn
+	r.ch = ch
+	close(r.ready)
+	r.mu.Unlock()
 
-	log.Println("Connected to RabbitMQ successfully!")
-	return conn, ch, nil
+	go r.watch(conn, ch)
+	return nil
+}
+
+// watch waits for conn or ch to close and then reconnects
+func (r *RabbitMQ) watch(conn *amqp.Connection, ch *amqp.Channel) {
+	var reason *amqp.Error
+	select {
+	case reason = <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+	case reason = <-ch.NotifyClose(make(chan *amqp.Error, 1)):
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.ch = nil
+	r.ready = make(chan struct{})
+	r.mu.Unlock()
+
+	// A channel can close on its own; start over on a fresh connection
+	conn.Close()
+	log.Printf("RabbitMQ connection lost: %v", reason)
+
+	delay := r.minDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+
+		err := r.connect()
+		if err == nil {
+			log.Printf("RabbitMQ reconnected after %d attempts", attempt)
+			return
+		}
+		log.Printf("Failed to reconnect to RabbitMQ (attempt %d): %v", attempt, err)
+		delay = min(delay*2, r.maxDelay)
+	}
+}
+
+// Channel returns the current channel, or ErrRabbitMQUnavailable while
+// reconnecting
+func (r *RabbitMQ) Channel() (*amqp.Channel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.ch == nil {
+		return nil, ErrRabbitMQUnavailable
+	}
+	return r.ch, nil
+}
+
+// NewChannel opens a channel of its own on the current connection, for a
+// consumer that sets channel-wide options such as prefetch or confirms. It
+// closes with the connection.
+func (r *RabbitMQ) NewChannel() (*amqp.Channel, error) {
+	r.mu.RLock()
+	conn, ch := r.conn, r.ch
+	r.mu.RUnlock()
+	if ch == nil {
+		return nil, ErrRabbitMQUnavailable
+	}
+	return conn.Channel()
+}
+
+// Wait returns the current channel as soon as there is one. It returns nil
+// once stop is closed or the connection is closed for good.
+func (r *RabbitMQ) Wait(stop <-chan struct{}) *amqp.Channel {
+	for {
+		r.mu.RLock()
+		ch, ready := r.ch, r.ready
+		r.mu.RUnlock()
+		if ch != nil {
+			return ch
+		}
+
+		select {
+		case <-ready:
+		case <-stop:
+			return nil
+		case <-r.done:
+			return nil
+		}
+	}
+}
+
+// Close closes the connection for good
+func (r *RabbitMQ) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.ch = nil
+	close(r.done)
+	r.conn.Close()
+}
+
+func intEnv(name string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func durationEnv(name string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return value
+	}
+	return defaultValue
 }
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 100, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: 500 * time.Millisecond},
+		{retry: 2, want: time.Second},
+		{retry: 3, want: 2 * time.Second},
+		{retry: 4, want: 4 * time.Second},
+		{retry: 7, want: 30 * time.Second},
+		{retry: 8, want: 30 * time.Second},
+		// A plain shift would have overflowed into a negative delay
+		{retry: 64, want: 30 * time.Second},
+		{retry: 1000, want: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Delay(tt.retry); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelayBaseAboveMax(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Second}
+	for _, retry := range []int{1, 2, 10} {
+		if got := policy.Delay(retry); got != 10*time.Second {
+			t.Errorf("Delay(%d) = %v, want %v", retry, got, 10*time.Second)
+		}
+	}
+}
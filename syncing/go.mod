@@ -9,6 +9,7 @@ require (
 	github.com/streadway/amqp v1.1.0
 	gorm.io/driver/postgres v1.5.10
 	gorm.io/gorm v1.25.12
+	pdm-shared v0.0.0
 )
 
 require (
@@ -22,3 +23,5 @@ require (
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
+
+replace pdm-shared => ../shared
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"syncing/config"
+	"syncing/models"
+
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A note deleted for good takes its attachments with it. Their rows are
+// deleted in the same transaction as the note; the files are in the logic
+// server's blob store and their bytes in its quota counters, so once the
+// delete is committed the logic server is sent an "attachments_deleted"
+// notice on the sync_to_logic queue, per user, to remove the files and give
+// the bytes back.
+
+// deletedAttachments is the payload of an "attachments_deleted" notice
+type deletedAttachments struct {
+	UserID      string   `json:"userid"`
+	StorageKeys []string `json:"storage_keys"`
+	Bytes       int64    `json:"bytes"`
+}
+
+// deleteNoteAttachments deletes the attachment rows of a note and returns
+// them
+func deleteNoteAttachments(tx *gorm.DB, noteID string) ([]models.NoteAttachment, error) {
+	var attachments []models.NoteAttachment
+	err := tx.Clauses(clause.Returning{}).
+		Where("noteid = ?", noteID).
+		Delete(&attachments).Error
+	return attachments, err
+}
+
+// groupDeletedAttachments groups attachments into one notice per user, in
+// order of first appearance
+func groupDeletedAttachments(attachments []models.NoteAttachment) []deletedAttachments {
+	var notices []deletedAttachments
+	index := make(map[string]int)
+	for _, attachment := range attachments {
+		i, ok := index[attachment.UserID]
+		if !ok {
+			i = len(notices)
+			index[attachment.UserID] = i
+			notices = append(notices, deletedAttachments{UserID: attachment.UserID})
+		}
+		notices[i].StorageKeys = append(notices[i].StorageKeys, attachment.StorageKey)
+		notices[i].Bytes += attachment.Size
+	}
+	return notices
+}
+
+// notifyAttachmentsDeleted tells the logic server about attachments deleted
+// with their notes. If a notice is lost, the files stay behind and are
+// logged, and the quota counter is corrected when it next expires.
+func (h *SyncHandler) notifyAttachmentsDeleted(attachments []models.NoteAttachment) {
+	for _, deleted := range groupDeletedAttachments(attachments) {
+		body, err := json.Marshal(map[string]interface{}{
+			"type":    "attachments_deleted",
+			"payload": deleted,
+		})
+		if err == nil {
+			var ch *amqp.Channel
+			if ch, err = h.RabbitMQ.Channel(); err == nil {
+				err = ch.Publish(
+					"",                      // Exchange
+					config.SyncToLogicQueue, // Routing key (queue name)
+					false,                   // Mandatory
+					false,                   // Immediate
+					amqp.Publishing{
+						ContentType:  "application/json",
+						DeliveryMode: amqp.Persistent,
+						Body:         body,
+					},
+				)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to notify the logic server of deleted attachments %v of user %s: %v", deleted.StorageKeys, deleted.UserID, err)
+		}
+	}
+}
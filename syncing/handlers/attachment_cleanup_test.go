@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"reflect"
+	"syncing/models"
+	"testing"
+)
+
+func TestGroupDeletedAttachments(t *testing.T) {
+	tests := []struct {
+		name        string
+		attachments []models.NoteAttachment
+		want        []deletedAttachments
+	}{
+		{name: "none"},
+		{
+			name:        "one",
+			attachments: []models.NoteAttachment{{UserID: "u1", StorageKey: "attachments/u1/a", Size: 10}},
+			want:        []deletedAttachments{{UserID: "u1", StorageKeys: []string{"attachments/u1/a"}, Bytes: 10}},
+		},
+		{
+			name: "several users",
+			attachments: []models.NoteAttachment{
+				{UserID: "u2", StorageKey: "attachments/u2/a", Size: 1},
+				{UserID: "u1", StorageKey: "attachments/u1/b", Size: 2},
+				{UserID: "u2", StorageKey: "attachments/u2/c", Size: 4},
+			},
+			want: []deletedAttachments{
+				{UserID: "u2", StorageKeys: []string{"attachments/u2/a", "attachments/u2/c"}, Bytes: 5},
+				{UserID: "u1", StorageKeys: []string{"attachments/u1/b"}, Bytes: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := groupDeletedAttachments(tt.attachments); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupDeletedAttachments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"syncing/links"
+	"syncing/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// declaredLinks reads the links a client declared with an update. ok is false
+// when the payload has none, in which case earlier declared links are kept.
+func declaredLinks(payload map[string]interface{}) (ids []string, ok bool) {
+	if _, ok := payload["links"]; !ok {
+		return nil, false
+	}
+	return links.Normalize(stringSlice(payload["links"])), true
+}
+
+// updateNoteLinks re-derives the outgoing links of a saved note: the ones
+// parsed from its content are always replaced, declared ones only when the
+// update carried them
+func updateNoteLinks(tx *gorm.DB, note models.Notes, declared []string, replaceDeclared bool) error {
+	origins := []string{models.LinkParsed}
+	if replaceDeclared {
+		origins = append(origins, models.LinkDeclared)
+	}
+	if err := tx.Where("source_id = ? AND origin IN ?", note.NoteID, origins).Delete(&models.NoteLink{}).Error; err != nil {
+		return err
+	}
+
+	var rows []models.NoteLink
+	add := func(ids []string, origin string) {
+		for _, id := range ids {
+			if id != note.NoteID {
+				rows = append(rows, models.NoteLink{SourceID: note.NoteID, TargetID: id, UserID: note.UserID, Origin: origin})
+			}
+		}
+	}
+	if replaceDeclared {
+		add(declared, models.LinkDeclared)
+	}
+	add(links.Parse(note.Content), models.LinkParsed)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// A link both declared and parsed is kept once, as declared
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// deleteNoteLinks drops every link from or to a permanently deleted note
+func deleteNoteLinks(tx *gorm.DB, noteID string) error {
+	return tx.Where("source_id = ? OR target_id = ?", noteID, noteID).Delete(&models.NoteLink{}).Error
+}
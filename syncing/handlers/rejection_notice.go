@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"syncing/config"
+
+	"github.com/streadway/amqp"
+)
+
+// The logic server caches a note write as soon as it is queued, as a pending
+// entry that is kept until the write reaches the database. A write that is
+// dead-lettered never does, so the sync server tells the logic server about
+// it on the sync_to_logic queue, and the pending entry is dropped rather than
+// served until it expires. So does a write skipped because a newer one is
+// stored.
+
+// rejectedNotes is the payload of a "note_rejected" notice: the notes of a
+// dead-lettered write and the update time it carried
+type rejectedNotes struct {
+	UserID     string   `json:"userid"`
+	NoteIDs    []string `json:"noteids"`
+	UpdateTime int64    `json:"update_time"`
+	Reason     string   `json:"reason"`
+}
+
+// rejectedNotesOf returns the notes written by the task in body, if it is a
+// note write
+func rejectedNotesOf(body []byte) (rejectedNotes, bool) {
+	var message struct {
+		Type    string                 `json:"type"`
+		Payload map[string]interface{} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return rejectedNotes{}, false
+	}
+
+	var rejected rejectedNotes
+	if updateTime, ok := message.Payload["update_time"].(float64); ok {
+		rejected.UpdateTime = int64(updateTime)
+	}
+	switch message.Type {
+	case "note_update", "note_patch":
+		rejected.UserID, _ = message.Payload["userid"].(string)
+		if noteID, ok := message.Payload["noteid"].(string); ok {
+			rejected.NoteIDs = []string{noteID}
+		}
+	case "note_batch":
+		rejected.UserID, _ = message.Payload["userId"].(string)
+		operations, _ := message.Payload["operations"].([]interface{})
+		for _, raw := range operations {
+			op, _ := raw.(map[string]interface{})
+			if noteID, ok := op["noteid"].(string); ok {
+				rejected.NoteIDs = append(rejected.NoteIDs, noteID)
+			}
+		}
+	default:
+		return rejectedNotes{}, false
+	}
+	return rejected, rejected.UserID != "" && len(rejected.NoteIDs) > 0
+}
+
+// notifyRejected publishes a "note_rejected" notice for the note write in
+// msg on ch, in confirm mode, and waits for the broker to confirm it. Other
+// tasks are left alone.
+func (h *SyncHandler) notifyRejected(ch *amqp.Channel, confirms <-chan amqp.Confirmation, msg amqp.Delivery, reason string) {
+	rejected, ok := rejectedNotesOf(msg.Body)
+	if !ok {
+		return
+	}
+	rejected.Reason = reason
+	publishRejected(ch, confirms, rejected)
+}
+
+// notifySkipped publishes a "note_rejected" notice for the notes of the note
+// write in msg that were skipped as stale
+func (h *SyncHandler) notifySkipped(ch *amqp.Channel, confirms <-chan amqp.Confirmation, msg amqp.Delivery, stale *staleTask) {
+	rejected, ok := rejectedNotesOf(msg.Body)
+	if !ok {
+		return
+	}
+	rejected.NoteIDs = stale.noteIDs
+	rejected.Reason = stale.Error()
+	publishRejected(ch, confirms, rejected)
+}
+
+// publishRejected publishes a "note_rejected" notice on ch and waits for the
+// broker to confirm it
+func publishRejected(ch *amqp.Channel, confirms <-chan amqp.Confirmation, rejected rejectedNotes) {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":    "note_rejected",
+		"payload": rejected,
+	})
+	if err == nil {
+		err = ch.Publish(
+			"",                      // Exchange
+			config.SyncToLogicQueue, // Routing key (queue name)
+			false,                   // Mandatory
+			false,                   // Immediate
+			amqp.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp.Persistent,
+				Body:         body,
+			},
+		)
+	}
+	if err == nil {
+		if confirm, ok := <-confirms; !ok || !confirm.Ack {
+			err = errors.New("not confirmed by the broker")
+		}
+	}
+	if err != nil {
+		// The pending entries expire on their own
+		log.Printf("Failed to notify the logic server of rejected notes %v: %v", rejected.NoteIDs, err)
+	}
+}
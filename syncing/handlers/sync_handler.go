@@ -2,165 +2,576 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"pdm-shared/integrity"
+	"pdm-shared/patch"
+	"strconv"
+	"strings"
+	"syncing/config"
 	"syncing/models"
 	"time"
 
 	"github.com/streadway/amqp"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// consumeRetryDelay is how long ConsumeRabbitMQMessages waits after it
+	// failed to start consuming
+	consumeRetryDelay = time.Second
+	// consumePrefetch is how many unacked tasks the broker sends ahead
+	consumePrefetch = 16
 )
 
 type SyncHandler struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	RabbitMQ *config.RabbitMQ
+	Exchange string
+	// RequireVersioned rejects notes whose digests are unversioned
+	RequireVersioned bool
+}
+
+// noteEvent is a saved note change to forward to connected clients
+type noteEvent struct {
+	eventType  string
+	note       models.Notes
+	recipients []string
+	// attachments were deleted with the note, see notifyAttachmentsDeleted
+	attachments []models.NoteAttachment
+}
+
+func NewSyncHandler(db *gorm.DB, rabbitMQ *config.RabbitMQ, exchange string) *SyncHandler {
+	requireVersioned, _ := strconv.ParseBool(os.Getenv("INTEGRITY_REQUIRE_VERSIONED"))
+	return &SyncHandler{DB: db, RabbitMQ: rabbitMQ, Exchange: exchange, RequireVersioned: requireVersioned}
+}
+
+// verifyIntegrity re-checks the digests of a note before it is stored. The
+// logic server verifies them too; this guards against anything else that
+// can publish to the queue.
+func (h *SyncHandler) verifyIntegrity(heading, content, hash, headHash string) error {
+	err := integrity.Verify(heading, content, hash, headHash)
+	if errors.Is(err, integrity.ErrLegacy) && !h.RequireVersioned {
+		return nil
+	}
+	return err
 }
 
-func NewSyncHandler(db *gorm.DB) *SyncHandler {
-	return &SyncHandler{DB: db}
+// publishNoteEvent fans a saved note out to every recipient's WebSocket
+// connections through the notes exchange
+func (h *SyncHandler) publishNoteEvent(event noteEvent) {
+	h.publishToUsers(event.eventType, event.note, event.recipients)
 }
 
-func (h *SyncHandler) ConsumeRabbitMQMessages(ch *amqp.Channel) {
+// publishToUsers sends a typed event to the WebSocket connections of each
+// user through the notes exchange
+func (h *SyncHandler) publishToUsers(eventType string, payload interface{}, userIDs []string) {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":    eventType,
+		"payload": payload,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", eventType, err)
+		return
+	}
+
+	// Events are not kept while reconnecting; clients reload their notes
+	// when they connect again
+	ch, err := h.RabbitMQ.Channel()
+	if err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		err := ch.Publish(
+			h.Exchange,            // Exchange
+			"note_update."+userID, // Routing key
+			false,                 // Mandatory
+			false,                 // Immediate
+			amqp.Publishing{
+				ContentType: "application/json",
+				Body:        body,
+			},
+		)
+		if err != nil {
+			log.Printf("Failed to publish %s event to user %v: %v", eventType, userID, err)
+		}
+	}
+}
+
+// stringSlice converts a decoded JSON array to strings, skipping other values
+func stringSlice(value interface{}) []string {
+	items, _ := value.([]interface{})
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// ConsumeRabbitMQMessages handles the tasks of the logic server. The
+// deliveries stop when the RabbitMQ connection is lost; it consumes again
+// once the connection is restored, and returns when it is closed.
+func (h *SyncHandler) ConsumeRabbitMQMessages() {
+	for h.RabbitMQ.Wait(nil) != nil {
+		ch, err := h.RabbitMQ.NewChannel()
+		if err == nil {
+			err = h.consume(ch)
+			ch.Close()
+		}
+		if err != nil {
+			log.Printf("Failed to start consuming messages: %v", err)
+			time.Sleep(consumeRetryDelay)
+		}
+	}
+}
+
+// consume handles the tasks delivered on ch until it closes. A task is
+// acked once it is committed; see settle for failed ones.
+func (h *SyncHandler) consume(ch *amqp.Channel) error {
+	if err := ch.Qos(consumePrefetch, 0, false); err != nil {
+		return err
+	}
+	// Failed tasks are dead-lettered on ch, and only acked once the broker
+	// confirms the copy
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
 	msgs, err := ch.Consume(
-		"logic_to_sync", // Queue name
-		"",              // Consumer tag
-		true,            // Auto-acknowledge
-		false,           // Exclusive
-		false,           // No-local
-		false,           // No-wait
-		nil,             // Arguments
+		config.LogicToSyncQueue, // Queue name
+		"",                      // Consumer tag
+		false,                   // Auto-acknowledge
+		false,                   // Exclusive
+		false,                   // No-local
+		false,                   // No-wait
+		nil,                     // Arguments
 	)
 	if err != nil {
-		log.Fatalf("Failed to start consuming messages: %v", err)
+		return err
 	}
 
 	log.Println("Sync Server listening for RabbitMQ messages...")
 
 	for msg := range msgs {
-		var message map[string]interface{}
-		if err := json.Unmarshal(msg.Body, &message); err != nil {
-			log.Printf("Invalid message format: %v", err)
-			continue
-		}
+		attempts, err := h.handleWithRetry(msg)
+		h.settle(ch, confirms, msg, attempts, err)
+	}
+	log.Println("Sync Server stopped receiving RabbitMQ messages")
+	return nil
+}
 
-		taskType, _ := message["type"].(string)
-		payload, _ := message["payload"].(map[string]interface{})
+func (h *SyncHandler) handleMessage(msg amqp.Delivery) error {
+	var message map[string]interface{}
+	if err := json.Unmarshal(msg.Body, &message); err != nil {
+		return rejectf("invalid message format: %v", err)
+	}
 
-		switch taskType {
-		case "note_update":
-			h.handleNoteUpdate(payload)
-		case "note_delete":
-			h.handleNoteDelete(payload)
-		case "add_session":
-			h.handleAddSessionKey(payload)
-		case "add_session_refresh":
-			h.handleAddRefreshKey(payload)
-		case "delete_session":
-			h.handleInvalidateSessionKey(payload)
-		default:
-			log.Printf("Unknown task type: %s", taskType)
-		}
+	taskType, _ := message["type"].(string)
+	payload, _ := message["payload"].(map[string]interface{})
+
+	switch taskType {
+	case "note_update":
+		return h.handleNoteUpdate(payload)
+	case "note_patch":
+		return h.handleNotePatch(payload)
+	case "note_delete":
+		return h.handleNoteDelete(payload)
+	case "note_batch":
+		return h.handleNoteBatch(payload)
+	case "reminder_due":
+		return h.handleReminderDue(payload)
+	case "add_session":
+		return h.handleAddSessionKey(payload)
+	case "add_session_refresh":
+		return h.handleAddRefreshKey(payload)
+	case "delete_session":
+		return h.handleInvalidateSessionKey(payload)
+	default:
+		return rejectf("unknown task type %q", taskType)
 	}
 }
 
-func (h *SyncHandler) handleNoteUpdate(payload map[string]interface{}) {
+func (h *SyncHandler) handleNoteUpdate(payload map[string]interface{}) error {
 	// For numeric ID, first assert to float64, then convert to uint
 	noteID, ok := payload["noteid"].(string)
 	if !ok {
-		log.Printf("Invalid note ID for note update: %v", payload)
-		return
+		return rejectf("invalid note ID for note update: %v", payload)
 	}
 
 	// String assertions
 	hash, ok := payload["h"].(string)
 	if !ok {
-		log.Printf("Invalid content hash \"h\" for note update: %v", payload)
-		return
+		return rejectf("invalid content hash \"h\" for note update: %v", payload)
 	}
 
 	headHash, ok := payload["intgrh"].(string)
 	if !ok {
-		log.Printf("Invalid heading hash \"intgrh\" for note update: %v", payload)
-		return
+		return rejectf("invalid heading hash \"intgrh\" for note update: %v", payload)
 	}
 
 	content, ok := payload["content"].(string)
 	if !ok {
-		log.Printf("Invalid content for note update: %v", payload)
-		return
+		return rejectf("invalid content for note update: %v", payload)
 	}
 
 	heading, ok := payload["heading"].(string)
 	if !ok {
-		log.Printf("Invalid heading for note update: %v", payload)
-		return
+		return rejectf("invalid heading for note update: %v", payload)
 	}
 
 	// For deleted flag, first assert to float64, then convert to int
 	deletedFloat, ok := payload["deleted"].(float64)
 	if !ok {
-		log.Printf("Invalid deleted for note update: %v", payload)
-		return
+		return rejectf("invalid deleted for note update: %v", payload)
 	}
 	deleted := int(deletedFloat)
 
 	log.Printf("Received RabbitMQ for note update for %v\n", noteID)
 
+	if err := h.verifyIntegrity(heading, content, hash, headHash); err != nil {
+		return fmt.Errorf("note update for %v: %w", noteID, err)
+	}
+
+	updateTime, ok := updateTimeOf(payload)
+	if !ok {
+		return rejectf("invalid update time for note update: %v", payload)
+	}
+
 	var note models.Notes
-	if err := h.DB.First(&note, "noteid = ?", noteID).Error; err != nil {
-		log.Printf("Note not found: %v", err)
-		return
+	declared, replaceDeclared := declaredLinks(payload)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&note, "noteid = ?", noteID).Error; err != nil {
+			return err
+		}
+		if err := staleWrite(note, updateTime); err != nil {
+			return err
+		}
+
+		note.Content = content
+		note.H = hash
+		note.Heading = heading
+		note.Intgrh = headHash
+		note.Deleted = deleted
+		note.UpdateTime = updateTime
+		if err := tx.Save(&note).Error; err != nil {
+			return err
+		}
+		return updateNoteLinks(tx, note, declared, replaceDeclared)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update note %v: %w", noteID, err)
 	}
+	log.Printf("Note updated successfully: %s", noteID)
 
-	updateTime, ok := payload["update_time"].(float64)
+	recipients := stringSlice(payload["recipients"])
+	if len(recipients) == 0 {
+		recipients = []string{note.UserID}
+	}
+	h.publishNoteEvent(noteEvent{eventType: "note_update", note: note, recipients: recipients})
+	return nil
+}
+
+// handleNotePatch applies a patch to the stored note. The patch is only
+// applied to the content it was made against, and the result must match the
+// digests the logic server checked.
+func (h *SyncHandler) handleNotePatch(payload map[string]interface{}) error {
+	noteID, ok := payload["noteid"].(string)
 	if !ok {
-		log.Printf("Invalid update time for note update: %v", payload)
-		return
+		return rejectf("invalid note ID for note patch: %v", payload)
+	}
+
+	baseHash, _ := payload["base_h"].(string)
+	format, _ := payload["format"].(string)
+	hash, _ := payload["h"].(string)
+	headHash, _ := payload["intgrh"].(string)
+	updateTime, ok := updateTimeOf(payload)
+	if !ok {
+		return rejectf("invalid update time for note patch: %v", payload)
 	}
 
-	note.Content = content
-	note.UpdateTime = time.Now()
-	note.H = hash
-	note.Heading = heading
-	note.Intgrh = headHash
-	note.Deleted = deleted
-	note.UpdateTime = time.Unix(int64(updateTime), 0)
+	raw, err := json.Marshal(payload["patch"])
+	if err != nil {
+		return rejectf("invalid patch for note %v: %v", noteID, err)
+	}
+
+	log.Printf("Received RabbitMQ for note patch for %v (%d bytes)\n", noteID, len(raw))
+
+	var note models.Notes
+	declared, replaceDeclared := declaredLinks(payload)
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&note, "noteid = ?", noteID).Error; err != nil {
+			return err
+		}
+		// A patch older than the stored note was made against content that
+		// has been replaced since
+		if err := staleWrite(note, updateTime); err != nil {
+			return err
+		}
+
+		current, _ := integrity.Compute(note.Heading, note.Content)
+		if !strings.EqualFold(current, baseHash) {
+			return rejectf("base %s does not match stored content %s", baseHash, current)
+		}
+
+		doc, err := patch.Apply(patch.Document{Content: note.Content, Heading: note.Heading, Deleted: note.Deleted}, format, raw)
+		if err != nil {
+			return err
+		}
+		if err := h.verifyIntegrity(doc.Heading, doc.Content, hash, headHash); err != nil {
+			return err
+		}
 
-	if err := h.DB.Save(&note).Error; err != nil {
-		log.Printf("Failed to update note: %v", err)
-	} else {
-		log.Printf("Note updated successfully: %s", noteID)
+		note.Content = doc.Content
+		note.Heading = doc.Heading
+		note.Deleted = doc.Deleted
+		note.H = hash
+		note.Intgrh = headHash
+		note.UpdateTime = updateTime
+		if err := tx.Save(&note).Error; err != nil {
+			return err
+		}
+		return updateNoteLinks(tx, note, declared, replaceDeclared)
+	})
+	if err != nil {
+		return fmt.Errorf("note patch for %v: %w", noteID, err)
 	}
+	log.Printf("Note patched successfully: %s", noteID)
+
+	recipients := stringSlice(payload["recipients"])
+	if len(recipients) == 0 {
+		recipients = []string{note.UserID}
+	}
+	h.publishNoteEvent(noteEvent{eventType: "note_update", note: note, recipients: recipients})
+	return nil
 }
 
-func (h *SyncHandler) handleNoteDelete(payload map[string]interface{}) {
+func (h *SyncHandler) handleNoteDelete(payload map[string]interface{}) error {
 	log.Printf("Started delete note.")
 	noteID, ok := payload["noteid"].(string)
 	if !ok {
-		log.Printf("Invalid note ID for note delete: %v", payload)
-		return
+		return rejectf("invalid note ID for note delete: %v", payload)
 	}
 	log.Printf("Tobe deleted note id: %v", noteID)
 
 	deletePermanently, _ := payload["deletePermanently"].(bool)
 
-	if deletePermanently {
-		err := h.DB.Where("noteid = ? ", noteID).Delete(&models.Notes{}).Error
-		if err != nil {
-			log.Printf("Failed to delete note permanently")
-		}
-	} else {
-		err := h.DB.Model(&models.Notes{}).
+	var attachments []models.NoteAttachment
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		attachments, err = deleteNote(tx, noteID, deletePermanently)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete note %v: %w", noteID, err)
+	}
+	h.notifyAttachmentsDeleted(attachments)
+	return nil
+}
+
+// deleteNote moves a note to the trash, or deletes it for good along with
+// its shares, links and attachments, whose rows it returns
+func deleteNote(tx *gorm.DB, noteID string, deletePermanently bool) ([]models.NoteAttachment, error) {
+	if !deletePermanently {
+		return nil, tx.Model(&models.Notes{}).
 			Where("noteid = ?", noteID).
-			Update("deleted", 0)
-		if err != nil {
-			// Handle the error
-			fmt.Println("Delete note delete = 0 Error:", err)
+			Update("deleted", 0).Error
+	}
+
+	if err := tx.Where("noteid = ?", noteID).Delete(&models.NoteShare{}).Error; err != nil {
+		return nil, err
+	}
+	if err := deleteNoteLinks(tx, noteID); err != nil {
+		return nil, err
+	}
+	attachments, err := deleteNoteAttachments(tx, noteID)
+	if err != nil {
+		return nil, err
+	}
+	return attachments, tx.Where("noteid = ? ", noteID).Delete(&models.Notes{}).Error
+}
+
+// handleNoteBatch applies an ordered list of note operations in a single
+// transaction; if any of them fails, none of them are kept.
+func (h *SyncHandler) handleNoteBatch(payload map[string]interface{}) error {
+	userID, ok := payload["userId"].(string)
+	if !ok {
+		return rejectf("invalid user ID for note batch: %v", payload)
+	}
+
+	operations, ok := payload["operations"].([]interface{})
+	if !ok {
+		return rejectf("invalid operations for note batch: %v", payload)
+	}
+
+	updateTime, ok := updateTimeOf(payload)
+	if !ok {
+		return rejectf("invalid update time for note batch: %v", payload)
+	}
+
+	log.Printf("Received RabbitMQ note batch of %d operations for user %v\n", len(operations), userID)
+
+	var events []noteEvent
+	var skipped []string
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		events, skipped = events[:0], skipped[:0]
+		for i, raw := range operations {
+			op, ok := raw.(map[string]interface{})
+			if !ok {
+				return rejectf("operation %d: invalid format", i)
+			}
+			event, err := h.applyBatchOperation(tx, userID, op, updateTime)
+			var stale *staleTask
+			if errors.As(err, &stale) {
+				log.Printf("Skipped operation %d of note batch for user %v: %v", i, userID, err)
+				skipped = append(skipped, stale.noteIDs...)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply note batch for user %v: %w", userID, err)
+	}
+	log.Printf("Note batch applied successfully for user %v", userID)
+
+	// Only tell clients about the changes once they are committed
+	var attachments []models.NoteAttachment
+	for _, event := range events {
+		h.publishNoteEvent(event)
+		attachments = append(attachments, event.attachments...)
+	}
+	h.notifyAttachmentsDeleted(attachments)
+	if len(skipped) > 0 {
+		return &staleTask{noteIDs: skipped, reason: fmt.Sprintf("notes %v were written after %v", skipped, updateTime)}
+	}
+	return nil
+}
+
+func (h *SyncHandler) applyBatchOperation(tx *gorm.DB, userID string, op map[string]interface{}, updateTime time.Time) (noteEvent, error) {
+	opType, _ := op["op"].(string)
+	noteID, ok := op["noteid"].(string)
+	if !ok || noteID == "" {
+		return noteEvent{}, rejectf("invalid note ID")
+	}
+
+	content, _ := op["content"].(string)
+	heading, _ := op["heading"].(string)
+	hash, _ := op["h"].(string)
+	headHash, _ := op["intgrh"].(string)
+	deletedFloat, _ := op["deleted"].(float64)
+
+	if opType == "create" || opType == "update" {
+		if err := h.verifyIntegrity(heading, content, hash, headHash); err != nil {
+			return noteEvent{}, err
+		}
+	}
+
+	event := noteEvent{
+		eventType:  "note_" + opType,
+		recipients: stringSlice(op["recipients"]),
+	}
+	if len(event.recipients) == 0 {
+		event.recipients = []string{userID}
+	}
+
+	switch opType {
+	case "create":
+		event.note = models.Notes{
+			NoteID:     noteID,
+			UserID:     userID,
+			Content:    content,
+			H:          hash,
+			Intgrh:     headHash,
+			Heading:    heading,
+			Deleted:    int(deletedFloat),
+			Time:       updateTime,
+			UpdateTime: updateTime,
+		}
+		if err := tx.Create(&event.note).Error; err != nil {
+			return event, err
+		}
+		declared, replaceDeclared := declaredLinks(op)
+		return event, updateNoteLinks(tx, event.note, declared, replaceDeclared)
+
+	case "update":
+		// Last writer wins: a note written after this batch keeps its
+		// content. Times compare in whole seconds, see staleWrite.
+		result := tx.Model(&models.Notes{}).
+			Where("noteid = ? AND userid = ? AND update_time < ?", noteID, userID, updateTime.Add(time.Second)).
+			Updates(map[string]interface{}{
+				"content":     content,
+				"h":           hash,
+				"heading":     heading,
+				"intgrh":      headHash,
+				"deleted":     int(deletedFloat),
+				"update_time": updateTime,
+			})
+		if result.Error != nil {
+			return event, result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.First(&event.note, "noteid = ? AND userid = ?", noteID, userID).Error; err != nil {
+				return event, rejectf("note %s not found", noteID)
+			}
+			if err := staleWrite(event.note, updateTime); err != nil {
+				return event, err
+			}
+			return event, rejectf("note %s not updated", noteID)
+		}
+		if err := tx.First(&event.note, "noteid = ?", noteID).Error; err != nil {
+			return event, err
+		}
+		declared, replaceDeclared := declaredLinks(op)
+		return event, updateNoteLinks(tx, event.note, declared, replaceDeclared)
+
+	case "delete":
+		if err := tx.Where("noteid = ? AND userid = ?", noteID, userID).First(&event.note).Error; err != nil {
+			return event, fmt.Errorf("note %s not found: %w", noteID, err)
 		}
+		deletePermanently, _ := op["deletePermanently"].(bool)
+		var err error
+		event.attachments, err = deleteNote(tx, noteID, deletePermanently)
+		return event, err
+
+	default:
+		return event, rejectf("unknown operation %q", opType)
+	}
+}
+
+// handleReminderDue pushes a reminder fired by the logic server's scheduler
+// to the user's connected clients
+func (h *SyncHandler) handleReminderDue(payload map[string]interface{}) error {
+	userID, _ := payload["userid"].(string)
+	if userID == "" {
+		return rejectf("invalid reminder: missing user ID")
 	}
 
+	reminder := map[string]interface{}{
+		"id":      payload["id"],
+		"noteid":  payload["noteid"],
+		"heading": payload["heading"],
+	}
+	if fireAt, ok := payload["fire_at"].(float64); ok {
+		reminder["fire_at"] = time.Unix(int64(fireAt), 0).UTC()
+	}
+
+	h.publishToUsers("reminder", reminder, []string{userID})
+	return nil
 }
 
-func (h *SyncHandler) handleAddRefreshKey(payload map[string]interface{}) {
+func (h *SyncHandler) handleAddRefreshKey(payload map[string]interface{}) error {
 	userID, _ := payload["userId"].(string)
 	//sessionKey, _ := payload["sessionKey"].(string)
 	refreshKey, _ := payload["refreshKey"].(string)
@@ -171,13 +582,13 @@ func (h *SyncHandler) handleAddRefreshKey(payload map[string]interface{}) {
 	}
 
 	if err := h.DB.Create(&session).Error; err != nil {
-		log.Printf("Failed to add session: %v", err)
-	} else {
-		log.Printf("Session added successfully for user: %v", userID)
+		return fmt.Errorf("failed to add session: %w", err)
 	}
+	log.Printf("Session added successfully for user: %v", userID)
+	return nil
 }
 
-func (h *SyncHandler) handleAddSessionKey(payload map[string]interface{}) {
+func (h *SyncHandler) handleAddSessionKey(payload map[string]interface{}) error {
 	userID, _ := payload["userId"].(string)
 	sessionKey, _ := payload["sessionKey"].(string)
 	expiration, _ := payload["expiration"].(float64)
@@ -191,27 +602,26 @@ func (h *SyncHandler) handleAddSessionKey(payload map[string]interface{}) {
 	}
 
 	if err := h.DB.Create(&session).Error; err != nil {
-		log.Printf("Failed to add session: %v", err)
-	} else {
-		log.Printf("Session added successfully for user: %d", userID)
+		return fmt.Errorf("failed to add session: %w", err)
 	}
+	log.Printf("Session added successfully for user: %v", userID)
+	return nil
 }
 
-func (h *SyncHandler) handleInvalidateSessionKey(payload map[string]interface{}) {
+func (h *SyncHandler) handleInvalidateSessionKey(payload map[string]interface{}) error {
 	userID, _ := payload["userId"].(string)
 	sessionKey, _ := payload["sessionKey"].(string)
 
 	if sessionKey == "" {
-		log.Printf("No session key provided for invalidation")
-		return
+		return rejectf("no session key provided for invalidation")
 	}
 
 	// Update the valid field to "0" instead of deleting
 	if err := h.DB.Model(&models.SessionKey{}).
 		Where("user_id = ? AND session_key = ?", userID, sessionKey).
 		Update("valid", "0").Error; err != nil {
-		log.Printf("Failed to invalidate session: %v", err)
-	} else {
-		log.Printf("Session invalidated successfully for user: %v", userID)
+		return fmt.Errorf("failed to invalidate session: %w", err)
 	}
+	log.Printf("Session invalidated successfully for user: %v", userID)
+	return nil
 }
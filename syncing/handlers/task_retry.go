@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"pdm-shared/integrity"
+	"pdm-shared/patch"
+	"strings"
+	"syncing/config"
+	"syncing/models"
+	"time"
+
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+)
+
+// A task that fails is not lost. If it can never succeed, it is malformed,
+// its note is gone or its digests do not match, it goes to the dead-letter
+// queue at once; otherwise, the database being down say, it is tried again
+// in place after a delay that doubles with every attempt, and dead-lettered
+// once the retry policy runs out. Retrying in place holds back the tasks
+// behind it, so the writes to a note are applied in the order they were
+// made. A dead-lettered task is published with the attempts and the reason
+// in its headers, the logic server is told if it was a note write, and the
+// original is acked.
+//
+// Writes carry the time they were made, in whole seconds, and a write older
+// than the stored note is skipped, last writer wins, so a task delivered
+// again cannot undo a newer one. The stored time is compared in whole seconds
+// too; a write in the same second as the stored one is taken as the newer,
+// since tasks are applied in the order they were queued. The logic server is
+// told about a skipped write as about a dead-lettered one, so it stops
+// serving the write from its cache.
+
+// Headers of a dead-lettered task
+const (
+	headerAttempts      = "x-attempts"
+	headerFailureReason = "x-failure-reason"
+	headerFailedAt      = "x-failed-at"
+	headerPermanent     = "x-failure-permanent"
+)
+
+// maxFailureReason is the most bytes of an error kept in headerFailureReason;
+// headers must fit in a frame, and the errors of malformed tasks quote them
+const maxFailureReason = 1024
+
+var (
+	// errRejectedTask is wrapped by the errors of tasks that can never succeed
+	errRejectedTask = errors.New("task rejected")
+	// errStaleTask matches the errors of writes older than the stored notes;
+	// a newer write won, so there is nothing left to do
+	errStaleTask = errors.New("stale task")
+)
+
+// staleTask is the error of a write skipped for some or all of its notes
+// because they were written after it
+type staleTask struct {
+	noteIDs []string
+	reason  string
+}
+
+func (e *staleTask) Error() string {
+	return errStaleTask.Error() + ": " + e.reason
+}
+
+func (e *staleTask) Is(target error) bool {
+	return target == errStaleTask
+}
+
+// updateTimeOf reads the update time of a write from a task payload
+func updateTimeOf(payload map[string]interface{}) (time.Time, bool) {
+	seconds, ok := payload["update_time"].(float64)
+	return time.Unix(int64(seconds), 0), ok
+}
+
+// staleWrite returns a staleTask error if note was written after a write
+// made at updateTime, comparing whole seconds
+func staleWrite(note models.Notes, updateTime time.Time) error {
+	if note.UpdateTime.Truncate(time.Second).After(updateTime) {
+		return &staleTask{
+			noteIDs: []string{note.NoteID},
+			reason:  fmt.Sprintf("note %s was written at %v, after %v", note.NoteID, note.UpdateTime, updateTime),
+		}
+	}
+	return nil
+}
+
+func rejectf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errRejectedTask, fmt.Sprintf(format, args...))
+}
+
+// permanent reports whether a task that failed with err would fail again
+func permanent(err error) bool {
+	for _, target := range []error{
+		errRejectedTask,
+		gorm.ErrRecordNotFound,
+		integrity.ErrLegacy,
+		integrity.ErrUnsupportedVersion,
+		integrity.ErrContentMismatch,
+		integrity.ErrHeadingMismatch,
+		patch.ErrInvalidPatch,
+		patch.ErrTestFailed,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func failureReason(err error) string {
+	reason := err.Error()
+	if len(reason) > maxFailureReason {
+		reason = strings.ToValidUTF8(reason[:maxFailureReason], "") + "..."
+	}
+	return reason
+}
+
+// handleWithRetry handles msg, trying again after a transient failure as
+// the retry policy allows. It returns the number of attempts made and the
+// error of the last one.
+func (h *SyncHandler) handleWithRetry(msg amqp.Delivery) (int, error) {
+	retry := h.RabbitMQ.Retry
+	for attempt := 1; ; attempt++ {
+		err := h.handleMessage(msg)
+		if err == nil || errors.Is(err, errStaleTask) || permanent(err) || attempt >= retry.MaxAttempts {
+			return attempt, err
+		}
+
+		delay := retry.Delay(attempt)
+		log.Printf("Task failed on attempt %d, retrying in %v: %v", attempt, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// settle acks msg if it was handled, after notifying the logic server of
+// the notes it skipped as stale. Otherwise it publishes msg to the
+// dead-letter queue on ch, in confirm mode, and acks it once the broker
+// confirms the copy, after notifying the logic server of a rejected note
+// write. If the copy is not confirmed, msg is requeued.
+func (h *SyncHandler) settle(ch *amqp.Channel, confirms <-chan amqp.Confirmation, msg amqp.Delivery, attempts int, err error) {
+	var stale *staleTask
+	if errors.As(err, &stale) {
+		log.Printf("Skipped stale writes of notes %v: %v", stale.noteIDs, err)
+		h.notifySkipped(ch, confirms, msg, stale)
+		err = nil
+	}
+	if err == nil {
+		if err := msg.Ack(false); err != nil {
+			log.Printf("Failed to ack task: %v", err)
+		}
+		return
+	}
+
+	headers := amqp.Table{
+		headerAttempts:      int32(attempts),
+		headerFailureReason: failureReason(err),
+		headerFailedAt:      time.Now().UTC().Format(time.RFC3339),
+		headerPermanent:     permanent(err),
+	}
+	log.Printf("Task failed after %d attempts, dead-lettering it: %v", attempts, err)
+
+	err = ch.Publish(
+		"",                     // Exchange
+		config.DeadLetterQueue, // Routing key (queue name)
+		false,                  // Mandatory
+		false,                  // Immediate
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    msg.MessageId,
+			Body:         msg.Body,
+		},
+	)
+	if err == nil {
+		if confirm, ok := <-confirms; !ok || !confirm.Ack {
+			err = errors.New("not confirmed by the broker")
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to dead-letter task, requeueing it: %v", err)
+		if err := msg.Nack(false, true); err != nil {
+			log.Printf("Failed to requeue task: %v", err)
+		}
+		return
+	}
+
+	h.notifyRejected(ch, confirms, msg, headers[headerFailureReason].(string))
+	if err := msg.Ack(false); err != nil {
+		log.Printf("Failed to ack task: %v", err)
+	}
+}
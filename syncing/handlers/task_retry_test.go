@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"pdm-shared/integrity"
+	"pdm-shared/patch"
+	"reflect"
+	"strings"
+	"syncing/models"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+func TestPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "rejected", err: rejectf("missing noteid"), want: true},
+		{name: "note gone", err: fmt.Errorf("loading note: %w", gorm.ErrRecordNotFound), want: true},
+		{name: "legacy digest", err: integrity.ErrLegacy, want: true},
+		{name: "unsupported digest", err: integrity.ErrUnsupportedVersion, want: true},
+		{name: "content mismatch", err: fmt.Errorf("note n1: %w", integrity.ErrContentMismatch), want: true},
+		{name: "heading mismatch", err: integrity.ErrHeadingMismatch, want: true},
+		{name: "invalid patch", err: fmt.Errorf("%w: bad op", patch.ErrInvalidPatch), want: true},
+		{name: "patch test failed", err: patch.ErrTestFailed, want: true},
+		{name: "database down", err: errors.New("dial tcp: connection refused")},
+		{name: "invalid transaction", err: fmt.Errorf("saving note: %w", gorm.ErrInvalidTransaction)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := permanent(tt.err); got != tt.want {
+				t.Errorf("permanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleWrite(t *testing.T) {
+	// Stored times keep sub-second precision; writes carry whole seconds
+	stored := time.Date(2026, 1, 2, 3, 4, 5, 700000000, time.UTC)
+	second := stored.Truncate(time.Second)
+	note := models.Notes{NoteID: "n1", UpdateTime: stored}
+
+	tests := []struct {
+		name       string
+		updateTime time.Time
+		wantStale  bool
+	}{
+		{name: "newer write", updateTime: second.Add(time.Second)},
+		{name: "same second", updateTime: second},
+		{name: "older write", updateTime: second.Add(-time.Second), wantStale: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := staleWrite(note, tt.updateTime)
+			if got := errors.Is(err, errStaleTask); got != tt.wantStale {
+				t.Errorf("staleWrite() = %v, want stale %v", err, tt.wantStale)
+			}
+			if err == nil {
+				return
+			}
+			if permanent(err) {
+				t.Errorf("permanent(%v) = true, a stale write is done rather than failed", err)
+			}
+			var stale *staleTask
+			if !errors.As(fmt.Errorf("failed to update note: %w", err), &stale) || !reflect.DeepEqual(stale.noteIDs, []string{"n1"}) {
+				t.Errorf("staleWrite() = %v, want a staleTask for note n1", err)
+			}
+		})
+	}
+}
+
+func TestUpdateTimeOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		want    time.Time
+		wantOK  bool
+	}{
+		{name: "seconds", payload: map[string]interface{}{"update_time": float64(1767323045)}, want: time.Unix(1767323045, 0), wantOK: true},
+		{name: "missing", payload: map[string]interface{}{}},
+		{name: "not a number", payload: map[string]interface{}{"update_time": "2026-01-02T03:04:05Z"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := updateTimeOf(tt.payload)
+			if ok != tt.wantOK || (ok && !got.Equal(tt.want)) {
+				t.Errorf("updateTimeOf() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	long := strings.Repeat("x", maxFailureReason+10)
+	// A multi-byte rune straddling the cut
+	straddling := strings.Repeat("x", maxFailureReason-1) + "é" + "tail"
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "short", err: errors.New("db down"), want: "db down"},
+		{name: "exactly the limit", err: errors.New(long[:maxFailureReason]), want: long[:maxFailureReason]},
+		{name: "truncated", err: errors.New(long), want: long[:maxFailureReason] + "..."},
+		{name: "truncated on a rune boundary", err: errors.New(straddling), want: straddling[:maxFailureReason-1] + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := failureReason(tt.err)
+			if got != tt.want {
+				t.Errorf("failureReason() = %q (%d bytes), want %d bytes", got, len(got), len(tt.want))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("failureReason() is not valid UTF-8")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"syncing/config"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -18,40 +19,115 @@ type ClientConnection struct {
 	consumer string     // Consumer tag for cleanup
 }
 
+// resubscribeDelay is how long a client waits before subscribing again
+// after a failure
+const resubscribeDelay = time.Second
+
 // WebSocketHandler manages WebSocket connections and RabbitMQ integration
 type WebSocketHandler struct {
-	upgrader   websocket.Upgrader
-	clients    map[string][]*ClientConnection
-	clientsMu  sync.RWMutex
-	rabbitChan *amqp.Channel
-	exchange   string
+	upgrader  websocket.Upgrader
+	clients   map[string][]*ClientConnection
+	clientsMu sync.RWMutex
+	rabbitMQ  *config.RabbitMQ
+	exchange  string
 }
 
-func NewWebSocketHandler(rabbitChan *amqp.Channel, exchange string) (*WebSocketHandler, error) {
-	handler := &WebSocketHandler{
+// NewWebSocketHandler returns a handler whose clients receive the events
+// published to exchange, which config.RabbitMQ declares
+func NewWebSocketHandler(rabbitMQ *config.RabbitMQ, exchange string) *WebSocketHandler {
+	return &WebSocketHandler{
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
-		clients:    make(map[string][]*ClientConnection),
-		rabbitChan: rabbitChan,
-		exchange:   exchange,
+		clients:  make(map[string][]*ClientConnection),
+		rabbitMQ: rabbitMQ,
+		exchange: exchange,
 	}
+}
 
-	// Declare the topic exchange
-	err := rabbitChan.ExchangeDeclare(
-		exchange,
-		"topic",
-		true,  // Durable
-		false, // Auto-deleted
-		false, // Internal
+// subscribe declares an exclusive queue for a client, bound to the events of
+// userID, and consumes it. The consumer tag is the queue name.
+func (h *WebSocketHandler) subscribe(ch *amqp.Channel, userID string) (amqp.Queue, <-chan amqp.Delivery, error) {
+	// Create an exclusive queue for this specific client
+	queue, err := ch.QueueDeclare(
+		"",    // Let RabbitMQ generate a unique name
+		false, // Non-durable
+		true,  // Delete when unused
+		true,  // Exclusive
 		false, // No-wait
 		nil,
 	)
 	if err != nil {
-		return nil, err
+		return queue, nil, err
+	}
+
+	// Bind the queue to the exchange with user-specific routing key
+	routingKey := "note_update." + userID
+	err = ch.QueueBind(
+		queue.Name,
+		routingKey,
+		h.exchange,
+		false,
+		nil,
+	)
+	if err != nil {
+		return queue, nil, err
 	}
 
-	return handler, nil
+	// Start consuming messages
+	msgs, err := ch.Consume(
+		queue.Name,
+		queue.Name, // Consumer tag
+		true,       // Auto-ack
+		true,       // Exclusive
+		false,      // No-local
+		false,      // No-wait
+		nil,
+	)
+	return queue, msgs, err
+}
+
+// forwardEvents writes the events of the client's user to its WebSocket
+// until done is closed. Its queue goes away with the RabbitMQ connection,
+// so it subscribes again when the connection is restored.
+func (h *WebSocketHandler) forwardEvents(client *ClientConnection, ch *amqp.Channel, msgs <-chan amqp.Delivery, done <-chan struct{}) {
+	// Cancelling the consumer deletes the queue
+	defer func() {
+		ch.Cancel(client.consumer, false)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg, ok := <-msgs:
+			if ok {
+				// Send directly to this client's WebSocket
+				if err := client.conn.WriteMessage(websocket.TextMessage, msg.Body); err != nil {
+					log.Printf("Error writing to WebSocket: %v", err)
+					return
+				}
+				continue
+			}
+		}
+
+		next := h.rabbitMQ.Wait(done)
+		if next == nil {
+			return
+		}
+		queue, nextMsgs, err := h.subscribe(next, client.userID)
+		if err != nil {
+			log.Printf("Failed to subscribe again for user %s: %v", client.userID, err)
+			select {
+			case <-done:
+				return
+			case <-time.After(resubscribeDelay):
+			}
+			continue
+		}
+		ch, msgs = next, nextMsgs
+		client.queue, client.consumer = queue, queue.Name
+	}
 }
 
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -94,56 +170,24 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 		}
 	}()
 
-	// Create an exclusive queue for this specific client
-	queue, err := h.rabbitChan.QueueDeclare(
-		"",    // Let RabbitMQ generate a unique name
-		false, // Non-durable
-		true,  // Delete when unused
-		true,  // Exclusive
-		false, // No-wait
-		nil,
-	)
-	if err != nil {
-		log.Printf("Failed to declare queue: %v", err)
-		conn.Close()
-		return
-	}
-
-	// Bind the queue to the exchange with user-specific routing key
-	routingKey := "note_update." + userID
-	err = h.rabbitChan.QueueBind(
-		queue.Name,
-		routingKey,
-		h.exchange,
-		false,
-		nil,
-	)
+	ch, err := h.rabbitMQ.Channel()
 	if err != nil {
-		log.Printf("Failed to bind queue: %v", err)
+		log.Printf("Failed to subscribe: %v", err)
 		conn.Close()
 		return
 	}
-
-	// Start consuming messages
-	msgs, err := h.rabbitChan.Consume(
-		queue.Name,
-		"",    // Let RabbitMQ generate a consumer tag
-		true,  // Auto-ack
-		true,  // Exclusive
-		false, // No-local
-		false, // No-wait
-		nil,
-	)
+	queue, msgs, err := h.subscribe(ch, userID)
 	if err != nil {
-		log.Printf("Failed to start consuming: %v", err)
+		log.Printf("Failed to subscribe: %v", err)
 		conn.Close()
 		return
 	}
 
 	client := &ClientConnection{
-		conn:   conn,
-		userID: userID,
-		queue:  queue,
+		conn:     conn,
+		userID:   userID,
+		queue:    queue,
+		consumer: queue.Name,
 	}
 
 	// Register client
@@ -151,15 +195,9 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	defer h.unregisterClient(client)
 
 	// Handle RabbitMQ messages in a goroutine
-	go func() {
-		for msg := range msgs {
-			// Send directly to this client's WebSocket
-			if err := conn.WriteMessage(websocket.TextMessage, msg.Body); err != nil {
-				log.Printf("Error writing to WebSocket: %v", err)
-				return
-			}
-		}
-	}()
+	done := make(chan struct{})
+	defer close(done)
+	go h.forwardEvents(client, ch, msgs, done)
 
 	// Handle WebSocket messages
 	for {
@@ -0,0 +1,53 @@
+// Package links finds wiki-style references between notes. A note links to
+// another by writing its ID in double brackets, optionally with a label:
+//
+//	see [[0b6f3d4e-9a52-4c1e-8d7a-3f1e2b4c5d6a]] or
+//	[[0b6f3d4e-9a52-4c1e-8d7a-3f1e2b4c5d6a|meeting notes]]
+//
+// Only plaintext content can be parsed; clients that encrypt notes declare
+// their links alongside the update instead.
+package links
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxPerNote bounds the links kept for a single note
+const MaxPerNote = 1000
+
+var reference = regexp.MustCompile(`\[\[\s*([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})\s*(?:\|[^\]\n]*)?\]\]`)
+
+// Parse returns the note IDs referenced in content, lowercased, without
+// duplicates and in order of first appearance
+func Parse(content string) []string {
+	if !strings.Contains(content, "[[") {
+		return nil
+	}
+
+	matches := reference.FindAllStringSubmatch(content, -1)
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match[1])
+	}
+	return Normalize(ids)
+}
+
+// Normalize lowercases IDs and drops empty and duplicate ones, keeping at
+// most MaxPerNote
+func Normalize(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" || seen[id] {
+			continue
+		}
+		if len(result) == MaxPerNote {
+			break
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package links
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	idA = "0b6f3d4e-9a52-4c1e-8d7a-3f1e2b4c5d6a"
+	idB = "7c1d2e3f-4a5b-4c6d-8e7f-901a2b3c4d5e"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "no links", content: "just text"},
+		{name: "one link", content: "see [[" + idA + "]]", want: []string{idA}},
+		{name: "label", content: "[[" + idA + "|meeting notes]]", want: []string{idA}},
+		{name: "spaces inside brackets", content: "[[ " + idA + " |x]]", want: []string{idA}},
+		{name: "upper case", content: "[[" + strings.ToUpper(idA) + "]]", want: []string{idA}},
+		{name: "order of first appearance", content: "[[" + idB + "]] [[" + idA + "]] [[" + idB + "]]", want: []string{idB, idA}},
+		{name: "not an ID", content: "[[some page]]"},
+		{name: "single brackets", content: "[" + idA + "]"},
+		{name: "unclosed", content: "[[" + idA},
+		{name: "label across lines", content: "[[" + idA + "|a\nb]]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.content)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	many := make([]string, MaxPerNote+5)
+	for i := range many {
+		many[i] = fmt.Sprintf("id-%d", i)
+	}
+
+	tests := []struct {
+		name    string
+		ids     []string
+		want    []string
+		wantLen int
+	}{
+		{name: "empty", ids: nil, want: []string{}},
+		{name: "trims and lowercases", ids: []string{"  ABC ", "def"}, want: []string{"abc", "def"}},
+		{name: "drops empty and duplicates", ids: []string{"a", "", " ", "A", "b", "a"}, want: []string{"a", "b"}},
+		{name: "keeps at most MaxPerNote", ids: many, wantLen: MaxPerNote},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Normalize(tt.ids)
+			if tt.want == nil {
+				if len(got) != tt.wantLen {
+					t.Errorf("len(Normalize()) = %d, want %d", len(got), tt.wantLen)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Normalize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -21,24 +21,19 @@ func main() {
 	}
 	defer config.CloseDB(db)
 
-	// Initialize RabbitMQ connection
-	rabbitMQ, ch, err := config.InitRabbitMQ()
+	// Initialize RabbitMQ connection, which is restored when lost
+	rabbitMQ, err := config.InitRabbitMQ()
 	if err != nil {
 		log.Fatalf("Error initializing RabbitMQ: %v", err)
 	}
 	defer rabbitMQ.Close()
-	defer ch.Close()
 
-	syncHandler := handlers.NewSyncHandler(db)
+	wsHandler := handlers.NewWebSocketHandler(rabbitMQ, config.NotesExchange)
 
-	// Start RabbitMQ consumer goroutine
-	go syncHandler.ConsumeRabbitMQMessages(ch)
+	syncHandler := handlers.NewSyncHandler(db, rabbitMQ, config.NotesExchange)
 
-	// Create WebSocket handler
-	wsHandler, err := handlers.NewWebSocketHandler(ch, "notes_exchange")
-	if err != nil {
-		log.Fatal(err)
-	}
+	// Start RabbitMQ consumer goroutine
+	go syncHandler.ConsumeRabbitMQMessages()
 
 	// Set up HTTP route
 	http.HandleFunc("/ws", wsHandler.HandleWebSocket)
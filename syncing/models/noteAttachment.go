@@ -0,0 +1,17 @@
+package models
+
+// NoteAttachment is a file attached to a note. The logic server uploads and
+// serves attachments and owns their table; the sync server only deletes
+// them along with their note.
+type NoteAttachment struct {
+	ID         string `gorm:"primaryKey;type:uuid" json:"id"`
+	NoteID     string `gorm:"column:noteid;type:uuid;not null;index" json:"noteid"`
+	UserID     string `gorm:"column:userid;type:uuid;not null;index" json:"userid"`
+	Size       int64  `gorm:"column:size;not null" json:"size"`
+	StorageKey string `gorm:"column:storage_key;not null" json:"-"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteAttachment) TableName() string {
+	return "note_attachment"
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// Link origins
+const (
+	LinkParsed   = "parsed"
+	LinkDeclared = "declared"
+)
+
+// NoteLink is a reference from one note to another, found in its content or
+// declared by the client that saved it
+type NoteLink struct {
+	SourceID  string    `gorm:"primaryKey;column:source_id;type:uuid" json:"sourceId"`
+	TargetID  string    `gorm:"primaryKey;column:target_id;type:uuid;index" json:"targetId"`
+	UserID    string    `gorm:"column:userid;type:uuid;not null;index" json:"userid"`
+	Origin    string    `gorm:"column:origin;type:varchar(8);not null" json:"origin"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteLink) TableName() string {
+	return "note_link"
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+type NoteShare struct {
+	ID           string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	NoteID       string    `gorm:"column:noteid;type:uuid;not null" json:"noteid"`
+	OwnerID      string    `gorm:"column:owner_id;type:uuid;not null" json:"ownerId"`
+	SharedWithID string    `gorm:"column:shared_with_id;type:uuid;not null" json:"sharedWithId"`
+	Permission   string    `gorm:"column:permission;type:varchar(8);not null" json:"permission"`
+	KeyEnvelope  string    `gorm:"column:key_envelope;not null" json:"keyEnvelope"`
+	CreatedAt    time.Time `gorm:"column:created_at;type:timestamptz;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// TableName overrides the default table name for GORM
+func (NoteShare) TableName() string {
+	return "note_share"
+}